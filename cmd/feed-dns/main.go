@@ -2,13 +2,20 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
 
 	"os"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/sky-uk/feed/controller"
 	"github.com/sky-uk/feed/dns"
+	"github.com/sky-uk/feed/dns/rfc2136"
+	"github.com/sky-uk/feed/dns/zonefile"
 	"github.com/sky-uk/feed/elb"
+	"github.com/sky-uk/feed/leader"
 	"github.com/sky-uk/feed/util/cmd"
 )
 
@@ -22,20 +29,64 @@ var (
 	healthPort     int
 	elbLabelValue  string
 	elbRegion      string
-	r53HostedZone  string
+	elbFromStatus  bool
+	elbZoneID      string
+	r53HostedZones string
+	r53ZoneDomains string
+	r53SyncPoll    int
+	r53WaitForSync bool
+	r53MaxDeletion int
+	r53DualStack   bool
+	r53SetID       string
+	r53Weight      int64
+	r53Failover    string
+	r53HealthCheck bool
+	r53HealthPort  int
+	r53GracePeriod int
+	dnsProvider    string
+	dnsTTL         int
+	internalAddrs  string
+	externalAddrs  string
+	rfc2136Server  string
+	rfc2136Zone    string
+	tsigKeyName    string
+	tsigSecretFile string
+	tsigAlgorithm  string
+	zoneFilePath   string
+	zoneFileZone   string
+	zoneFileNS     string
+	zoneFileAdmin  string
+	leaderElection bool
+	leaderNS       string
+	leaderName     string
+	leaderID       string
+	leaderLease    int
+	leaderRetry    int
 )
 
 func init() {
 	const (
-		defaultAPIServer      = "https://kubernetes:443"
-		defaultCaCertFile     = "/run/secrets/kubernetes.io/serviceaccount/ca.crt"
-		defaultTokenFile      = "/run/secrets/kubernetes.io/serviceaccount/token"
-		defaultClientCertFile = ""
-		defaultClientKeyFile  = ""
-		defaultHealthPort     = 12082
-		defaultElbRegion      = "eu-west-1"
-		defaultElbLabelValue  = ""
-		defaultHostedZone     = ""
+		defaultAPIServer       = "https://kubernetes:443"
+		defaultCaCertFile      = "/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+		defaultTokenFile       = "/run/secrets/kubernetes.io/serviceaccount/token"
+		defaultClientCertFile  = ""
+		defaultClientKeyFile   = ""
+		defaultHealthPort      = 12082
+		defaultElbRegion       = "eu-west-1"
+		defaultElbLabelValue   = ""
+		defaultHostedZone      = ""
+		defaultZoneDomains     = ""
+		defaultSyncPoll        = 10
+		defaultMaxDeletion     = 50
+		defaultWeight          = 1
+		defaultHealthCheckPort = 80
+		defaultDNSProvider     = dns.Route53Provider
+		defaultDNSTTL          = 300
+		defaultTSIGAlgorithm   = rfc2136.HmacSHA256
+		defaultLeaderNS        = "kube-system"
+		defaultLeaderName      = "feed-dns-leader"
+		defaultLeaderLease     = 15
+		defaultLeaderRetry     = 5
 	)
 
 	flag.StringVar(&apiServer, "apiserver", defaultAPIServer,
@@ -56,8 +107,92 @@ func init() {
 		"AWS region for ELBs.")
 	flag.StringVar(&elbLabelValue, "elb-label-value", defaultElbLabelValue,
 		"Alias to ELBs tagged with "+elb.ElbTag+"=value. Leave empty to not attach.")
-	flag.StringVar(&r53HostedZone, "r53-hosted-zone", defaultHostedZone,
-		"Route53 Hosted zone to manage.")
+	flag.BoolVar(&elbFromStatus, "elb-from-ingress-status", false,
+		"Alias to the ELB hostname in each ingress's status, as published by feed-ingress with "+
+			"-update-ingress-status, instead of looking up ELBs by -elb-label-value. "+
+			"Doesn't need permission to describe ELBs.")
+	flag.StringVar(&elbZoneID, "elb-hosted-zone-id", "",
+		"Canonical hosted zone ID of the ELBs, for -elb-from-ingress-status. Defaults to the ID for -elb-region.")
+	flag.StringVar(&r53HostedZones, "r53-hosted-zone", defaultHostedZone,
+		"Comma separated list of Route53 hosted zone IDs to manage. Each ingress host is managed in the zone "+
+			"with the longest matching domain.")
+	flag.StringVar(&r53ZoneDomains, "r53-hosted-zone-domains", defaultZoneDomains,
+		"Comma separated list of domains whose Route53 hosted zones, public and private, should be managed. "+
+			"Where a public and private zone have the same domain, internal ingresses are managed in the private "+
+			"zone and internet-facing ingresses in the public zone.")
+	flag.IntVar(&r53SyncPoll, "r53-sync-poll-seconds", defaultSyncPoll,
+		"Interval in seconds for checking whether submitted Route53 changes are in sync. Set to 0 to disable.")
+	flag.BoolVar(&r53WaitForSync, "r53-wait-for-sync", false,
+		"Report feed-dns as unhealthy until all submitted Route53 changes are in sync.")
+	flag.IntVar(&r53MaxDeletion, "r53-max-deletion-percent", defaultMaxDeletion,
+		"Refuse to delete more than this percentage of a hosted zone's A records in a single update, "+
+			"to protect against mass deletion if ingresses are temporarily missing. Only applies once an update "+
+			fmt.Sprintf("deletes at least %d records. Set to 0 to disable.", dns.MinMassDeletion))
+	flag.BoolVar(&r53DualStack, "r53-dualstack", false,
+		"Create AAAA alias records to the dualstack ELB name, in addition to A records, so services can be "+
+			"resolved by IPv6 clients. Can be overridden per ingress with the sky.uk/dns-dualstack annotation.")
+	flag.StringVar(&r53SetID, "r53-set-identifier", "",
+		"Identifies this cluster's records in weighted or failover record sets, for hosts served by multiple "+
+			"clusters. Only records with this identifier are managed. Leave empty for simple routing.")
+	flag.Int64Var(&r53Weight, "r53-weight", defaultWeight,
+		"Weight of this cluster's records in weighted record sets. Can be overridden per ingress with the "+
+			"sky.uk/dns-weight annotation. Requires r53-set-identifier.")
+	flag.StringVar(&r53Failover, "r53-failover", "",
+		"PRIMARY or SECONDARY to use failover record sets instead of weighted. Can be overridden per ingress "+
+			"with the sky.uk/dns-failover annotation. Requires r53-set-identifier.")
+	flag.BoolVar(&r53HealthCheck, "r53-health-checks", false,
+		"Create a Route53 HTTP health check for each ingress host, and associate it with the host's records. "+
+			"The path checked is the ingress path, or the sky.uk/dns-health-check-path annotation if set. "+
+			"Internal ingresses and private hosted zones aren't checked, as Route53 can't reach them.")
+	flag.IntVar(&r53HealthPort, "r53-health-check-port", defaultHealthCheckPort,
+		"Port that Route53 health checks connect to.")
+	flag.IntVar(&r53GracePeriod, "r53-deletion-grace-seconds", 0,
+		"Keep Route53 records of removed hosts for this many seconds before deleting them, so hosts that are "+
+			"quickly recreated, such as during a redeploy, stay resolvable. Pending deletions are stored in a "+
+			"TXT record in each hosted zone. Set to 0 to delete immediately.")
+	flag.StringVar(&dnsProvider, "dns-provider", defaultDNSProvider,
+		"DNS backend to manage records in: "+dns.Route53Provider+" to alias ELBs in Route53 hosted zones, or "+
+			dns.RFC2136Provider+" to point records at the frontend addresses with dynamic updates to a DNS server, "+
+			"or "+dns.ZoneFileProvider+" to write records pointing at the frontend addresses to a zone file.")
+	flag.IntVar(&dnsTTL, "dns-ttl", defaultDNSTTL,
+		"TTL in seconds of records pointing at frontend addresses.")
+	flag.StringVar(&internalAddrs, "frontend-internal", "",
+		"Comma separated list of IP addresses, or a single hostname, of the frontend for internal ingresses. "+
+			"Used by providers without ELB aliases.")
+	flag.StringVar(&externalAddrs, "frontend-internet-facing", "",
+		"Comma separated list of IP addresses, or a single hostname, of the frontend for internet-facing "+
+			"ingresses. Used by providers without ELB aliases.")
+	flag.StringVar(&rfc2136Server, "rfc2136-server", "",
+		"host:port of the primary DNS server to send dynamic updates to.")
+	flag.StringVar(&rfc2136Zone, "rfc2136-zone", "",
+		"Domain of the zone to update on the DNS server.")
+	flag.StringVar(&tsigKeyName, "rfc2136-tsig-key-name", "",
+		"Name of the TSIG key to sign updates with. Leave empty to send unsigned updates.")
+	flag.StringVar(&tsigSecretFile, "rfc2136-tsig-secret-file", "",
+		"File containing the base64 encoded TSIG secret.")
+	flag.StringVar(&tsigAlgorithm, "rfc2136-tsig-algorithm", defaultTSIGAlgorithm,
+		"TSIG algorithm: hmac-md5, hmac-sha1, hmac-sha256, or hmac-sha512.")
+	flag.StringVar(&zoneFilePath, "zonefile-path", "",
+		"Path of the zone file to write. It's replaced atomically whenever records change.")
+	flag.StringVar(&zoneFileZone, "zonefile-zone", "",
+		"Domain of the zone to write.")
+	flag.StringVar(&zoneFileNS, "zonefile-nameservers", "",
+		"Comma separated list of nameservers for the zone's NS records. The first is the primary in the SOA record. "+
+			"Defaults to ns.<zone>.")
+	flag.StringVar(&zoneFileAdmin, "zonefile-hostmaster", "",
+		"Email address of the zone administrator for the SOA record. Defaults to hostmaster@<zone>.")
+	flag.BoolVar(&leaderElection, "leader-election", false,
+		"Elect a leader among feed-dns replicas, so only one updates DNS. The others are standbys.")
+	flag.StringVar(&leaderNS, "leader-election-namespace", defaultLeaderNS,
+		"Namespace of the config map holding the leader lease.")
+	flag.StringVar(&leaderName, "leader-election-config-map", defaultLeaderName,
+		"Name of the config map holding the leader lease. It's created if it doesn't exist.")
+	flag.StringVar(&leaderID, "leader-election-id", "",
+		"Identity of this replica in leader election. Defaults to the hostname, which is the pod name.")
+	flag.IntVar(&leaderLease, "leader-election-lease-seconds", defaultLeaderLease,
+		"How long a leader lease lasts without being renewed, before a standby takes over.")
+	flag.IntVar(&leaderRetry, "leader-election-retry-seconds", defaultLeaderRetry,
+		"How often the leader renews its lease, and standbys check it.")
 }
 
 func main() {
@@ -66,11 +201,58 @@ func main() {
 	validateConfig()
 
 	client := cmd.CreateK8sClient(caCertFile, tokenFile, apiServer, clientCertFile, clientKeyFile)
-	dnsUpdater := dns.New(r53HostedZone, elbRegion, elbLabelValue)
+	dnsUpdater := dns.New(dns.Config{
+		Provider:                   dnsProvider,
+		HostedZones:                splitList(r53HostedZones),
+		HostedZoneDomains:          splitList(r53ZoneDomains),
+		ElbRegion:                  elbRegion,
+		ElbLabelValue:              elbLabelValue,
+		FrontendsFromIngressStatus: elbFromStatus,
+		ElbHostedZoneID:            elbZoneID,
+		SyncPollInterval:           time.Second * time.Duration(r53SyncPoll),
+		WaitForSync:                r53WaitForSync,
+		MaxDeletionPercent:         r53MaxDeletion,
+		SetIdentifier:              r53SetID,
+		HealthChecks:               r53HealthCheck,
+		HealthCheckPort:            r53HealthPort,
+		DeletionGracePeriod:        time.Second * time.Duration(r53GracePeriod),
+		FrontendAddresses:          frontendAddresses(),
+		TTL:                        dnsTTL,
+		RFC2136: rfc2136.Conf{
+			Server:        rfc2136Server,
+			Zone:          rfc2136Zone,
+			TSIGKeyName:   tsigKeyName,
+			TSIGSecret:    readTSIGSecret(),
+			TSIGAlgorithm: tsigAlgorithm,
+		},
+		ZoneFile: zonefile.Conf{
+			Path:        zoneFilePath,
+			Zone:        zoneFileZone,
+			Nameservers: splitList(zoneFileNS),
+			Hostmaster:  zoneFileAdmin,
+		},
+	})
+
+	if leaderElection {
+		dnsUpdater = leader.New(leader.Config{
+			KubernetesClient: client,
+			Namespace:        leaderNS,
+			Name:             leaderName,
+			Identity:         leaderIdentity(),
+			LeaseDuration:    time.Second * time.Duration(leaderLease),
+			RetryPeriod:      time.Second * time.Duration(leaderRetry),
+			Updater:          dnsUpdater,
+		})
+	}
 
 	controller := controller.New(controller.Config{
 		KubernetesClient: client,
 		Updaters:         []controller.Updater{dnsUpdater},
+		DefaultDualStack: r53DualStack,
+		DefaultDNSRouting: controller.DNSRouting{
+			Weight:   r53Weight,
+			Failover: r53Failover,
+		},
 	})
 
 	cmd.AddHealthPort(dnsUpdater, healthPort)
@@ -82,18 +264,131 @@ func main() {
 		os.Exit(-1)
 	}
 
-	err = dnsUpdater.Start()
+	select {}
+}
+
+func leaderIdentity() string {
+	if leaderID != "" {
+		return leaderID
+	}
+	hostname, err := os.Hostname()
 	if err != nil {
-		log.Error("Error while starting updater: ", err)
+		log.Error("Unable to get hostname for leader-election-id: ", err)
 		os.Exit(-1)
 	}
-
-	select {}
+	return hostname
 }
 
 func validateConfig() {
-	if r53HostedZone == "" {
-		log.Error("Must supply r53-hosted-zone")
+	switch dnsProvider {
+	case dns.Route53Provider:
+		if r53HostedZones == "" && r53ZoneDomains == "" {
+			log.Error("Must supply r53-hosted-zone or r53-hosted-zone-domains")
+			os.Exit(-1)
+		}
+	case dns.RFC2136Provider:
+		if rfc2136Server == "" || rfc2136Zone == "" {
+			log.Error("Must supply rfc2136-server and rfc2136-zone")
+			os.Exit(-1)
+		}
+		if tsigKeyName != "" && tsigSecretFile == "" {
+			log.Error("Must supply rfc2136-tsig-secret-file to use rfc2136-tsig-key-name")
+			os.Exit(-1)
+		}
+		validateFrontendConfig()
+	case dns.ZoneFileProvider:
+		if zoneFilePath == "" || zoneFileZone == "" {
+			log.Error("Must supply zonefile-path and zonefile-zone")
+			os.Exit(-1)
+		}
+		validateFrontendConfig()
+	default:
+		log.Errorf("dns-provider must be %s, %s or %s", dns.Route53Provider, dns.RFC2136Provider,
+			dns.ZoneFileProvider)
+		os.Exit(-1)
+	}
+	if elbFromStatus && dnsProvider != dns.Route53Provider {
+		log.Errorf("elb-from-ingress-status is only supported by the %s provider", dns.Route53Provider)
+		os.Exit(-1)
+	}
+	if r53HealthPort < 1 || r53HealthPort > 65535 {
+		log.Error("r53-health-check-port must be a valid port")
+		os.Exit(-1)
+	}
+	if r53GracePeriod < 0 {
+		log.Error("r53-deletion-grace-seconds must not be negative")
+		os.Exit(-1)
+	}
+	if r53WaitForSync && r53SyncPoll <= 0 {
+		log.Error("Must supply a positive r53-sync-poll-seconds to use r53-wait-for-sync")
+		os.Exit(-1)
+	}
+	if r53Weight < 0 || r53Weight > controller.MaxDNSWeight {
+		log.Errorf("r53-weight must be between 0 and %d", controller.MaxDNSWeight)
+		os.Exit(-1)
+	}
+	if r53Failover != "" && r53Failover != controller.FailoverPrimary && r53Failover != controller.FailoverSecondary {
+		log.Errorf("r53-failover must be %s or %s", controller.FailoverPrimary, controller.FailoverSecondary)
 		os.Exit(-1)
 	}
+	if r53SetID == "" && (isFlagSet("r53-weight") || r53Failover != "") {
+		log.Error("Must supply r53-set-identifier to use r53-weight or r53-failover")
+		os.Exit(-1)
+	}
+	if leaderElection && (leaderRetry <= 0 || leaderLease <= leaderRetry) {
+		log.Error("leader-election-retry-seconds must be positive and less than leader-election-lease-seconds")
+		os.Exit(-1)
+	}
+}
+
+// isFlagSet returns true if the flag was given on the command line, even if set to its default.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+func validateFrontendConfig() {
+	if internalAddrs == "" && externalAddrs == "" {
+		log.Error("Must supply frontend-internal or frontend-internet-facing")
+		os.Exit(-1)
+	}
+	if dnsTTL <= 0 {
+		log.Error("dns-ttl must be positive")
+		os.Exit(-1)
+	}
+}
+
+func frontendAddresses() map[string][]string {
+	addresses := make(map[string][]string)
+	if internalAddrs != "" {
+		addresses["internal"] = splitList(internalAddrs)
+	}
+	if externalAddrs != "" {
+		addresses["internet-facing"] = splitList(externalAddrs)
+	}
+	return addresses
+}
+
+func readTSIGSecret() string {
+	if tsigSecretFile == "" {
+		return ""
+	}
+	secret, err := ioutil.ReadFile(tsigSecretFile)
+	if err != nil {
+		log.Errorf("Unable to read rfc2136-tsig-secret-file: %v", err)
+		os.Exit(-1)
+	}
+	return strings.TrimSpace(string(secret))
+}
+
+func splitList(list string) []string {
+	if list == "" {
+		return nil
+	}
+	return strings.Split(list, ",")
 }
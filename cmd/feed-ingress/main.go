@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"io/ioutil"
 	"os"
 
 	_ "net/http/pprof"
@@ -14,7 +15,9 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sky-uk/feed/controller"
 	"github.com/sky-uk/feed/elb"
+	"github.com/sky-uk/feed/k8s"
 	"github.com/sky-uk/feed/nginx"
+	"github.com/sky-uk/feed/status"
 	"github.com/sky-uk/feed/util"
 	"github.com/sky-uk/feed/util/cmd"
 )
@@ -37,15 +40,30 @@ var (
 	nginxKeepAliveSeconds        int
 	nginxBackendKeepalives       int
 	nginxBackendKeepaliveSeconds int
+	nginxBackendConnectTimeout   int
+	nginxWebSocketTimeout        int
+	nginxRateLimitZoneMB         int
+	nginxRateLimitStatus         int
+	nginxMaxConnectionsPerIP     int
+	nginxHTTP2                   bool
 	nginxLogLevel                string
 	nginxTrustedFrontends        string
+	nginxResolvers               string
 	elbLabelValue                string
 	elbRegion                    string
 	elbExpectedNumber            int
+	updateIngressStatus          bool
+	watchSecrets                 bool
+	secretsPollSeconds           int
+	internalAddrs                string
+	externalAddrs                string
 	pushgatewayURL               string
 	pushgatewayIntervalSeconds   int
 )
 
+// resolvConf is read for the default nginx resolvers.
+const resolvConf = "/etc/resolv.conf"
+
 var unhealthyCounter = prometheus.NewCounter(prometheus.CounterOpts{
 	Namespace: util.PrometheusNamespace,
 	Subsystem: util.PrometheusIngressSubsystem,
@@ -71,6 +89,10 @@ func init() {
 		defaultNginxKeepAliveSeconds        = 60
 		defaultNginxBackendKeepalives       = 512
 		defaultNginxBackendKeepaliveSeconds = 60
+		defaultNginxBackendConnectTimeout   = 10
+		defaultNginxWebSocketTimeout        = 3600
+		defaultNginxRateLimitZoneMB         = 10
+		defaultNginxRateLimitStatus         = 429
 		defaultNginxLogLevel                = "info"
 		defaultElbLabelValue                = ""
 		defaultElbRegion                    = "eu-west-1"
@@ -116,12 +138,33 @@ func init() {
 	flag.IntVar(&nginxBackendKeepaliveSeconds, "nginx-backend-keepalive-seconds", defaultNginxBackendKeepaliveSeconds,
 		"Time to keep backend keepalive connections open. This should generally be set smaller than backend service keepalive "+
 			"times to prevent stale connections.")
+	flag.IntVar(&nginxBackendConnectTimeout, "nginx-backend-connect-timeout-seconds", defaultNginxBackendConnectTimeout,
+		"Timeout for connecting to backend services. This is overridden by the sky.uk/proxy-connect-timeout "+
+			"annotation on ingress resources. Read and send timeouts default to nginx-backend-keepalive-seconds, "+
+			"and are overridden by the sky.uk/proxy-read-timeout and sky.uk/proxy-send-timeout annotations.")
+	flag.IntVar(&nginxWebSocketTimeout, "nginx-websocket-timeout-seconds", defaultNginxWebSocketTimeout,
+		"Default read and send timeouts for ingresses with the sky.uk/websocket annotation, so idle WebSocket "+
+			"connections stay open. This is overridden by the proxy timeout annotations.")
+	flag.IntVar(&nginxRateLimitZoneMB, "nginx-rate-limit-zone-mb", defaultNginxRateLimitZoneMB,
+		"Size in megabytes of the shared memory zone tracking clients of each ingress with a sky.uk/rate-limit-rps "+
+			"annotation. One megabyte holds about 16 thousand client IPs.")
+	flag.IntVar(&nginxRateLimitStatus, "nginx-rate-limit-status", defaultNginxRateLimitStatus,
+		"Status code of requests rejected for exceeding a rate or connection limit.")
+	flag.BoolVar(&nginxHTTP2, "nginx-http2", false,
+		"Accept HTTP/2 on the ingress port, which is needed by ingresses with gRPC backends. Clients must then use "+
+			"HTTP/2, so the ELBs need TCP rather than HTTP listeners.")
+	flag.IntVar(&nginxMaxConnectionsPerIP, "nginx-max-connections-per-ip", 0,
+		"Maximum concurrent connections from each client IP to ingresses. The client IP is only correct if "+
+			"nginx-trusted-frontends is set. Leave as 0 to not limit connections.")
 	flag.StringVar(&nginxLogLevel, "nginx-loglevel", defaultNginxLogLevel,
 		"Log level for nginx. See http://nginx.org/en/docs/ngx_core_module.html#error_log for levels.")
 	flag.StringVar(&nginxTrustedFrontends, "nginx-trusted-frontends", "",
 		"Comma separated list of CIDRs to trust when determining the client's real IP from the "+
 			"X-Forwarded-For header. The client IP is used for allowing or denying ingress access. "+
 			"This will typically be the ELB subnet.")
+	flag.StringVar(&nginxResolvers, "nginx-resolvers", "",
+		"Comma separated list of DNS servers nginx uses to resolve the hosts of sky.uk/auth-url services as "+
+			"requests arrive. Defaults to the nameservers in "+resolvConf+".")
 	flag.StringVar(&elbLabelValue, "elb-label-value", defaultElbLabelValue,
 		"Attach to ELBs tagged with "+elb.ElbTag+"=value. Leave empty to not attach.")
 	flag.IntVar(&elbExpectedNumber, "elb-expected-number", defaultElbExpectedNumber,
@@ -129,6 +172,19 @@ func init() {
 			" otherwise it fails to start if it can't attach to this number.")
 	flag.StringVar(&elbRegion, "elb-region", defaultElbRegion,
 		"AWS region for ELBs.")
+	flag.BoolVar(&updateIngressStatus, "update-ingress-status", false,
+		"Publish the frontend addresses in the status of each ingress, by its sky.uk/frontend-elb-scheme "+
+			"annotation. Uses the DNS names of the ELBs found with -elb-label-value, unless overridden "+
+			"by -frontend-internal or -frontend-internet-facing.")
+	flag.BoolVar(&watchSecrets, "watch-secrets", false,
+		"Read the secrets named by the sky.uk/auth-secret and sky.uk/backend-tls-secret annotations, so "+
+			"ingresses can use them. Requires permission to get those secrets.")
+	flag.IntVar(&secretsPollSeconds, "secrets-poll-seconds", int(controller.DefaultSecretsPollInterval.Seconds()),
+		"Interval in seconds for checking the secrets read with -watch-secrets for changes.")
+	flag.StringVar(&internalAddrs, "frontend-internal", "",
+		"Comma separated hostnames or IPs to publish in the status of internal ingresses.")
+	flag.StringVar(&externalAddrs, "frontend-internet-facing", "",
+		"Comma separated hostnames or IPs to publish in the status of internet-facing ingresses.")
 	flag.StringVar(&pushgatewayURL, "pushgateway", "",
 		"Prometheus pushgateway URL for pushing metrics. Leave blank to not push metrics.")
 	flag.IntVar(&pushgatewayIntervalSeconds, "pushgateway-interval", defaultPushgatewayIntervalSeconds,
@@ -140,14 +196,17 @@ func init() {
 func main() {
 	flag.Parse()
 	cmd.ConfigureLogging(debug)
+	validateConfig()
 
 	client := cmd.CreateK8sClient(caCertFile, tokenFile, apiserverURL, clientCertFile, clientKeyFile)
-	updaters := createIngressUpdaters()
+	updaters := createIngressUpdaters(client)
 
 	controller := controller.New(controller.Config{
-		KubernetesClient: client,
-		Updaters:         updaters,
-		DefaultAllow:     ingressAllow,
+		KubernetesClient:    client,
+		Updaters:            updaters,
+		DefaultAllow:        ingressAllow,
+		WatchSecrets:        watchSecrets,
+		SecretsPollInterval: time.Second * time.Duration(secretsPollSeconds),
 	})
 
 	cmd.AddHealthPort(controller, healthPort)
@@ -166,23 +225,101 @@ func main() {
 	select {}
 }
 
-func createIngressUpdaters() []controller.Updater {
+func validateConfig() {
+	if nginxWebSocketTimeout <= 0 {
+		log.Error("nginx-websocket-timeout-seconds must be positive")
+		os.Exit(-1)
+	}
+	if nginxRateLimitZoneMB <= 0 {
+		log.Error("nginx-rate-limit-zone-mb must be positive")
+		os.Exit(-1)
+	}
+	if nginxRateLimitStatus < 400 || nginxRateLimitStatus > 599 {
+		log.Error("nginx-rate-limit-status must be between 400 and 599")
+		os.Exit(-1)
+	}
+	if nginxMaxConnectionsPerIP < 0 {
+		log.Error("nginx-max-connections-per-ip must not be negative")
+		os.Exit(-1)
+	}
+	if secretsPollSeconds <= 0 {
+		log.Error("secrets-poll-seconds must be positive")
+		os.Exit(-1)
+	}
+}
+
+func createIngressUpdaters(client k8s.Client) []controller.Updater {
 	frontend := elb.New(elbRegion, elbLabelValue, elbExpectedNumber)
 	trustedFrontends := []string{}
 	if nginxTrustedFrontends != "" {
 		trustedFrontends = strings.Split(nginxTrustedFrontends, ",")
 	}
+	resolvers := nameservers(resolvConf)
+	if nginxResolvers != "" {
+		resolvers = strings.Split(nginxResolvers, ",")
+	}
 	proxy := nginx.New(nginx.Conf{
-		BinaryLocation:          nginxBinary,
-		IngressPort:             ingressPort,
-		WorkingDir:              nginxWorkDir,
-		WorkerProcesses:         nginxWorkerProcesses,
-		WorkerConnections:       nginxWorkerConnections,
-		KeepaliveSeconds:        nginxKeepAliveSeconds,
-		BackendKeepalives:       nginxBackendKeepalives,
-		BackendKeepaliveSeconds: nginxBackendKeepaliveSeconds,
-		HealthPort:              ingressHealthPort,
-		TrustedFrontends:        trustedFrontends,
+		BinaryLocation:               nginxBinary,
+		IngressPort:                  ingressPort,
+		WorkingDir:                   nginxWorkDir,
+		WorkerProcesses:              nginxWorkerProcesses,
+		WorkerConnections:            nginxWorkerConnections,
+		KeepaliveSeconds:             nginxKeepAliveSeconds,
+		BackendKeepalives:            nginxBackendKeepalives,
+		BackendKeepaliveSeconds:      nginxBackendKeepaliveSeconds,
+		BackendConnectTimeoutSeconds: nginxBackendConnectTimeout,
+		WebSocketTimeoutSeconds:      nginxWebSocketTimeout,
+		RateLimitZoneSizeMB:          nginxRateLimitZoneMB,
+		RateLimitStatus:              nginxRateLimitStatus,
+		MaxConnectionsPerIP:          nginxMaxConnectionsPerIP,
+		HTTP2:                        nginxHTTP2,
+		HealthPort:                   ingressHealthPort,
+		TrustedFrontends:             trustedFrontends,
+		Resolvers:                    resolvers,
 	})
-	return []controller.Updater{frontend, proxy}
+	updaters := []controller.Updater{frontend, proxy}
+
+	if updateIngressStatus {
+		updaters = append(updaters, status.New(status.Config{
+			KubernetesClient: client,
+			Frontends:        frontendAddresses(),
+			ElbRegion:        elbRegion,
+			ElbLabelValue:    elbLabelValue,
+		}))
+	}
+
+	return updaters
+}
+
+func frontendAddresses() map[string][]string {
+	addresses := make(map[string][]string)
+	if internalAddrs != "" {
+		addresses["internal"] = strings.Split(internalAddrs, ",")
+	}
+	if externalAddrs != "" {
+		addresses["internet-facing"] = strings.Split(externalAddrs, ",")
+	}
+	return addresses
+}
+
+// nameservers returns the nameservers of the resolv.conf file, in the form nginx's resolver directive expects.
+func nameservers(path string) []string {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Warnf("Unable to read nameservers from %s: %v", path, err)
+		return nil
+	}
+	var servers []string
+	for _, line := range strings.Split(string(contents), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "nameserver" {
+			continue
+		}
+		server := fields[1]
+		if strings.Contains(server, ":") {
+			server = "[" + server + "]"
+		}
+		servers = append(servers, server)
+	}
+	return servers
 }
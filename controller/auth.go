@@ -0,0 +1,128 @@
+package controller
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/sky-uk/feed/k8s"
+)
+
+// AuthTypeBasic is the sky.uk/auth-type for basic authentication, with users from an htpasswd file in a secret.
+const AuthTypeBasic = "basic"
+
+// basicAuthSecretKey is the key of the htpasswd file in the secret.
+const basicAuthSecretKey = "auth"
+
+// BasicAuth has the users allowed by basic authentication.
+type BasicAuth struct {
+	// Secret is the namespace/name of the secret holding the htpasswd file.
+	Secret string
+	// Htpasswd is the contents of the htpasswd file. Empty if secrets aren't being watched.
+	Htpasswd []byte
+}
+
+// String leaves out the password hashes.
+func (a BasicAuth) String() string {
+	return fmt.Sprintf("basic auth from %s", a.Secret)
+}
+
+// basicAuth returns the basic authentication of the ingress, or nil if it doesn't have any.
+func basicAuth(ingress k8s.Ingress, secrets map[string]k8s.Secret) (*BasicAuth, error) {
+	authType, ok := ingress.Annotations[authTypeAnnotation]
+	if !ok {
+		if _, ok := ingress.Annotations[authSecretAnnotation]; ok {
+			return nil, fmt.Errorf("%s needs %s to be set", authSecretAnnotation, authTypeAnnotation)
+		}
+		return nil, nil
+	}
+	if authType != AuthTypeBasic {
+		return nil, fmt.Errorf("%s must be %s, but was %q", authTypeAnnotation, AuthTypeBasic, authType)
+	}
+
+	secretName, ok := ingress.Annotations[authSecretAnnotation]
+	if !ok || secretName == "" {
+		return nil, fmt.Errorf("%s needs %s to be set", authTypeAnnotation, authSecretAnnotation)
+	}
+
+	name, secret, err := lookupSecret(ingress, secretName, secrets)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return &BasicAuth{Secret: name}, nil
+	}
+	htpasswd := secret.Data[basicAuthSecretKey]
+	if len(htpasswd) == 0 {
+		return nil, fmt.Errorf("secret %s has no %s key", name, basicAuthSecretKey)
+	}
+	return &BasicAuth{Secret: name, Htpasswd: htpasswd}, nil
+}
+
+// ExternalAuth authenticates each request with a subrequest to an external service, which allows it with a 2xx
+// response or denies it with 401 or 403.
+type ExternalAuth struct {
+	// URL of the auth service.
+	URL string
+	// SigninURL is where unauthenticated users are redirected to, with the original URL in the rd query parameter.
+	// Empty returns 401 to unauthenticated users.
+	SigninURL string
+	// ResponseHeaders are copied from the auth response to the proxied request, such as the user's identity.
+	ResponseHeaders []string
+}
+
+// externalAuth returns the external authentication of the ingress, or nil if it doesn't have any.
+func externalAuth(annotations map[string]string) (*ExternalAuth, error) {
+	authURL, ok := annotations[authURLAnnotation]
+	if !ok {
+		for _, annotation := range []string{authSigninAnnotation, authResponseHeadersAnnotation} {
+			if _, ok := annotations[annotation]; ok {
+				return nil, fmt.Errorf("%s needs %s to be set", annotation, authURLAnnotation)
+			}
+		}
+		return nil, nil
+	}
+	if err := validateAuthURL(authURL); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", authURLAnnotation, err)
+	}
+	// The auth service gets the path of the internal subrequest location if the URL doesn't have one.
+	parsed, _ := url.Parse(authURL)
+	if parsed.Path == "" {
+		parsed.Path = "/"
+	}
+	auth := &ExternalAuth{URL: parsed.String()}
+
+	if signin, ok := annotations[authSigninAnnotation]; ok {
+		if err := validateAuthURL(signin); err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", authSigninAnnotation, err)
+		}
+		auth.SigninURL = signin
+	}
+
+	if headers, ok := annotations[authResponseHeadersAnnotation]; ok {
+		for _, header := range strings.Split(headers, ",") {
+			header = strings.TrimSpace(header)
+			if !validHeaderName(header) {
+				return nil, fmt.Errorf("invalid header in %s: %q", authResponseHeadersAnnotation, header)
+			}
+			auth.ResponseHeaders = append(auth.ResponseHeaders, header)
+		}
+	}
+
+	return auth, nil
+}
+
+// validateAuthURL allows absolute http and https URLs, without characters nginx would treat as syntax or variables.
+func validateAuthURL(authURL string) error {
+	if strings.ContainsAny(authURL, " \t\r\n\"'\\;{}$") {
+		return fmt.Errorf("%q can't contain whitespace, quotes, backslashes, semicolons, braces or $", authURL)
+	}
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		return err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" || parsed.Host == "" {
+		return fmt.Errorf("%q must be an absolute http or https URL", authURL)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package controller
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"strings"
+
+	"github.com/sky-uk/feed/k8s"
+)
+
+const (
+	// BackendProtocolHTTP proxies requests to the backend over HTTP/1.1. This is the default.
+	BackendProtocolHTTP = "HTTP"
+	// BackendProtocolHTTPS proxies requests to the backend over HTTP/1.1 with TLS.
+	BackendProtocolHTTPS = "HTTPS"
+	// BackendProtocolGRPC proxies gRPC requests to the backend over HTTP/2.
+	BackendProtocolGRPC = "GRPC"
+	// BackendProtocolGRPCS proxies gRPC requests to the backend over HTTP/2 with TLS.
+	BackendProtocolGRPCS = "GRPCS"
+)
+
+// parseBackendProtocol sets the backend protocol of the entry, returning an error if it's unknown or can't be
+// used with the entry's other settings.
+func parseBackendProtocol(entry *IngressEntry, protocol string) error {
+	protocol = strings.ToUpper(protocol)
+	switch protocol {
+	case BackendProtocolHTTP, BackendProtocolHTTPS:
+	case BackendProtocolGRPC, BackendProtocolGRPCS:
+		if entry.WebSocket {
+			return fmt.Errorf("%s can't be used with %s backends", webSocketAnnotation, protocol)
+		}
+		if entry.ExternalAuth != nil && entry.ExternalAuth.SigninURL != "" {
+			return fmt.Errorf("%s can't be used with %s backends, as gRPC clients can't follow redirects",
+				authSigninAnnotation, protocol)
+		}
+	default:
+		return fmt.Errorf("%s must be one of %s, %s, %s or %s, but was %q", backendProtocolAnnotation,
+			BackendProtocolHTTP, BackendProtocolHTTPS, BackendProtocolGRPC, BackendProtocolGRPCS, protocol)
+	}
+	entry.BackendProtocol = protocol
+	return nil
+}
+
+// Keys of the backend TLS secret, matching those of kubernetes.io/tls secrets.
+const (
+	backendTLSCAKey          = "ca.crt"
+	backendTLSCertificateKey = "tls.crt"
+	backendTLSKeyKey         = "tls.key"
+)
+
+// BackendTLS configures TLS connections to HTTPS and GRPCS backends.
+type BackendTLS struct {
+	// ServerName is sent in SNI, and verified against the backend's certificate if CA is set.
+	ServerName string
+	// Secret is the namespace/name of the secret holding the certificates. Empty if there isn't one.
+	Secret string
+	// CA is the PEM bundle trusted to sign the backend's certificate. Empty doesn't verify the backend, or if
+	// Secret is set, means secrets aren't being watched.
+	CA []byte
+	// Certificate is the PEM client certificate presented to the backend. Empty doesn't present one.
+	Certificate []byte
+	// Key is the PEM private key of Certificate.
+	Key []byte
+}
+
+// String leaves out the certificates and key, so entries can be logged.
+func (t BackendTLS) String() string {
+	if t.Secret == "" {
+		return fmt.Sprintf("backend tls to %s", t.ServerName)
+	}
+	return fmt.Sprintf("backend tls to %s from %s", t.ServerName, t.Secret)
+}
+
+// backendTLS returns the TLS settings of the ingress's backend, or nil if it doesn't have any. The server name
+// defaults to the service's cluster DNS name.
+func backendTLS(ingress k8s.Ingress, protocol, service string, secrets map[string]k8s.Secret) (*BackendTLS, error) {
+	secretName, hasSecret := ingress.Annotations[backendTLSSecretAnnotation]
+	serverName, hasServerName := ingress.Annotations[backendTLSServerNameAnnotation]
+	if !hasSecret && !hasServerName {
+		return nil, nil
+	}
+	if protocol != BackendProtocolHTTPS && protocol != BackendProtocolGRPCS {
+		return nil, fmt.Errorf("backend tls needs %s to be %s or %s", backendProtocolAnnotation,
+			BackendProtocolHTTPS, BackendProtocolGRPCS)
+	}
+
+	backendTLS := &BackendTLS{ServerName: service + "." + ingress.Namespace + ".svc"}
+	if hasServerName {
+		name, err := NormaliseHost(serverName)
+		if err != nil || strings.HasPrefix(name, "*") {
+			return nil, fmt.Errorf("invalid %s %q", backendTLSServerNameAnnotation, serverName)
+		}
+		backendTLS.ServerName = name
+	}
+	if !hasSecret {
+		return backendTLS, nil
+	}
+
+	name, secret, err := lookupSecret(ingress, secretName, secrets)
+	if err != nil {
+		return nil, err
+	}
+	backendTLS.Secret = name
+	if secret == nil {
+		return backendTLS, nil
+	}
+
+	backendTLS.CA = secret.Data[backendTLSCAKey]
+	if !x509.NewCertPool().AppendCertsFromPEM(backendTLS.CA) {
+		return nil, fmt.Errorf("secret %s has no PEM certificates in its %s key", name, backendTLSCAKey)
+	}
+
+	backendTLS.Certificate = secret.Data[backendTLSCertificateKey]
+	backendTLS.Key = secret.Data[backendTLSKeyKey]
+	if len(backendTLS.Certificate) > 0 || len(backendTLS.Key) > 0 {
+		if _, err := tls.X509KeyPair(backendTLS.Certificate, backendTLS.Key); err != nil {
+			return nil, fmt.Errorf("secret %s has an invalid client certificate: %v", name, err)
+		}
+	}
+
+	return backendTLS, nil
+}
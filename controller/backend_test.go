@@ -0,0 +1,109 @@
+package controller
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/sky-uk/feed/k8s"
+	"github.com/stretchr/testify/assert"
+)
+
+// selfSignedCert returns a PEM certificate and key, which can be used as both a CA and a client certificate.
+func selfSignedCert(t *testing.T) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func TestBackendTLS(t *testing.T) {
+	assert := assert.New(t)
+	cert, key := selfSignedCert(t)
+	secret := func(name string, data map[string][]byte) k8s.Secret {
+		return k8s.Secret{ObjectMeta: k8s.ObjectMeta{Namespace: "ns", Name: name}, Data: data}
+	}
+	secrets := mapSecretsByName([]k8s.Secret{
+		secret("ca", map[string][]byte{"ca.crt": cert}),
+		secret("client", map[string][]byte{"ca.crt": cert, "tls.crt": cert, "tls.key": key}),
+		secret("no-ca", map[string][]byte{"tls.crt": cert, "tls.key": key}),
+		secret("bad-ca", map[string][]byte{"ca.crt": []byte("not a cert")}),
+		secret("no-key", map[string][]byte{"ca.crt": cert, "tls.crt": cert}),
+	})
+
+	var tests = []struct {
+		description string
+		annotations map[string]string
+		protocol    string
+		secrets     map[string]k8s.Secret
+		expected    *BackendTLS
+		valid       bool
+	}{
+		{"no annotations", map[string]string{}, BackendProtocolHTTPS, secrets, nil, true},
+		{"server name without a secret only sets SNI",
+			map[string]string{backendTLSServerNameAnnotation: "Backend.Example.com"}, BackendProtocolHTTPS, nil,
+			&BackendTLS{ServerName: "backend.example.com"}, true},
+		{"ca secret with default server name",
+			map[string]string{backendTLSSecretAnnotation: "ca"}, BackendProtocolGRPCS, secrets,
+			&BackendTLS{ServerName: "svc.ns.svc", Secret: "ns/ca", CA: cert}, true},
+		{"client certificate",
+			map[string]string{backendTLSSecretAnnotation: "client"}, BackendProtocolHTTPS, secrets,
+			&BackendTLS{ServerName: "svc.ns.svc", Secret: "ns/client", CA: cert, Certificate: cert, Key: key}, true},
+		{"plain http backend", map[string]string{backendTLSSecretAnnotation: "ca"}, "", secrets, nil, false},
+		{"grpc backend", map[string]string{backendTLSSecretAnnotation: "ca"}, BackendProtocolGRPC, secrets, nil, false},
+		{"secrets not watched", map[string]string{backendTLSSecretAnnotation: "ca"}, BackendProtocolHTTPS, nil,
+			&BackendTLS{ServerName: "svc.ns.svc", Secret: "ns/ca"}, true},
+		{"missing secret", map[string]string{backendTLSSecretAnnotation: "missing"}, BackendProtocolHTTPS, secrets,
+			nil, false},
+		{"secret without ca", map[string]string{backendTLSSecretAnnotation: "no-ca"}, BackendProtocolHTTPS, secrets,
+			nil, false},
+		{"invalid ca", map[string]string{backendTLSSecretAnnotation: "bad-ca"}, BackendProtocolHTTPS, secrets, nil,
+			false},
+		{"client certificate without key", map[string]string{backendTLSSecretAnnotation: "no-key"},
+			BackendProtocolHTTPS, secrets, nil, false},
+		{"invalid server name", map[string]string{backendTLSServerNameAnnotation: "backend;"}, BackendProtocolHTTPS,
+			secrets, nil, false},
+		{"wildcard server name", map[string]string{backendTLSServerNameAnnotation: "*.example.com"},
+			BackendProtocolHTTPS, secrets, nil, false},
+	}
+
+	for _, test := range tests {
+		ingress := k8s.Ingress{ObjectMeta: k8s.ObjectMeta{Namespace: "ns", Annotations: test.annotations}}
+		backendTLS, err := backendTLS(ingress, test.protocol, "svc", test.secrets)
+		if test.valid {
+			assert.NoError(err, test.description)
+			assert.Equal(test.expected, backendTLS, test.description)
+		} else {
+			assert.Error(err, test.description)
+		}
+	}
+}
+
+func TestBackendTLSStringLeavesOutKey(t *testing.T) {
+	backendTLS := &BackendTLS{ServerName: "svc.ns.svc", Secret: "ns/client", Key: []byte("secret key")}
+	assert.Equal(t, "backend tls to svc.ns.svc from ns/client", backendTLS.String())
+}
@@ -5,7 +5,9 @@ It delegates update logic to an Updater interface.
 package controller
 
 import (
+	"strconv"
 	"sync"
+	"time"
 
 	"fmt"
 
@@ -18,6 +20,33 @@ import (
 
 const ingressAllowAnnotation = "sky.uk/allow"
 const frontendElbScheme = "sky.uk/frontend-elb-scheme"
+const dnsDualStackAnnotation = "sky.uk/dns-dualstack"
+const dnsWeightAnnotation = "sky.uk/dns-weight"
+const dnsFailoverAnnotation = "sky.uk/dns-failover"
+const dnsIgnoreAnnotation = "sky.uk/dns-ignore"
+const dnsTTLAnnotation = "sky.uk/dns-ttl"
+const dnsAliasesAnnotation = "sky.uk/dns-aliases"
+const dnsHealthCheckPathAnnotation = "sky.uk/dns-health-check-path"
+const dnsHostnameAnnotation = "sky.uk/dns-hostname"
+const awsInternalLoadBalancerAnnotation = "service.beta.kubernetes.io/aws-load-balancer-internal"
+const proxyConnectTimeoutAnnotation = "sky.uk/proxy-connect-timeout"
+const proxyReadTimeoutAnnotation = "sky.uk/proxy-read-timeout"
+const proxySendTimeoutAnnotation = "sky.uk/proxy-send-timeout"
+const stripPathAnnotation = "sky.uk/strip-path"
+const rewriteTargetAnnotation = "sky.uk/rewrite-target"
+const pathTypeAnnotation = "sky.uk/path-type"
+const rateLimitRPSAnnotation = "sky.uk/rate-limit-rps"
+const rateLimitBurstAnnotation = "sky.uk/rate-limit-burst"
+const rateLimitKeyAnnotation = "sky.uk/rate-limit-key"
+const authTypeAnnotation = "sky.uk/auth-type"
+const authSecretAnnotation = "sky.uk/auth-secret"
+const webSocketAnnotation = "sky.uk/websocket"
+const backendProtocolAnnotation = "sky.uk/backend-protocol"
+const backendTLSSecretAnnotation = "sky.uk/backend-tls-secret"
+const backendTLSServerNameAnnotation = "sky.uk/backend-tls-server-name"
+const authURLAnnotation = "sky.uk/auth-url"
+const authSigninAnnotation = "sky.uk/auth-signin"
+const authResponseHeadersAnnotation = "sky.uk/auth-response-headers"
 
 // Controller operates on ingress resources, listening for updates and notifying its Updaters.
 type Controller interface {
@@ -33,6 +62,12 @@ type controller struct {
 	client        k8s.Client
 	updaters      []Updater
 	defaultAllow  []string
+	dualStack     bool
+	dnsRouting    DNSRouting
+	watchSecrets  bool
+	secretsPoll   time.Duration
+	secretRefs    []secretRef
+	secrets       map[string]k8s.Secret
 	watcher       k8s.Watcher
 	watcherDone   sync.WaitGroup
 	started       bool
@@ -42,18 +77,36 @@ type controller struct {
 
 // Config for creating a new ingress controller.
 type Config struct {
-	KubernetesClient k8s.Client
-	Updaters         []Updater
-	DefaultAllow     string
+	KubernetesClient  k8s.Client
+	Updaters          []Updater
+	DefaultAllow      string
+	DefaultDualStack  bool
+	DefaultDNSRouting DNSRouting
+	// WatchSecrets reads the secrets named by annotations, and checks them for changes every SecretsPollInterval.
+	// It only needs permission to get those secrets, not to list or watch all the secrets in the cluster.
+	WatchSecrets bool
+	// SecretsPollInterval defaults to DefaultSecretsPollInterval.
+	SecretsPollInterval time.Duration
 }
 
+// DefaultSecretsPollInterval is how often secrets are checked for changes by default.
+const DefaultSecretsPollInterval = 30 * time.Second
+
 // New creates an ingress controller.
 func New(conf Config) Controller {
-	return &controller{
+	c := &controller{
 		client:       conf.KubernetesClient,
 		updaters:     conf.Updaters,
 		defaultAllow: strings.Split(conf.DefaultAllow, ","),
+		dualStack:    conf.DefaultDualStack,
+		dnsRouting:   conf.DefaultDNSRouting,
+		watchSecrets: conf.WatchSecrets,
+		secretsPoll:  conf.SecretsPollInterval,
+	}
+	if c.secretsPoll == 0 {
+		c.secretsPoll = DefaultSecretsPollInterval
 	}
+	return c
 }
 
 func (c *controller) Start() error {
@@ -91,17 +144,38 @@ func (c *controller) watchForUpdates() {
 func (c *controller) handleUpdates() {
 	defer c.watcherDone.Done()
 
-	for range c.watcher.Updates() {
-		log.Info("Received update on watcher")
-		if err := c.updateIngresses(); err != nil {
-			c.updatesHealth.Set(err)
-			log.Errorf("Unable to update ingresses: %v", err)
-		} else {
-			c.updatesHealth.Set(nil)
+	var secretsPoll <-chan time.Time
+	if c.watchSecrets {
+		ticker := time.NewTicker(c.secretsPoll)
+		defer ticker.Stop()
+		secretsPoll = ticker.C
+	}
+
+	for {
+		select {
+		case _, ok := <-c.watcher.Updates():
+			if !ok {
+				log.Debug("Controller stopped watching for updates")
+				return
+			}
+			log.Info("Received update on watcher")
+			c.update()
+		case <-secretsPoll:
+			if c.secretsChanged() {
+				log.Info("Secrets have changed")
+				c.update()
+			}
 		}
 	}
+}
 
-	log.Debug("Controller stopped watching for updates")
+func (c *controller) update() {
+	if err := c.updateIngresses(); err != nil {
+		c.updatesHealth.Set(err)
+		log.Errorf("Unable to update ingresses: %v", err)
+	} else {
+		c.updatesHealth.Set(nil)
+	}
 }
 
 func (c *controller) updateIngresses() error {
@@ -117,6 +191,13 @@ func (c *controller) updateIngresses() error {
 
 	serviceMap := mapNamesToAddresses(services)
 
+	var secrets map[string]k8s.Secret
+	if c.watchSecrets {
+		c.secretRefs = referencedSecrets(ingresses)
+		secrets = c.readSecrets(c.secretRefs)
+		c.secrets = secrets
+	}
+
 	var skipped int
 	entries := []IngressEntry{}
 	for _, ingress := range ingresses {
@@ -134,6 +215,11 @@ func (c *controller) updateIngresses() error {
 						ServicePort:    int32(path.Backend.ServicePort.IntValue()),
 						Allow:          c.defaultAllow,
 						ELbScheme:      ingress.Annotations[frontendElbScheme],
+						DualStack:      c.dualStack,
+					}
+
+					for _, lb := range ingress.Status.LoadBalancer.Ingress {
+						entry.LoadBalancerAddresses = append(entry.LoadBalancerAddresses, lb.IP+lb.Hostname)
 					}
 
 					if allow, ok := ingress.Annotations[ingressAllowAnnotation]; ok {
@@ -144,6 +230,138 @@ func (c *controller) updateIngresses() error {
 						}
 					}
 
+					if dualStack, ok := ingress.Annotations[dnsDualStackAnnotation]; ok {
+						if parsed, err := strconv.ParseBool(dualStack); err == nil {
+							entry.DualStack = parsed
+						} else {
+							log.Warnf("Ignoring invalid %s annotation on %s: %v", dnsDualStackAnnotation, entry.Name, err)
+						}
+					}
+
+					if ignore, ok := ingress.Annotations[dnsIgnoreAnnotation]; ok {
+						if parsed, err := strconv.ParseBool(ignore); err == nil {
+							entry.DNSIgnore = parsed
+						} else {
+							log.Warnf("Ignoring invalid %s annotation on %s: %v", dnsIgnoreAnnotation, entry.Name, err)
+						}
+					}
+
+					if ttl, ok := ingress.Annotations[dnsTTLAnnotation]; ok {
+						if parsed, err := strconv.Atoi(ttl); err == nil && parsed > 0 {
+							entry.DNSTTL = parsed
+						} else {
+							log.Warnf("Ignoring invalid %s annotation on %s, must be a positive number of seconds: %q",
+								dnsTTLAnnotation, entry.Name, ttl)
+						}
+					}
+
+					if aliases, ok := ingress.Annotations[dnsAliasesAnnotation]; ok {
+						entry.DNSAliases = validHosts(parseDNSAliases(aliases), dnsAliasesAnnotation, entry.Name)
+					}
+
+					if path, ok := ingress.Annotations[dnsHealthCheckPathAnnotation]; ok {
+						if strings.HasPrefix(path, "/") {
+							entry.DNSHealthCheckPath = path
+						} else {
+							log.Warnf("Ignoring invalid %s annotation on %s, must start with /: %q",
+								dnsHealthCheckPathAnnotation, entry.Name, path)
+						}
+					}
+
+					entry.ProxyConnectTimeoutSeconds = parseTimeout(ingress.Annotations, proxyConnectTimeoutAnnotation, entry.Name)
+					entry.ProxyReadTimeoutSeconds = parseTimeout(ingress.Annotations, proxyReadTimeoutAnnotation, entry.Name)
+					entry.ProxySendTimeoutSeconds = parseTimeout(ingress.Annotations, proxySendTimeoutAnnotation, entry.Name)
+
+					if err := parseRateLimit(&entry, ingress.Annotations); err != nil {
+						log.Warnf("Ignoring invalid rate limit on %s: %v", entry.Name, err)
+					}
+
+					if strip, ok := ingress.Annotations[stripPathAnnotation]; ok {
+						if parsed, err := strconv.ParseBool(strip); err == nil {
+							entry.PreservePath = !parsed
+						} else {
+							log.Warnf("Ignoring invalid %s annotation on %s: %v", stripPathAnnotation, entry.Name, err)
+						}
+					}
+
+					if webSocket, ok := ingress.Annotations[webSocketAnnotation]; ok {
+						if parsed, err := strconv.ParseBool(webSocket); err == nil {
+							entry.WebSocket = parsed
+						} else {
+							log.Warnf("Ignoring invalid %s annotation on %s: %v", webSocketAnnotation, entry.Name, err)
+						}
+					}
+
+					auth, err := basicAuth(ingress, secrets)
+					if err != nil {
+						log.Warnf("Skipping entry %s: %v", entry.Name, err)
+						skipped++
+						continue
+					}
+					entry.BasicAuth = auth
+
+					externalAuth, err := externalAuth(ingress.Annotations)
+					if err != nil {
+						log.Warnf("Skipping entry %s: %v", entry.Name, err)
+						skipped++
+						continue
+					}
+					entry.ExternalAuth = externalAuth
+
+					if protocol, ok := ingress.Annotations[backendProtocolAnnotation]; ok {
+						if err := parseBackendProtocol(&entry, protocol); err != nil {
+							log.Warnf("Skipping entry %s: %v", entry.Name, err)
+							skipped++
+							continue
+						}
+					}
+
+					backendTLS, err := backendTLS(ingress, entry.BackendProtocol, path.Backend.ServiceName, secrets)
+					if err != nil {
+						log.Warnf("Skipping entry %s: %v", entry.Name, err)
+						skipped++
+						continue
+					}
+					entry.BackendTLS = backendTLS
+
+					if pathType, ok := ingress.Annotations[pathTypeAnnotation]; ok {
+						if err := validatePath(pathType, entry.Path); err != nil {
+							log.Warnf("Skipping entry %s: %v", entry.Name, err)
+							skipped++
+							continue
+						}
+						entry.PathType = pathType
+					}
+
+					if rewrite, ok := ingress.Annotations[rewriteTargetAnnotation]; ok {
+						regex, replacement, err := parseRewrite(rewrite)
+						if err != nil {
+							log.Warnf("Skipping entry %s: %v", entry.Name, err)
+							skipped++
+							continue
+						}
+						entry.RewriteRegex = regex
+						entry.RewriteReplacement = replacement
+					}
+
+					dnsRouting, err := parseDNSRouting(c.dnsRouting, ingress.Annotations)
+					if err != nil {
+						log.Warnf("Skipping entry %s: %v", entry.Name, err)
+						skipped++
+						continue
+					}
+					entry.DNSRouting = dnsRouting
+
+					if entry.Host != "" {
+						host, err := NormaliseHost(entry.Host)
+						if err != nil {
+							log.Warnf("Skipping entry %s: %v", entry.Name, err)
+							skipped++
+							continue
+						}
+						entry.Host = host
+					}
+
 					if err := entry.validate(); err == nil {
 						entries = append(entries, entry)
 					} else {
@@ -156,8 +374,11 @@ func (c *controller) updateIngresses() error {
 		}
 	}
 
-	log.Infof("Updating with %d entries, skipping %d invalid", len(entries), skipped)
-	update := IngressUpdate{Entries: entries}
+	serviceEntries := c.serviceEntries(services)
+
+	log.Infof("Updating with %d entries and %d services, skipping %d invalid", len(entries), len(serviceEntries),
+		skipped)
+	update := IngressUpdate{Entries: entries, Services: serviceEntries}
 	for _, u := range c.updaters {
 		if err := u.Update(update); err != nil {
 			return err
@@ -167,6 +388,120 @@ func (c *controller) updateIngresses() error {
 	return nil
 }
 
+// parseDNSRouting overrides the default routing with the ingress's weight or failover annotation.
+func parseDNSRouting(routing DNSRouting, annotations map[string]string) (DNSRouting, error) {
+	weight, hasWeight := annotations[dnsWeightAnnotation]
+	failover, hasFailover := annotations[dnsFailoverAnnotation]
+
+	if hasWeight && hasFailover {
+		return routing, fmt.Errorf("only one of %s and %s can be set", dnsWeightAnnotation, dnsFailoverAnnotation)
+	}
+
+	if hasWeight {
+		parsed, err := strconv.ParseInt(weight, 10, 64)
+		if err != nil || parsed < 0 || parsed > MaxDNSWeight {
+			return routing, fmt.Errorf("%s must be between 0 and %d, but was %q", dnsWeightAnnotation, MaxDNSWeight, weight)
+		}
+		return DNSRouting{Weight: parsed}, nil
+	}
+
+	if hasFailover {
+		failover = strings.ToUpper(failover)
+		if failover != FailoverPrimary && failover != FailoverSecondary {
+			return routing, fmt.Errorf("%s must be %s or %s, but was %q", dnsFailoverAnnotation,
+				FailoverPrimary, FailoverSecondary, failover)
+		}
+		return DNSRouting{Failover: failover}, nil
+	}
+
+	return routing, nil
+}
+
+// parseTimeout returns the timeout annotation in seconds, or zero if it's missing or invalid.
+func parseTimeout(annotations map[string]string, annotation, name string) int {
+	timeout, ok := annotations[annotation]
+	if !ok {
+		return 0
+	}
+	parsed, err := strconv.Atoi(timeout)
+	if err != nil || parsed <= 0 {
+		log.Warnf("Ignoring invalid %s annotation on %s, must be a positive number of seconds: %q",
+			annotation, name, timeout)
+		return 0
+	}
+	return parsed
+}
+
+// parseDNSAliases splits the comma separated aliases, ignoring blanks.
+func parseDNSAliases(aliases string) []string {
+	var parsed []string
+	for _, alias := range strings.Split(aliases, ",") {
+		if alias = strings.TrimSpace(alias); alias != "" {
+			parsed = append(parsed, alias)
+		}
+	}
+	return parsed
+}
+
+// serviceEntries returns an entry for each service of type LoadBalancer with a DNS hostname annotation.
+func (c *controller) serviceEntries(services []k8s.Service) []ServiceEntry {
+	var entries []ServiceEntry
+	for _, svc := range services {
+		hostnames, ok := svc.Annotations[dnsHostnameAnnotation]
+		if !ok {
+			continue
+		}
+
+		entry := ServiceEntry{
+			Name:      svc.Namespace + "/" + svc.Name,
+			Hosts:     validHosts(parseDNSAliases(hostnames), dnsHostnameAnnotation, svc.Namespace+"/"+svc.Name),
+			ELbScheme: "internet-facing",
+		}
+
+		if svc.Spec.Type != k8s.ServiceTypeLoadBalancer {
+			log.Warnf("Ignoring %s annotation on %s, as it isn't a %s service", dnsHostnameAnnotation, entry.Name,
+				k8s.ServiceTypeLoadBalancer)
+			continue
+		}
+
+		for _, lb := range svc.Status.LoadBalancer.Ingress {
+			entry.LoadBalancerAddresses = append(entry.LoadBalancerAddresses, lb.IP+lb.Hostname)
+		}
+		if len(entry.LoadBalancerAddresses) == 0 {
+			log.Infof("Service %s has no load balancer in its status yet", entry.Name)
+			continue
+		}
+
+		if internal, ok := svc.Annotations[awsInternalLoadBalancerAnnotation]; ok && internal != "false" {
+			entry.ELbScheme = "internal"
+		}
+
+		routing, err := parseDNSRouting(c.dnsRouting, svc.Annotations)
+		if err != nil {
+			log.Warnf("Skipping service %s: %v", entry.Name, err)
+			continue
+		}
+		entry.DNSRouting = routing
+
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// validHosts returns the normalised hosts from the annotation, dropping any that are invalid.
+func validHosts(hosts []string, annotation, name string) []string {
+	var valid []string
+	for _, host := range hosts {
+		normalised, err := NormaliseHost(host)
+		if err != nil {
+			log.Warnf("Ignoring invalid host in %s annotation on %s: %v", annotation, name, err)
+			continue
+		}
+		valid = append(valid, normalised)
+	}
+	return valid
+}
+
 type serviceName struct {
 	namespace string
 	name      string
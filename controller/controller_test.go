@@ -9,6 +9,8 @@ import (
 
 	"strings"
 
+	"errors"
+
 	"github.com/sky-uk/feed/k8s"
 	fake "github.com/sky-uk/feed/util/test"
 	"github.com/stretchr/testify/assert"
@@ -344,6 +346,363 @@ func TestUpdaterIsUpdatedOnK8sUpdates(t *testing.T) {
 				Allow:          []string{},
 			}}},
 		},
+		{
+			"ingress with dualstack",
+			withAnnotation(createDefaultIngresses(), dnsDualStackAnnotation, "true"),
+			createDefaultServices(),
+			withEntries(createLbEntriesFixture(), func(e *IngressEntry) { e.DualStack = true }),
+		},
+		{
+			"ingress with invalid dualstack is ignored",
+			withAnnotation(createDefaultIngresses(), dnsDualStackAnnotation, "maybe"),
+			createDefaultServices(),
+			createLbEntriesFixture(),
+		},
+		{
+			"ingress with dns weight",
+			withAnnotation(createDefaultIngresses(), dnsWeightAnnotation, "20"),
+			createDefaultServices(),
+			withEntries(createLbEntriesFixture(), func(e *IngressEntry) { e.DNSRouting = DNSRouting{Weight: 20} }),
+		},
+		{
+			"ingress with dns failover",
+			withAnnotation(createDefaultIngresses(), dnsFailoverAnnotation, "secondary"),
+			createDefaultServices(),
+			withEntries(createLbEntriesFixture(), func(e *IngressEntry) { e.DNSRouting = DNSRouting{Failover: "SECONDARY"} }),
+		},
+		{
+			"ingress with invalid dns weight",
+			withAnnotation(createDefaultIngresses(), dnsWeightAnnotation, "256"),
+			createDefaultServices(),
+			IngressUpdate{Entries: []IngressEntry{}},
+		},
+		{
+			"ingress with invalid dns failover",
+			withAnnotation(createDefaultIngresses(), dnsFailoverAnnotation, "TERTIARY"),
+			createDefaultServices(),
+			IngressUpdate{Entries: []IngressEntry{}},
+		},
+		{
+			"ingress ignored by dns",
+			withAnnotation(createDefaultIngresses(), dnsIgnoreAnnotation, "true"),
+			createDefaultServices(),
+			withEntries(createLbEntriesFixture(), func(e *IngressEntry) { e.DNSIgnore = true }),
+		},
+		{
+			"ingress with invalid dns ignore is not ignored",
+			withAnnotation(createDefaultIngresses(), dnsIgnoreAnnotation, "perhaps"),
+			createDefaultServices(),
+			createLbEntriesFixture(),
+		},
+		{
+			"ingress with dns ttl",
+			withAnnotation(createDefaultIngresses(), dnsTTLAnnotation, "30"),
+			createDefaultServices(),
+			withEntries(createLbEntriesFixture(), func(e *IngressEntry) { e.DNSTTL = 30 }),
+		},
+		{
+			"ingress with invalid dns ttl uses default",
+			withAnnotation(createDefaultIngresses(), dnsTTLAnnotation, "-1"),
+			createDefaultServices(),
+			createLbEntriesFixture(),
+		},
+		{
+			"ingress with proxy timeouts",
+			withAnnotation(withAnnotation(withAnnotation(createDefaultIngresses(),
+				proxyConnectTimeoutAnnotation, "5"),
+				proxyReadTimeoutAnnotation, "300"),
+				proxySendTimeoutAnnotation, "120"),
+			createDefaultServices(),
+			withEntries(createLbEntriesFixture(), func(e *IngressEntry) {
+				e.ProxyConnectTimeoutSeconds = 5
+				e.ProxyReadTimeoutSeconds = 300
+				e.ProxySendTimeoutSeconds = 120
+			}),
+		},
+		{
+			"ingress with invalid proxy timeout uses default",
+			withAnnotation(createDefaultIngresses(), proxyReadTimeoutAnnotation, "5m"),
+			createDefaultServices(),
+			createLbEntriesFixture(),
+		},
+		{
+			"ingress without path stripping",
+			withAnnotation(createDefaultIngresses(), stripPathAnnotation, "false"),
+			createDefaultServices(),
+			withEntries(createLbEntriesFixture(), func(e *IngressEntry) { e.PreservePath = true }),
+		},
+		{
+			"ingress with invalid strip path uses default",
+			withAnnotation(createDefaultIngresses(), stripPathAnnotation, "sometimes"),
+			createDefaultServices(),
+			createLbEntriesFixture(),
+		},
+		{
+			"ingress with rewrite target",
+			withAnnotation(createDefaultIngresses(), rewriteTargetAnnotation, "^/api/(.*)$ /v2/$1"),
+			createDefaultServices(),
+			withEntries(createLbEntriesFixture(), func(e *IngressEntry) {
+				e.RewriteRegex = "^/api/(.*)$"
+				e.RewriteReplacement = "/v2/$1"
+			}),
+		},
+		{
+			"ingress with invalid rewrite target is skipped",
+			withAnnotation(createDefaultIngresses(), rewriteTargetAnnotation, "^/api/((.*)$ /v2/$1"),
+			createDefaultServices(),
+			IngressUpdate{Entries: []IngressEntry{}},
+		},
+		{
+			"ingress with path type",
+			withAnnotation(createDefaultIngresses(), pathTypeAnnotation, PathTypeExact),
+			createDefaultServices(),
+			withEntries(createLbEntriesFixture(), func(e *IngressEntry) { e.PathType = PathTypeExact }),
+		},
+		{
+			"ingress with invalid path type is skipped",
+			withAnnotation(createDefaultIngresses(), pathTypeAnnotation, "Glob"),
+			createDefaultServices(),
+			IngressUpdate{Entries: []IngressEntry{}},
+		},
+		{
+			"ingress with rate limit",
+			withAnnotation(withAnnotation(createDefaultIngresses(),
+				rateLimitRPSAnnotation, "100"),
+				rateLimitBurstAnnotation, "50"),
+			createDefaultServices(),
+			withEntries(createLbEntriesFixture(), func(e *IngressEntry) {
+				e.RateLimitRPS = 100
+				e.RateLimitBurst = 50
+			}),
+		},
+		{
+			"ingress with invalid rate limit isn't limited",
+			withAnnotation(createDefaultIngresses(), rateLimitRPSAnnotation, "-100"),
+			createDefaultServices(),
+			createLbEntriesFixture(),
+		},
+		{
+			"ingress with basic auth is kept without the htpasswd file if secrets aren't watched",
+			withAnnotation(withAnnotation(createDefaultIngresses(),
+				authTypeAnnotation, AuthTypeBasic),
+				authSecretAnnotation, "htpasswd"),
+			createDefaultServices(),
+			withEntries(createLbEntriesFixture(), func(e *IngressEntry) {
+				e.BasicAuth = &BasicAuth{Secret: ingressNamespace + "/htpasswd"}
+			}),
+		},
+		{
+			"ingress with websocket",
+			withAnnotation(createDefaultIngresses(), webSocketAnnotation, "true"),
+			createDefaultServices(),
+			withEntries(createLbEntriesFixture(), func(e *IngressEntry) { e.WebSocket = true }),
+		},
+		{
+			"ingress with invalid websocket annotation is unchanged",
+			withAnnotation(createDefaultIngresses(), webSocketAnnotation, "yes please"),
+			createDefaultServices(),
+			createLbEntriesFixture(),
+		},
+		{
+			"ingress with grpc backend",
+			withAnnotation(createDefaultIngresses(), backendProtocolAnnotation, "grpc"),
+			createDefaultServices(),
+			withEntries(createLbEntriesFixture(), func(e *IngressEntry) { e.BackendProtocol = BackendProtocolGRPC }),
+		},
+		{
+			"ingress with https backend verified against its service name",
+			withAnnotation(withAnnotation(createDefaultIngresses(),
+				backendProtocolAnnotation, "https"),
+				backendTLSServerNameAnnotation, "backend.sky.com"),
+			createDefaultServices(),
+			withEntries(createLbEntriesFixture(), func(e *IngressEntry) {
+				e.BackendProtocol = BackendProtocolHTTPS
+				e.BackendTLS = &BackendTLS{ServerName: "backend.sky.com"}
+			}),
+		},
+		{
+			"ingress with backend tls secret is kept without the certificates if secrets aren't watched",
+			withAnnotation(withAnnotation(createDefaultIngresses(),
+				backendProtocolAnnotation, "grpcs"),
+				backendTLSSecretAnnotation, "backend-ca"),
+			createDefaultServices(),
+			withEntries(createLbEntriesFixture(), func(e *IngressEntry) {
+				e.BackendProtocol = BackendProtocolGRPCS
+				e.BackendTLS = &BackendTLS{
+					ServerName: ingressSvcName + "." + ingressNamespace + ".svc",
+					Secret:     ingressNamespace + "/backend-ca",
+				}
+			}),
+		},
+		{
+			"ingress with unknown backend protocol is skipped",
+			withAnnotation(createDefaultIngresses(), backendProtocolAnnotation, "SPDY"),
+			createDefaultServices(),
+			IngressUpdate{Entries: []IngressEntry{}},
+		},
+		{
+			"ingress with grpc backend and websocket is skipped",
+			withAnnotation(withAnnotation(createDefaultIngresses(),
+				backendProtocolAnnotation, BackendProtocolGRPCS),
+				webSocketAnnotation, "true"),
+			createDefaultServices(),
+			IngressUpdate{Entries: []IngressEntry{}},
+		},
+		{
+			"ingress with grpc backend and auth signin is skipped",
+			withAnnotation(withAnnotation(withAnnotation(createDefaultIngresses(),
+				backendProtocolAnnotation, BackendProtocolGRPC),
+				authURLAnnotation, "https://sso.sky.com/auth"),
+				authSigninAnnotation, "https://sso.sky.com/signin"),
+			createDefaultServices(),
+			IngressUpdate{Entries: []IngressEntry{}},
+		},
+		{
+			"ingress with external auth",
+			withAnnotation(withAnnotation(withAnnotation(createDefaultIngresses(),
+				authURLAnnotation, "https://sso.sky.com/auth"),
+				authSigninAnnotation, "https://sso.sky.com/signin"),
+				authResponseHeadersAnnotation, "X-User, X-Groups"),
+			createDefaultServices(),
+			withEntries(createLbEntriesFixture(), func(e *IngressEntry) {
+				e.ExternalAuth = &ExternalAuth{
+					URL:             "https://sso.sky.com/auth",
+					SigninURL:       "https://sso.sky.com/signin",
+					ResponseHeaders: []string{"X-User", "X-Groups"},
+				}
+			}),
+		},
+		{
+			"ingress with invalid external auth is skipped",
+			withAnnotation(createDefaultIngresses(), authURLAnnotation, "/auth"),
+			createDefaultServices(),
+			IngressUpdate{Entries: []IngressEntry{}},
+		},
+		{
+			"ingress with wildcard host",
+			createIngressesFixture("*.foo.sky.com", ingressSvcName, ingressSvcPort, ingressAllow),
+			createDefaultServices(),
+			withEntries(createLbEntriesFixture(), func(e *IngressEntry) { e.Host = "*.foo.sky.com" }),
+		},
+		{
+			"ingress with uppercase and internationalised host is normalised",
+			createIngressesFixture("Bücher.SKY.com.", ingressSvcName, ingressSvcPort, ingressAllow),
+			createDefaultServices(),
+			withEntries(createLbEntriesFixture(), func(e *IngressEntry) { e.Host = "xn--bcher-kva.sky.com" }),
+		},
+		{
+			"ingress with invalid host",
+			createIngressesFixture("foo_bar.sky.com", ingressSvcName, ingressSvcPort, ingressAllow),
+			createDefaultServices(),
+			IngressUpdate{Entries: []IngressEntry{}},
+		},
+		{
+			"ingress with normalised dns aliases",
+			withAnnotation(createDefaultIngresses(), dnsAliasesAnnotation, "WWW.foo.sky.com,bad_alias.sky.com"),
+			createDefaultServices(),
+			withEntries(createLbEntriesFixture(), func(e *IngressEntry) { e.DNSAliases = []string{"www.foo.sky.com"} }),
+		},
+		{
+			"ingress with wildcard that isn't the leftmost label",
+			createIngressesFixture("foo.*.sky.com", ingressSvcName, ingressSvcPort, ingressAllow),
+			createDefaultServices(),
+			IngressUpdate{Entries: []IngressEntry{}},
+		},
+		{
+			"ingress with partial wildcard label",
+			createIngressesFixture("foo*.sky.com", ingressSvcName, ingressSvcPort, ingressAllow),
+			createDefaultServices(),
+			IngressUpdate{Entries: []IngressEntry{}},
+		},
+		{
+			"ingress with bare wildcard host",
+			createIngressesFixture("*", ingressSvcName, ingressSvcPort, ingressAllow),
+			createDefaultServices(),
+			IngressUpdate{Entries: []IngressEntry{}},
+		},
+		{
+			"ingress with invalid wildcard dns alias",
+			withAnnotation(createDefaultIngresses(), dnsAliasesAnnotation, "*.www.sky.com,*.*.sky.com,*"),
+			createDefaultServices(),
+			withEntries(createLbEntriesFixture(), func(e *IngressEntry) { e.DNSAliases = []string{"*.www.sky.com"} }),
+		},
+		{
+			"ingress with dns aliases",
+			withAnnotation(createDefaultIngresses(), dnsAliasesAnnotation, "www.foo.sky.com, ,foo2.sky.com"),
+			createDefaultServices(),
+			withEntries(createLbEntriesFixture(), func(e *IngressEntry) {
+				e.DNSAliases = []string{"www.foo.sky.com", "foo2.sky.com"}
+			}),
+		},
+		{
+			"ingress with dns health check path",
+			withAnnotation(createDefaultIngresses(), dnsHealthCheckPathAnnotation, "/healthz"),
+			createDefaultServices(),
+			withEntries(createLbEntriesFixture(), func(e *IngressEntry) { e.DNSHealthCheckPath = "/healthz" }),
+		},
+		{
+			"ingress with invalid dns health check path is ignored",
+			withAnnotation(createDefaultIngresses(), dnsHealthCheckPathAnnotation, "healthz"),
+			createDefaultServices(),
+			createLbEntriesFixture(),
+		},
+		{
+			"ingress with load balancer status",
+			withStatus(createDefaultIngresses(), k8s.LoadBalancerIngress{Hostname: "elb.sky.com"}, k8s.LoadBalancerIngress{IP: "10.0.0.1"}),
+			createDefaultServices(),
+			withEntries(createLbEntriesFixture(), func(e *IngressEntry) {
+				e.LoadBalancerAddresses = []string{"elb.sky.com", "10.0.0.1"}
+			}),
+		},
+		{
+			"load balancer service with dns hostname",
+			createDefaultIngresses(),
+			append(createDefaultServices(), createLoadBalancerService(map[string]string{
+				dnsHostnameAnnotation: "tcp.sky.com, tcp2.sky.com",
+			}, k8s.LoadBalancerIngress{Hostname: "nlb.sky.com"})),
+			IngressUpdate{Entries: createLbEntriesFixture().Entries, Services: []ServiceEntry{{
+				Name:                  ingressNamespace + "/lb-service",
+				Hosts:                 []string{"tcp.sky.com", "tcp2.sky.com"},
+				ELbScheme:             "internet-facing",
+				LoadBalancerAddresses: []string{"nlb.sky.com"},
+			}}},
+		},
+		{
+			"internal load balancer service with dns hostname and weight",
+			createDefaultIngresses(),
+			append(createDefaultServices(), createLoadBalancerService(map[string]string{
+				dnsHostnameAnnotation:             "tcp.sky.com",
+				awsInternalLoadBalancerAnnotation: "0.0.0.0/0",
+				dnsWeightAnnotation:               "5",
+			}, k8s.LoadBalancerIngress{IP: "10.0.0.5"})),
+			IngressUpdate{Entries: createLbEntriesFixture().Entries, Services: []ServiceEntry{{
+				Name:                  ingressNamespace + "/lb-service",
+				Hosts:                 []string{"tcp.sky.com"},
+				ELbScheme:             "internal",
+				LoadBalancerAddresses: []string{"10.0.0.5"},
+				DNSRouting:            DNSRouting{Weight: 5},
+			}}},
+		},
+		{
+			"load balancer service without status is skipped",
+			createDefaultIngresses(),
+			append(createDefaultServices(), createLoadBalancerService(map[string]string{
+				dnsHostnameAnnotation: "tcp.sky.com",
+			})),
+			createLbEntriesFixture(),
+		},
+		{
+			"service with dns hostname that isn't a load balancer is skipped",
+			createDefaultIngresses(),
+			withServiceAnnotation(createDefaultServices(), dnsHostnameAnnotation, "tcp.sky.com"),
+			createLbEntriesFixture(),
+		},
+		{
+			"ingress with both dns weight and failover",
+			withAnnotation(withAnnotation(createDefaultIngresses(), dnsWeightAnnotation, "1"), dnsFailoverAnnotation, "PRIMARY"),
+			createDefaultServices(),
+			IngressUpdate{Entries: []IngressEntry{}},
+		},
 	}
 
 	for _, test := range tests {
@@ -378,6 +737,235 @@ func TestUpdaterIsUpdatedOnK8sUpdates(t *testing.T) {
 	}
 }
 
+func TestDefaultDualStackCanBeOverridden(t *testing.T) {
+	// given
+	assert := assert.New(t)
+	client := new(fake.FakeClient)
+	updater := new(fakeUpdater)
+	controller := New(Config{
+		Updaters:         []Updater{updater},
+		KubernetesClient: client,
+		DefaultAllow:     ingressDefaultAllow,
+		DefaultDualStack: true,
+	})
+
+	ingresses := append(createDefaultIngresses(),
+		withAnnotation(createIngressesFixture("bar.sky.com", ingressSvcName, ingressSvcPort, ingressAllow),
+			dnsDualStackAnnotation, "false")...)
+	ingresses[1].Name = "bar-ingress"
+	dualStackEntry := createLbEntriesFixture().Entries[0]
+	dualStackEntry.DualStack = true
+	overriddenEntry := createLbEntriesFixture().Entries[0]
+	overriddenEntry.Name = ingressNamespace + "/bar-ingress"
+	overriddenEntry.Host = "bar.sky.com"
+
+	updater.On("Start").Return(nil)
+	updater.On("Stop").Return(nil)
+	updater.On("Update", IngressUpdate{Entries: []IngressEntry{dualStackEntry, overriddenEntry}}).Return(nil)
+	client.On("GetIngresses").Return(ingresses, nil)
+	client.On("GetServices").Return(createDefaultServices(), nil)
+	ingressWatcher, ingressCh, _ := createFakeWatcher()
+	serviceWatcher, _, _ := createFakeWatcher()
+	client.On("WatchIngresses").Return(ingressWatcher)
+	client.On("WatchServices").Return(serviceWatcher)
+
+	// when
+	assert.NoError(controller.Start())
+	ingressCh <- struct{}{}
+	time.Sleep(smallWaitTime)
+
+	// then
+	assert.NoError(controller.Stop())
+	updater.AssertExpectations(t)
+}
+
+func TestBasicAuthIsReadFromSecretsAndUpdatedWhenTheyChange(t *testing.T) {
+	// given
+	assert := assert.New(t)
+	client := new(fake.FakeClient)
+	updater := new(fakeUpdater)
+	controller := New(Config{
+		Updaters:            []Updater{updater},
+		KubernetesClient:    client,
+		DefaultAllow:        ingressDefaultAllow,
+		WatchSecrets:        true,
+		SecretsPollInterval: smallWaitTime / 5,
+	})
+
+	ingresses := withAnnotation(withAnnotation(createDefaultIngresses(),
+		authTypeAnnotation, AuthTypeBasic),
+		authSecretAnnotation, "htpasswd")
+	secret := func(htpasswd string) *k8s.Secret {
+		return &k8s.Secret{
+			ObjectMeta: k8s.ObjectMeta{Namespace: ingressNamespace, Name: "htpasswd"},
+			Data:       map[string][]byte{"auth": []byte(htpasswd)},
+		}
+	}
+	withAuth := func(htpasswd string) IngressUpdate {
+		return withEntries(createLbEntriesFixture(), func(e *IngressEntry) {
+			e.BasicAuth = &BasicAuth{Secret: ingressNamespace + "/htpasswd", Htpasswd: []byte(htpasswd)}
+		})
+	}
+
+	updater.On("Start").Return(nil)
+	updater.On("Stop").Return(nil)
+	updater.On("Update", withAuth("user:old")).Return(nil).Once()
+	updater.On("Update", withAuth("user:new")).Return(nil).Once()
+	client.On("GetIngresses").Return(ingresses, nil)
+	client.On("GetServices").Return(createDefaultServices(), nil)
+	client.On("GetSecret", ingressNamespace, "htpasswd").Return(secret("user:old"), nil).Once()
+	client.On("GetSecret", ingressNamespace, "htpasswd").Return(secret("user:new"), nil)
+	ingressWatcher, ingressCh, _ := createFakeWatcher()
+	serviceWatcher, _, _ := createFakeWatcher()
+	client.On("WatchIngresses").Return(ingressWatcher)
+	client.On("WatchServices").Return(serviceWatcher)
+
+	// when
+	assert.NoError(controller.Start())
+	ingressCh <- struct{}{}
+	time.Sleep(smallWaitTime)
+
+	// then
+	assert.NoError(controller.Stop())
+	updater.AssertExpectations(t)
+}
+
+func TestSecretsThatCantBeReadKeepTheirLastKnownValue(t *testing.T) {
+	// given
+	assert := assert.New(t)
+	client := new(fake.FakeClient)
+	refs := []secretRef{{ingressNamespace, "htpasswd"}, {ingressNamespace, "ca"}}
+	c := &controller{client: client, secretRefs: refs}
+	htpasswd := k8s.Secret{
+		ObjectMeta: k8s.ObjectMeta{Namespace: ingressNamespace, Name: "htpasswd"},
+		Data:       map[string][]byte{"auth": []byte("user:pass")},
+	}
+	client.On("GetSecret", ingressNamespace, "htpasswd").Return(&htpasswd, nil).Once()
+	client.On("GetSecret", ingressNamespace, "htpasswd").Return(nil, errors.New("apiserver unavailable"))
+	client.On("GetSecret", ingressNamespace, "ca").Return(nil, errors.New("apiserver unavailable"))
+
+	// when
+	c.secrets = c.readSecrets(refs)
+	changed := c.secretsChanged()
+
+	// then
+	assert.Equal(map[string]k8s.Secret{ingressNamespace + "/htpasswd": htpasswd}, c.readSecrets(refs))
+	assert.False(changed)
+}
+
+func TestOnlyReferencedSecretsAreRead(t *testing.T) {
+	ingresses := []k8s.Ingress{
+		{ObjectMeta: k8s.ObjectMeta{Namespace: "a", Annotations: map[string]string{
+			authSecretAnnotation: "htpasswd", backendTLSSecretAnnotation: "ca"}}},
+		{ObjectMeta: k8s.ObjectMeta{Namespace: "a", Annotations: map[string]string{authSecretAnnotation: "htpasswd"}}},
+		{ObjectMeta: k8s.ObjectMeta{Namespace: "b", Annotations: map[string]string{authSecretAnnotation: "htpasswd"}}},
+		{ObjectMeta: k8s.ObjectMeta{Namespace: "c", Annotations: map[string]string{authSecretAnnotation: ""}}},
+		{ObjectMeta: k8s.ObjectMeta{Namespace: "d"}},
+	}
+
+	assert.Equal(t, []secretRef{{"a", "htpasswd"}, {"a", "ca"}, {"b", "htpasswd"}}, referencedSecrets(ingresses))
+}
+
+func TestBasicAuthNeedsAnExistingSecret(t *testing.T) {
+	assert := assert.New(t)
+	ingress := k8s.Ingress{ObjectMeta: k8s.ObjectMeta{Namespace: "ns", Annotations: map[string]string{}}}
+	secrets := mapSecretsByName([]k8s.Secret{
+		{ObjectMeta: k8s.ObjectMeta{Namespace: "ns", Name: "htpasswd"}, Data: map[string][]byte{"auth": []byte("a:b")}},
+		{ObjectMeta: k8s.ObjectMeta{Namespace: "ns", Name: "empty"}, Data: map[string][]byte{"other": []byte("a:b")}},
+		{ObjectMeta: k8s.ObjectMeta{Namespace: "other", Name: "elsewhere"}, Data: map[string][]byte{"auth": []byte("a:b")}},
+	})
+
+	var tests = []struct {
+		authType string
+		secret   string
+		valid    bool
+	}{
+		{AuthTypeBasic, "htpasswd", true},
+		{AuthTypeBasic, "empty", false},
+		{AuthTypeBasic, "missing", false},
+		{AuthTypeBasic, "elsewhere", false},
+		{AuthTypeBasic, "", false},
+		{"digest", "htpasswd", false},
+	}
+
+	for _, test := range tests {
+		ingress.Annotations[authTypeAnnotation] = test.authType
+		ingress.Annotations[authSecretAnnotation] = test.secret
+		auth, err := basicAuth(ingress, secrets)
+		if test.valid {
+			assert.NoError(err, "%v", test)
+			assert.Equal(&BasicAuth{Secret: "ns/htpasswd", Htpasswd: []byte("a:b")}, auth)
+		} else {
+			assert.Error(err, "%v", test)
+		}
+	}
+
+	assert.Equal("basic auth from ns/htpasswd", fmt.Sprintf("%v", IngressEntry{BasicAuth: &BasicAuth{
+		Secret: "ns/htpasswd", Htpasswd: []byte("a:b")}}.BasicAuth))
+}
+
+func TestExternalAuthAnnotations(t *testing.T) {
+	assert := assert.New(t)
+
+	var tests = []struct {
+		annotations map[string]string
+		expected    *ExternalAuth
+		valid       bool
+	}{
+		{map[string]string{}, nil, true},
+		{map[string]string{authURLAnnotation: "http://auth.svc:8080"},
+			&ExternalAuth{URL: "http://auth.svc:8080/"}, true},
+		{map[string]string{authURLAnnotation: "https://sso.sky.com/auth?app=foo",
+			authSigninAnnotation: "https://sso.sky.com/signin?app=foo", authResponseHeadersAnnotation: "X-User"},
+			&ExternalAuth{URL: "https://sso.sky.com/auth?app=foo", SigninURL: "https://sso.sky.com/signin?app=foo",
+				ResponseHeaders: []string{"X-User"}}, true},
+		{map[string]string{authSigninAnnotation: "https://sso.sky.com/signin"}, nil, false},
+		{map[string]string{authResponseHeadersAnnotation: "X-User"}, nil, false},
+		{map[string]string{authURLAnnotation: "sso.sky.com/auth"}, nil, false},
+		{map[string]string{authURLAnnotation: "ftp://sso.sky.com/auth"}, nil, false},
+		{map[string]string{authURLAnnotation: "https://sso.sky.com/$uri"}, nil, false},
+		{map[string]string{authURLAnnotation: "https://sso.sky.com/auth; return 200"}, nil, false},
+		{map[string]string{authURLAnnotation: "https://sso.sky.com/auth", authSigninAnnotation: "/signin"}, nil, false},
+		{map[string]string{authURLAnnotation: "https://sso.sky.com/auth", authResponseHeadersAnnotation: "X-User,"},
+			nil, false},
+		{map[string]string{authURLAnnotation: "https://sso.sky.com/auth", authResponseHeadersAnnotation: "X User"},
+			nil, false},
+	}
+
+	for _, test := range tests {
+		auth, err := externalAuth(test.annotations)
+		if test.valid {
+			assert.NoError(err, "%v", test.annotations)
+			assert.Equal(test.expected, auth, "%v", test.annotations)
+		} else {
+			assert.Error(err, "%v", test.annotations)
+		}
+	}
+}
+
+// withAnnotation sets the annotation on all the ingresses.
+func withAnnotation(ingresses []k8s.Ingress, key, value string) []k8s.Ingress {
+	for _, ingress := range ingresses {
+		ingress.Annotations[key] = value
+	}
+	return ingresses
+}
+
+func withStatus(ingresses []k8s.Ingress, lbs ...k8s.LoadBalancerIngress) []k8s.Ingress {
+	for i := range ingresses {
+		ingresses[i].Status.LoadBalancer.Ingress = lbs
+	}
+	return ingresses
+}
+
+// withEntries modifies all the entries in the update.
+func withEntries(update IngressUpdate, modify func(*IngressEntry)) IngressUpdate {
+	for i := range update.Entries {
+		modify(&update.Entries[i])
+	}
+	return update
+}
+
 func createLbEntriesFixture() IngressUpdate {
 	return IngressUpdate{Entries: []IngressEntry{{
 		Name:           ingressNamespace + "/" + ingressName,
@@ -441,6 +1029,27 @@ func createIngressesFixture(host string, serviceName string, servicePort int, al
 	}
 }
 
+func createLoadBalancerService(annotations map[string]string, lbs ...k8s.LoadBalancerIngress) k8s.Service {
+	return k8s.Service{
+		ObjectMeta: k8s.ObjectMeta{
+			Name:        "lb-service",
+			Namespace:   ingressNamespace,
+			Annotations: annotations,
+		},
+		Spec: k8s.ServiceSpec{
+			Type: k8s.ServiceTypeLoadBalancer,
+		},
+		Status: k8s.ServiceStatus{LoadBalancer: k8s.LoadBalancerStatus{Ingress: lbs}},
+	}
+}
+
+func withServiceAnnotation(services []k8s.Service, key, value string) []k8s.Service {
+	for i := range services {
+		services[i].Annotations = map[string]string{key: value}
+	}
+	return services
+}
+
 func createDefaultServices() []k8s.Service {
 	return createServiceFixture(ingressSvcName, ingressNamespace, serviceIP)
 }
@@ -0,0 +1,78 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+const (
+	maxHostLength  = 253
+	maxLabelLength = 63
+)
+
+// NormaliseHost returns the host in the form used for nginx server names and DNS records: lowercase, with
+// internationalised labels mapped and punycode encoded using the IDNA lookup rules, and without a trailing dot.
+// It returns an error if the host isn't a valid DNS name. A wildcard is only allowed as the whole leftmost label.
+func NormaliseHost(host string) (string, error) {
+	if !utf8.ValidString(host) {
+		return "", fmt.Errorf("host %q is not valid UTF-8", host)
+	}
+	name := strings.TrimSuffix(host, ".")
+	if name == "" {
+		return "", fmt.Errorf("host %q is empty", host)
+	}
+
+	labels := strings.Split(name, ".")
+	wildcard := labels[0] == "*"
+	if wildcard {
+		labels = labels[1:]
+		if len(labels) == 0 {
+			return "", fmt.Errorf("host %q is not a valid DNS name: wildcard * must be followed by a domain", host)
+		}
+	}
+	name = strings.Join(labels, ".")
+	if strings.Contains(name, "*") {
+		return "", fmt.Errorf("host %q is not a valid DNS name: wildcard * must only be the whole leftmost label", host)
+	}
+
+	ascii, err := idna.Lookup.ToASCII(name)
+	if err != nil {
+		return "", fmt.Errorf("host %q is not a valid DNS name: %v", host, err)
+	}
+	labels = strings.Split(ascii, ".")
+	for _, label := range labels {
+		if err := validateLabel(label); err != nil {
+			return "", fmt.Errorf("host %q is not a valid DNS name: %v", host, err)
+		}
+	}
+
+	if wildcard {
+		labels = append([]string{"*"}, labels...)
+	}
+	name = strings.Join(labels, ".")
+	if len(name) > maxHostLength {
+		return "", fmt.Errorf("host %q is longer than %d characters", host, maxHostLength)
+	}
+	return name, nil
+}
+
+func validateLabel(label string) error {
+	if label == "" {
+		return fmt.Errorf("empty label")
+	}
+	if len(label) > maxLabelLength {
+		return fmt.Errorf("label %q is longer than %d characters", label, maxLabelLength)
+	}
+	if label[0] == '-' || label[len(label)-1] == '-' {
+		return fmt.Errorf("label %q starts or ends with a hyphen", label)
+	}
+	for _, c := range []byte(label) {
+		if !('a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-') {
+			return fmt.Errorf("label %q has invalid character %q", label, c)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormaliseHost(t *testing.T) {
+	var tests = []struct {
+		host       string
+		normalised string
+	}{
+		{"foo.sky.com", "foo.sky.com"},
+		{"Foo.SKY.com", "foo.sky.com"},
+		{"foo.sky.com.", "foo.sky.com"},
+		{"*.apps.sky.com", "*.apps.sky.com"},
+		{"bücher.sky.com", "xn--bcher-kva.sky.com"},
+		{"MÜNCHEN.sky.com", "xn--mnchen-3ya.sky.com"},
+		{"foo.中国", "foo.xn--fiqs8s"},
+		{"日本語.sky.com", "xn--wgv71a119e.sky.com"},
+		{"xn--bcher-kva.sky.com", "xn--bcher-kva.sky.com"},
+		{"XN--BCHER-KVA.sky.com", "xn--bcher-kva.sky.com"},
+		{"bu\u0308cher.sky.com", "xn--bcher-kva.sky.com"},
+		{"ｆｏｏ.sky.com", "foo.sky.com"},
+		{"a-1.sky.com", "a-1.sky.com"},
+	}
+
+	for _, test := range tests {
+		normalised, err := NormaliseHost(test.host)
+		assert.NoError(t, err, test.host)
+		assert.Equal(t, test.normalised, normalised, test.host)
+	}
+}
+
+func TestNormaliseHostRejectsInvalidNames(t *testing.T) {
+	longLabel := "a123456789012345678901234567890123456789012345678901234567890123"
+	longHost := longLabel[:60] + "." + longLabel[:60] + "." + longLabel[:60] + "." + longLabel[:60] + "." + longLabel[:60] + ".sky.com"
+
+	for _, host := range []string{
+		"",
+		".",
+		"*",
+		"*.",
+		"foo..sky.com",
+		".foo.sky.com",
+		"foo.*.sky.com",
+		"foo*.sky.com",
+		"*.*.sky.com",
+		"-foo.sky.com",
+		"foo-.sky.com",
+		"foo_bar.sky.com",
+		"foo bar.sky.com",
+		"foo.sky.com:80",
+		"xn--a.sky.com",
+		"xn--zz.sky.com",
+		"xn--bcher-kvb.sky.com",
+		longLabel + ".sky.com",
+		longHost,
+		"\xff.sky.com",
+	} {
+		_, err := NormaliseHost(host)
+		assert.Error(t, err, host)
+	}
+}
@@ -8,6 +8,22 @@ import (
 // IngressUpdate data
 type IngressUpdate struct {
 	Entries []IngressEntry
+	// Services are the services of type LoadBalancer that want DNS records.
+	Services []ServiceEntry
+}
+
+// ServiceEntry describes the DNS names of a service of type LoadBalancer, which point at its own load balancer.
+type ServiceEntry struct {
+	// Name of the entry.
+	Name string
+	// Hosts are the fully qualified domain names that point at the service's load balancer.
+	Hosts []string
+	// ELbScheme is internal or internet-facing, depending on the service's load balancer.
+	ELbScheme string
+	// LoadBalancerAddresses are the hostnames or IPs of the service's load balancer.
+	LoadBalancerAddresses []string
+	// DNSRouting is how DNS queries are routed when several clusters serve the same host.
+	DNSRouting DNSRouting
 }
 
 // IngressEntry describes the ingress for a single host, path, and service.
@@ -18,17 +34,83 @@ type IngressEntry struct {
 	Host string
 	// Path is the url path after the hostname. Must be non-empty.
 	Path string
+	// PathType is how Path is matched: PathTypeExact, PathTypePrefix or PathTypeRegex. If empty, Path is a
+	// directory prefix that's stripped when proxying, unless PreservePath is set.
+	PathType string
 	// ServiceAddress is a routable address for the Kubernetes backend service to proxy traffic to.
 	// Must be non-empty.
 	ServiceAddress string
 	// ServicePort is the port to proxy traffic to. Must be non-zero.
 	ServicePort int32
+	// BackendProtocol is how requests are proxied to the service, such as BackendProtocolGRPC. Empty uses
+	// BackendProtocolHTTP.
+	BackendProtocol string
+	// BackendTLS configures connections to HTTPS and GRPCS backends. Nil doesn't verify the backend.
+	BackendTLS *BackendTLS
 	// Allow are the ips or cidrs that are allowed to access the service.
 	Allow []string
 	// ElbScheme internet-facing or internal will dictate which kind of ELB to attach to
 	ELbScheme string
+	// DualStack adds IPv6 DNS records, in addition to IPv4, pointing at the frontend.
+	DualStack bool
+	// DNSRouting is how DNS queries are routed when several clusters serve the same host.
+	DNSRouting DNSRouting
+	// DNSIgnore stops DNS records being created for the host.
+	DNSIgnore bool
+	// DNSTTL is the TTL in seconds of DNS records for the host, for providers without aliases.
+	// Zero uses the provider's default.
+	DNSTTL int
+	// DNSAliases are extra hostnames that get DNS records pointing at the same frontend as the host.
+	DNSAliases []string
+	// DNSHealthCheckPath is the path requested by DNS health checks of the host. Defaults to Path.
+	DNSHealthCheckPath string
+	// LoadBalancerAddresses are the frontend hostnames or IPs published in the ingress status.
+	LoadBalancerAddresses []string
+	// ProxyConnectTimeoutSeconds is the timeout for connecting to the backend. Zero uses the proxy's default.
+	ProxyConnectTimeoutSeconds int
+	// ProxyReadTimeoutSeconds is the timeout between two reads from the backend. Zero uses the proxy's default.
+	ProxyReadTimeoutSeconds int
+	// ProxySendTimeoutSeconds is the timeout between two writes to the backend. Zero uses the proxy's default.
+	ProxySendTimeoutSeconds int
+	// PreservePath proxies the full request path, instead of stripping the Path prefix. Paths with a
+	// PathType are always proxied in full.
+	PreservePath bool
+	// RewriteRegex matches the request path to rewrite before proxying. Empty if the path isn't rewritten.
+	RewriteRegex string
+	// RewriteReplacement replaces the path matched by RewriteRegex, and can refer to its capture groups.
+	RewriteReplacement string
+	// RateLimitRPS is the maximum requests per second from each client. Zero doesn't limit the rate.
+	RateLimitRPS int
+	// RateLimitBurst is how many requests over the rate are allowed at once, before they're rejected.
+	RateLimitBurst int
+	// RateLimitHeader is the request header identifying clients for rate limiting. Empty uses the client IP.
+	RateLimitHeader string
+	// BasicAuth requires clients to authenticate as one of its users. Nil doesn't require authentication.
+	BasicAuth *BasicAuth
+	// WebSocket forwards requests to upgrade the connection, such as to WebSocket, with long timeouts by default.
+	WebSocket bool
+	// ExternalAuth requires requests to be allowed by an external auth service. Nil doesn't require it.
+	ExternalAuth *ExternalAuth
 }
 
+// DNSRouting is the routing policy of a cluster's DNS record, for hosts served by multiple clusters.
+type DNSRouting struct {
+	// Weight is the relative proportion of DNS queries answered with this cluster's record.
+	// Only used if Failover is empty.
+	Weight int64
+	// Failover is PRIMARY or SECONDARY, to only answer with a secondary record if the primary is unhealthy.
+	Failover string
+}
+
+const (
+	// FailoverPrimary is the DNSRouting.Failover value for the record to use while healthy.
+	FailoverPrimary = "PRIMARY"
+	// FailoverSecondary is the DNSRouting.Failover value for the record to use if the primary is unhealthy.
+	FailoverSecondary = "SECONDARY"
+	// MaxDNSWeight is the largest allowed DNSRouting.Weight.
+	MaxDNSWeight = 255
+)
+
 // validate returns error if entry has invalid fields.
 func (entry IngressEntry) validate() error {
 	if entry.Host == "" {
@@ -48,7 +130,7 @@ func (u IngressUpdate) SortedByName() IngressUpdate {
 	sortedEntries := make([]IngressEntry, len(u.Entries))
 	copy(sortedEntries, u.Entries)
 	sort.Sort(byName(sortedEntries))
-	return IngressUpdate{Entries: sortedEntries}
+	return IngressUpdate{Entries: sortedEntries, Services: u.Services}
 }
 
 type byName []IngressEntry
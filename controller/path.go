@@ -0,0 +1,39 @@
+package controller
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+const (
+	// PathTypeExact only matches requests for the path itself.
+	PathTypeExact = "Exact"
+	// PathTypePrefix matches requests starting with the path, in preference to any Regex paths.
+	PathTypePrefix = "Prefix"
+	// PathTypeRegex matches requests with the path as a regex.
+	PathTypeRegex = "Regex"
+)
+
+// validatePath returns an error if the path can't be matched as the path type, or safely quoted in the
+// nginx config.
+func validatePath(pathType, path string) error {
+	if strings.IndexFunc(path, unicode.IsSpace) >= 0 || strings.Contains(path, `"`) {
+		return fmt.Errorf("path %q must not contain spaces or quotes", path)
+	}
+	switch pathType {
+	case PathTypeExact, PathTypePrefix:
+		if path != "" && !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("%s path %q must start with /", pathType, path)
+		}
+	case PathTypeRegex:
+		if _, err := regexp.Compile(path); err != nil {
+			return fmt.Errorf("path %q is an invalid regex: %v", path, err)
+		}
+	default:
+		return fmt.Errorf("%s must be %s, %s or %s, but was %q", pathTypeAnnotation, PathTypeExact,
+			PathTypePrefix, PathTypeRegex, pathType)
+	}
+	return nil
+}
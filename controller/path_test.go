@@ -0,0 +1,37 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidatesPaths(t *testing.T) {
+	assert := assert.New(t)
+
+	var tests = []struct {
+		pathType string
+		path     string
+		valid    bool
+	}{
+		{PathTypeExact, "/healthz", true},
+		{PathTypeExact, "", true},
+		{PathTypeExact, "healthz", false},
+		{PathTypePrefix, "/api", true},
+		{PathTypePrefix, "/api v2", false},
+		{PathTypeRegex, "^/api/v[0-9]{1,2}/", true},
+		{PathTypeRegex, "^/api/(v1", false},
+		{PathTypeRegex, "/api\";", false},
+		{"exact", "/healthz", false},
+		{"", "/healthz", false},
+	}
+
+	for _, test := range tests {
+		err := validatePath(test.pathType, test.path)
+		if test.valid {
+			assert.NoError(err, "%s %s", test.pathType, test.path)
+		} else {
+			assert.Error(err, "%s %s", test.pathType, test.path)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	rateLimitKeyIP     = "ip"
+	rateLimitKeyHeader = "header:"
+)
+
+// parseRateLimit sets the rate limit of the entry from the ingress's annotations.
+func parseRateLimit(entry *IngressEntry, annotations map[string]string) error {
+	rps, ok := annotations[rateLimitRPSAnnotation]
+	if !ok {
+		for _, annotation := range []string{rateLimitBurstAnnotation, rateLimitKeyAnnotation} {
+			if _, ok := annotations[annotation]; ok {
+				return fmt.Errorf("%s needs %s to be set", annotation, rateLimitRPSAnnotation)
+			}
+		}
+		return nil
+	}
+
+	parsedRPS, err := strconv.Atoi(rps)
+	if err != nil || parsedRPS <= 0 {
+		return fmt.Errorf("%s must be a positive number of requests per second, but was %q",
+			rateLimitRPSAnnotation, rps)
+	}
+
+	var parsedBurst int
+	if burst, ok := annotations[rateLimitBurstAnnotation]; ok {
+		parsedBurst, err = strconv.Atoi(burst)
+		if err != nil || parsedBurst < 0 {
+			return fmt.Errorf("%s must be a non-negative number of requests, but was %q",
+				rateLimitBurstAnnotation, burst)
+		}
+	}
+
+	var header string
+	if key, ok := annotations[rateLimitKeyAnnotation]; ok && key != rateLimitKeyIP {
+		if !strings.HasPrefix(key, rateLimitKeyHeader) || !validHeaderName(key[len(rateLimitKeyHeader):]) {
+			return fmt.Errorf("%s must be %s or %s<header name>, but was %q", rateLimitKeyAnnotation,
+				rateLimitKeyIP, rateLimitKeyHeader, key)
+		}
+		header = key[len(rateLimitKeyHeader):]
+	}
+
+	entry.RateLimitRPS = parsedRPS
+	entry.RateLimitBurst = parsedBurst
+	entry.RateLimitHeader = header
+	return nil
+}
+
+// validHeaderName allows the header names nginx exposes as $http_ variables.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range []byte(name) {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,55 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsesRateLimits(t *testing.T) {
+	assert := assert.New(t)
+
+	var tests = []struct {
+		annotations map[string]string
+		expected    IngressEntry
+	}{
+		{
+			map[string]string{},
+			IngressEntry{},
+		},
+		{
+			map[string]string{rateLimitRPSAnnotation: "10"},
+			IngressEntry{RateLimitRPS: 10},
+		},
+		{
+			map[string]string{rateLimitRPSAnnotation: "10", rateLimitBurstAnnotation: "20", rateLimitKeyAnnotation: "ip"},
+			IngressEntry{RateLimitRPS: 10, RateLimitBurst: 20},
+		},
+		{
+			map[string]string{rateLimitRPSAnnotation: "5", rateLimitKeyAnnotation: "header:X-Api-Key"},
+			IngressEntry{RateLimitRPS: 5, RateLimitHeader: "X-Api-Key"},
+		},
+	}
+
+	for _, test := range tests {
+		var entry IngressEntry
+		assert.NoError(parseRateLimit(&entry, test.annotations), "%v", test.annotations)
+		assert.Equal(test.expected, entry, "%v", test.annotations)
+	}
+}
+
+func TestRejectsInvalidRateLimits(t *testing.T) {
+	for _, annotations := range []map[string]string{
+		{rateLimitRPSAnnotation: "0"},
+		{rateLimitRPSAnnotation: "lots"},
+		{rateLimitRPSAnnotation: "10", rateLimitBurstAnnotation: "-1"},
+		{rateLimitRPSAnnotation: "10", rateLimitKeyAnnotation: "cookie:session"},
+		{rateLimitRPSAnnotation: "10", rateLimitKeyAnnotation: "header:"},
+		{rateLimitRPSAnnotation: "10", rateLimitKeyAnnotation: "header:X-Api-Key;"},
+		{rateLimitBurstAnnotation: "10"},
+	} {
+		var entry IngressEntry
+		assert.Error(t, parseRateLimit(&entry, annotations), "%v", annotations)
+		assert.Equal(t, IngressEntry{}, entry, "%v", annotations)
+	}
+}
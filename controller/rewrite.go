@@ -0,0 +1,40 @@
+package controller
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var captureReference = regexp.MustCompile(`\$([0-9])`)
+
+// parseRewrite splits the rewrite annotation into a regex and its replacement, as in nginx's rewrite directive.
+// It returns an error if the regex doesn't compile, the replacement refers to a missing capture group, or
+// either can't be safely quoted in the nginx config.
+func parseRewrite(rewrite string) (string, string, error) {
+	fields := strings.Fields(rewrite)
+	if len(fields) != 2 {
+		return "", "", fmt.Errorf("%s must be a regex and a replacement separated by a space, but was %q",
+			rewriteTargetAnnotation, rewrite)
+	}
+	regex, replacement := fields[0], fields[1]
+
+	if strings.Contains(rewrite, `"`) {
+		return "", "", fmt.Errorf("%s must not contain quotes, but was %q", rewriteTargetAnnotation, rewrite)
+	}
+	compiled, err := regexp.Compile(regex)
+	if err != nil {
+		return "", "", fmt.Errorf("%s has an invalid regex: %v", rewriteTargetAnnotation, err)
+	}
+	if !strings.HasPrefix(replacement, "/") {
+		return "", "", fmt.Errorf("%s replacement must start with /, but was %q", rewriteTargetAnnotation, replacement)
+	}
+	for _, ref := range captureReference.FindAllStringSubmatch(replacement, -1) {
+		if group, _ := strconv.Atoi(ref[1]); group > compiled.NumSubexp() {
+			return "", "", fmt.Errorf("%s replacement refers to $%d, but the regex only has %d capture groups",
+				rewriteTargetAnnotation, group, compiled.NumSubexp())
+		}
+	}
+	return regex, replacement, nil
+}
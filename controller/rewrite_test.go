@@ -0,0 +1,45 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsesValidRewrites(t *testing.T) {
+	assert := assert.New(t)
+
+	var tests = []struct {
+		rewrite     string
+		regex       string
+		replacement string
+	}{
+		{"^/api/(.*)$ /v2/$1", "^/api/(.*)$", "/v2/$1"},
+		{"  ^/(\\w+)/(\\d{2,4})  /$2/$1?", "^/(\\w+)/(\\d{2,4})", "/$2/$1?"},
+		{"^/old /new", "^/old", "/new"},
+	}
+
+	for _, test := range tests {
+		regex, replacement, err := parseRewrite(test.rewrite)
+		assert.NoError(err, test.rewrite)
+		assert.Equal(test.regex, regex, test.rewrite)
+		assert.Equal(test.replacement, replacement, test.rewrite)
+	}
+}
+
+func TestRejectsInvalidRewrites(t *testing.T) {
+	for _, rewrite := range []string{
+		"",
+		"^/api/(.*)$",
+		"^/a /b /c",
+		"^/api/((.*)$ /$1",
+		"^/(?=lookahead) /",
+		"^/api/(.*)$ v2/$1",
+		"^/api/(.*)$ /v2/$2",
+		"^/api\" /v2",
+		"^/api /v2\";return",
+	} {
+		_, _, err := parseRewrite(rewrite)
+		assert.Error(t, err, rewrite)
+	}
+}
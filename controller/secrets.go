@@ -0,0 +1,96 @@
+package controller
+
+import (
+	"fmt"
+	"reflect"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/sky-uk/feed/k8s"
+)
+
+// secretAnnotations name secrets in the ingress's namespace.
+var secretAnnotations = []string{authSecretAnnotation, backendTLSSecretAnnotation}
+
+type secretRef struct {
+	namespace string
+	name      string
+}
+
+func (r secretRef) String() string {
+	return r.namespace + "/" + r.name
+}
+
+// referencedSecrets returns the secrets named by annotations of the ingresses, so only those need to be read.
+func referencedSecrets(ingresses []k8s.Ingress) []secretRef {
+	var refs []secretRef
+	seen := make(map[secretRef]bool)
+	for _, ingress := range ingresses {
+		for _, annotation := range secretAnnotations {
+			name := ingress.Annotations[annotation]
+			ref := secretRef{namespace: ingress.Namespace, name: name}
+			if name == "" || seen[ref] {
+				continue
+			}
+			seen[ref] = true
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// readSecrets gets each of the secrets, keyed by namespace/name. Secrets that don't exist are left out, so only
+// the entries using them are skipped. Secrets that can't be read keep their last known value, so an apiserver
+// error doesn't take those entries away.
+func (c *controller) readSecrets(refs []secretRef) map[string]k8s.Secret {
+	var secrets []k8s.Secret
+	for _, ref := range refs {
+		secret, err := c.client.GetSecret(ref.namespace, ref.name)
+		if err != nil {
+			if last, ok := c.secrets[ref.String()]; ok {
+				log.Warnf("Unable to read secret %v, so using its last known value: %v", ref, err)
+				secrets = append(secrets, last)
+			} else {
+				log.Warnf("Unable to read secret %v: %v", ref, err)
+			}
+			continue
+		}
+		if secret != nil {
+			secrets = append(secrets, *secret)
+		}
+	}
+	return mapSecretsByName(secrets)
+}
+
+// secretsChanged returns true if any of the secrets used by the last update have changed, been created, or
+// been deleted, as secrets aren't watched.
+func (c *controller) secretsChanged() bool {
+	if len(c.secretRefs) == 0 {
+		return false
+	}
+	return !reflect.DeepEqual(c.readSecrets(c.secretRefs), c.secrets)
+}
+
+// lookupSecret returns the namespace/name of a secret in the ingress's namespace, and the secret itself. Secrets
+// are keyed by namespace/name, and are nil if they aren't being read. In that case the secret is nil too, so
+// updaters that don't need its contents, such as feed-dns, still get the entry, and those that do can reject it.
+func lookupSecret(ingress k8s.Ingress, secretName string, secrets map[string]k8s.Secret) (string, *k8s.Secret,
+	error) {
+
+	name := ingress.Namespace + "/" + secretName
+	if secrets == nil {
+		return name, nil, nil
+	}
+	secret, ok := secrets[name]
+	if !ok {
+		return name, nil, fmt.Errorf("secret %s not found", name)
+	}
+	return name, &secret, nil
+}
+
+func mapSecretsByName(secrets []k8s.Secret) map[string]k8s.Secret {
+	m := make(map[string]k8s.Secret)
+	for _, secret := range secrets {
+		m[secret.Namespace+"/"+secret.Name] = secret
+	}
+	return m
+}
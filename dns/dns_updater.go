@@ -2,6 +2,12 @@ package dns
 
 import (
 	"fmt"
+	"hash/fnv"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
 
 	"strings"
 
@@ -10,98 +16,960 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	aws_elb "github.com/aws/aws-sdk-go/service/elb"
 	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sky-uk/feed/controller"
 	"github.com/sky-uk/feed/dns/r53"
+	"github.com/sky-uk/feed/dns/rfc2136"
+	"github.com/sky-uk/feed/dns/zonefile"
 	"github.com/sky-uk/feed/elb"
+	"github.com/sky-uk/feed/util"
 )
 
+var propagationHistogram = prometheus.NewHistogram(prometheus.HistogramOpts{
+	Namespace: util.PrometheusNamespace,
+	Subsystem: util.PrometheusDNSSubsystem,
+	Name:      "change_propagation_seconds",
+	Help:      "The time taken for Route53 changes to be in sync after being submitted.",
+	Buckets:   []float64{5, 10, 20, 30, 45, 60, 90, 120, 180, 300},
+})
+
+var pendingChangesGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: util.PrometheusNamespace,
+	Subsystem: util.PrometheusDNSSubsystem,
+	Name:      "pending_changes",
+	Help:      "The number of submitted Route53 changes that are not yet in sync.",
+})
+
+func init() {
+	prometheus.MustRegister(propagationHistogram)
+	prometheus.MustRegister(pendingChangesGauge)
+}
+
 type findElbs func(elb.ELB, string) (map[string]elb.LoadBalancerDetails, error)
+type findHostedZoneIDs func(region string, domains []string) ([]string, error)
+type newR53Client func(region, hostedZone string) r53.Route53Client
 
-type updater struct {
-	r53Sdk       r53.Route53Client
-	elb          elb.ELB
-	frontends    map[string]elb.LoadBalancerDetails
-	elbLabelName string
+const (
+	internalScheme  = "internal"
+	dualStackPrefix = "dualstack."
+
+	healthCheckInterval         = 30
+	healthCheckFailureThreshold = 3
+
+	graceRecordPrefix = "_feed-dns-grace."
+	graceRecordTTL    = 300
+	// maxTXTStringLength is the longest character string allowed in a TXT record value.
+	maxTXTStringLength = 255
+
+	serviceFrontendPrefix = "service:"
+)
+
+// elbHostedZoneIDs are the canonical hosted zone IDs of classic ELBs in each region, needed to alias an ELB
+// that is only known by its DNS name.
+var elbHostedZoneIDs = map[string]string{
+	"us-east-1":      "Z35SXDOTRQ7X7K",
+	"us-east-2":      "Z3AADJGX6KTTL2",
+	"us-west-1":      "Z368ELLRRE2KJ0",
+	"us-west-2":      "Z1H1FL5HABSF5",
+	"ca-central-1":   "ZQSVJUPU6J1EY",
+	"eu-west-1":      "Z32O12XQLNTSW2",
+	"eu-west-2":      "ZHURV8PSTC4K8",
+	"eu-central-1":   "Z215JYRZR1TBD5",
+	"ap-south-1":     "ZP97RAFLXTNZK",
+	"ap-southeast-1": "Z1LMS91P8CMLE5",
+	"ap-southeast-2": "Z1GM3OXH4ZPM65",
+	"ap-northeast-1": "Z14GRHDCWA56QT",
+	"ap-northeast-2": "ZWKZPGTI48KDX",
+	"sa-east-1":      "Z2P70J7HTTTPLU",
+}
+
+// nlbHostedZoneIDs are the canonical hosted zone IDs of network load balancers in each region.
+var nlbHostedZoneIDs = map[string]string{
+	"us-east-1":      "Z26RNL4JYFTOTI",
+	"us-east-2":      "ZLMOA37VPKANP",
+	"us-west-1":      "Z24FKFUX50B4VW",
+	"us-west-2":      "Z18D5FSROUN65G",
+	"eu-west-1":      "Z2IFOLAFXWLO4F",
+	"eu-west-2":      "ZD4D7Y8KGAS4G",
+	"eu-central-1":   "Z3F0SRJ5LGBH90",
+	"ap-southeast-1": "ZKVM4W9LS7TM",
+	"ap-southeast-2": "ZCT6FZBF4DROD",
+	"ap-northeast-1": "Z31USIVHYNEOWT",
+}
+
+// DNS providers that can be selected with Config.Provider.
+const (
+	// Route53Provider manages aliases to the front end ELBs in Route53 hosted zones.
+	Route53Provider = "route53"
+	// RFC2136Provider manages records pointing at the frontend addresses on a DNS server, using dynamic updates.
+	RFC2136Provider = "rfc2136"
+	// ZoneFileProvider writes records pointing at the frontend addresses to a zone file.
+	ZoneFileProvider = "zonefile"
+)
+
+// Config for creating a new dns updater.
+type Config struct {
+	// Provider is the DNS backend to update. Defaults to Route53Provider.
+	Provider string
+	// HostedZones are the IDs of the Route53 hosted zones to manage.
+	HostedZones []string
+	// HostedZoneDomains are domains whose hosted zones, public and private, will be looked up and managed.
+	HostedZoneDomains []string
+	// ElbRegion is the AWS region of the front end ELBs and hosted zones.
+	ElbRegion string
+	// ElbLabelValue is the value of the elb.ElbTag tag identifying the front end ELBs.
+	ElbLabelValue string
+	// FrontendsFromIngressStatus takes the front end ELB of each scheme from the ingress status, as published
+	// by feed-ingress, instead of looking up ELBs by tag.
+	FrontendsFromIngressStatus bool
+	// ElbHostedZoneID is the canonical hosted zone ID of the front end ELBs, used with FrontendsFromIngressStatus.
+	// Defaults to the ID for ElbRegion.
+	ElbHostedZoneID string
+	// SyncPollInterval is how often submitted changes are checked for propagation. Zero disables checking.
+	SyncPollInterval time.Duration
+	// WaitForSync makes the updater unhealthy while submitted changes have not propagated.
+	WaitForSync bool
+	// SetIdentifier identifies this cluster's records when several clusters serve the same hosts, using
+	// weighted or failover routing. Records with other identifiers are left alone. Leave empty for simple routing.
+	SetIdentifier string
+	// MaxDeletionPercent is the largest percentage of a hosted zone's records that a single update may
+	// delete, once it deletes at least MinMassDeletion records. Larger deletions are refused. Zero disables
+	// the check.
+	MaxDeletionPercent int
+	// HealthChecks creates a Route53 HTTP health check for each host, associated with its records.
+	HealthChecks bool
+	// HealthCheckPort is the port that health checks connect to.
+	HealthCheckPort int
+	// DeletionGracePeriod is how long records of removed hosts are kept before being deleted, in case the
+	// hosts come back. Zero deletes records immediately.
+	DeletionGracePeriod time.Duration
+	// FrontendAddresses are the addresses of the frontends by scheme, for providers without ELB aliases.
+	// Each is either IP addresses, for A and AAAA records, or a single hostname, for a CNAME record.
+	FrontendAddresses map[string][]string
+	// TTL of records, for providers without ELB aliases.
+	TTL int
+	// RFC2136 configures the RFC2136Provider.
+	RFC2136 rfc2136.Conf
+	// ZoneFile configures the ZoneFileProvider.
+	ZoneFile zonefile.Conf
+}
+
+type hostedZone struct {
+	id           string
 	domain       string
-	findElbs     findElbs
+	private      bool
+	r53Sdk       r53.Route53Client
+	healthChecks []*route53.HealthCheck
+	// missingSince is when each record awaiting deletion was first found to be unwanted.
+	missingSince map[recordKey]time.Time
+	// graceRecord is the TXT record that persists missingSince.
+	graceRecord *route53.ResourceRecordSet
+}
+
+type pendingChange struct {
+	id        string
+	zone      *hostedZone
+	submitted time.Time
+}
+
+type updater struct {
+	zones             []*hostedZone
+	hostedZoneIDs     []string
+	hostedZoneDomains []string
+	region            string
+	elb               elb.ELB
+	frontends         map[string]elb.LoadBalancerDetails
+	elbLabelName      string
+	frontendsInStatus bool
+	elbHostedZoneID   string
+	findElbs          findElbs
+	findHostedZoneIDs findHostedZoneIDs
+	newR53Client      newR53Client
+	syncPollInterval  time.Duration
+	waitForSync       bool
+	maxDeletionPct    int
+	setIdentifier     string
+	healthChecks      bool
+	healthCheckPort   int
+	gracePeriod       time.Duration
+	now               func() time.Time
+	pending           []pendingChange
+	pendingLock       sync.Mutex
+	doneCh            chan struct{}
+	stopOnce          sync.Once
 }
 
-// New creates an updater for dns
-func New(r53HostedZone, elbRegion string, elbLabelName string) controller.Updater {
+// New creates an updater for dns, using the configured provider.
+func New(conf Config) controller.Updater {
+	switch conf.Provider {
+	case RFC2136Provider:
+		return newRecordUpdater(rfc2136.New(conf.RFC2136), conf)
+	case ZoneFileProvider:
+		return newRecordUpdater(zonefile.New(conf.ZoneFile), conf)
+	default:
+		return newRoute53Updater(conf)
+	}
+}
+
+func newRoute53Updater(conf Config) *updater {
 	return &updater{
-		r53Sdk:       r53.New(elbRegion, r53HostedZone),
-		elb:          aws_elb.New(session.New(&aws.Config{Region: &elbRegion})),
-		elbLabelName: elbLabelName,
-		findElbs:     elb.FindFrontEndElbs,
+		hostedZoneIDs:     conf.HostedZones,
+		hostedZoneDomains: conf.HostedZoneDomains,
+		region:            conf.ElbRegion,
+		elb:               aws_elb.New(session.New(&aws.Config{Region: &conf.ElbRegion})),
+		elbLabelName:      conf.ElbLabelValue,
+		frontendsInStatus: conf.FrontendsFromIngressStatus,
+		elbHostedZoneID:   elbHostedZoneID(conf),
+		findElbs:          elb.FindFrontEndElbs,
+		findHostedZoneIDs: r53.FindHostedZoneIDs,
+		newR53Client:      r53.New,
+		syncPollInterval:  conf.SyncPollInterval,
+		waitForSync:       conf.WaitForSync,
+		maxDeletionPct:    conf.MaxDeletionPercent,
+		setIdentifier:     conf.SetIdentifier,
+		healthChecks:      conf.HealthChecks,
+		healthCheckPort:   conf.HealthCheckPort,
+		gracePeriod:       conf.DeletionGracePeriod,
+		now:               time.Now,
+		doneCh:            make(chan struct{}),
+	}
+}
+
+func elbHostedZoneID(conf Config) string {
+	if conf.ElbHostedZoneID != "" {
+		return conf.ElbHostedZoneID
 	}
+	return elbHostedZoneIDs[conf.ElbRegion]
 }
 
 func (u *updater) Start() error {
 	log.Info("Starting dns updater")
-	frontEnds, err := u.findElbs(u.elb, u.elbLabelName)
-	if err != nil {
-		return fmt.Errorf("unable to find front end load balancers: %v", err)
+	if u.frontendsInStatus {
+		if u.elbHostedZoneID == "" {
+			return fmt.Errorf("unknown ELB hosted zone ID for region %s", u.region)
+		}
+	} else {
+		frontEnds, err := u.findElbs(u.elb, u.elbLabelName)
+		if err != nil {
+			return fmt.Errorf("unable to find front end load balancers: %v", err)
+		}
+		u.frontends = frontEnds
 	}
-	u.frontends = frontEnds
-	u.domain, err = u.r53Sdk.GetHostedZoneDomain()
 
+	zoneIDs := u.hostedZoneIDs
+	if len(u.hostedZoneDomains) > 0 {
+		foundIDs, err := u.findHostedZoneIDs(u.region, u.hostedZoneDomains)
+		if err != nil {
+			return fmt.Errorf("unable to find hosted zones: %v", err)
+		}
+		zoneIDs = append(append([]string{}, zoneIDs...), foundIDs...)
+	}
+
+	zones, err := u.createHostedZones(zoneIDs)
 	if err != nil {
-		return fmt.Errorf("unable to get domain for hosted zone: %v", err)
+		return err
+	}
+	u.zones = zones
+
+	if u.syncPollInterval > 0 {
+		go u.periodicallyCheckPendingChanges()
 	}
 
 	log.Info("Dns updater started")
 	return nil
 }
 
+func (u *updater) createHostedZones(zoneIDs []string) ([]*hostedZone, error) {
+	var zones []*hostedZone
+	seen := make(map[string]bool)
+	for _, id := range zoneIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		zone := &hostedZone{id: id, r53Sdk: u.newR53Client(u.region, id)}
+		var err error
+		zone.domain, err = zone.r53Sdk.GetHostedZoneDomain()
+		if err != nil {
+			return nil, fmt.Errorf("unable to get domain for hosted zone: %v", err)
+		}
+		zone.private, err = zone.r53Sdk.IsPrivateHostedZone()
+		if err != nil {
+			return nil, fmt.Errorf("unable to get visibility of hosted zone: %v", err)
+		}
+
+		if u.healthChecks {
+			zone.healthChecks, err = zone.r53Sdk.GetHealthChecks(u.healthCheckPrefix(zone))
+			if err != nil {
+				return nil, fmt.Errorf("unable to get health checks for hosted zone: %v", err)
+			}
+		}
+
+		if u.gracePeriod > 0 {
+			if err := u.loadGraceRecord(zone); err != nil {
+				return nil, fmt.Errorf("unable to get pending deletions for hosted zone: %v", err)
+			}
+		}
+
+		log.Infof("Managing hosted zone %s for %s (private: %v)", zone.id, zone.domain, zone.private)
+		zones = append(zones, zone)
+	}
+	return zones, nil
+}
+
+func (u *updater) periodicallyCheckPendingChanges() {
+	ticker := time.NewTicker(u.syncPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-u.doneCh:
+			return
+		case <-ticker.C:
+			u.checkPendingChanges()
+		}
+	}
+}
+
+func (u *updater) addPendingChanges(zone *hostedZone, changeIDs []string) {
+	u.pendingLock.Lock()
+	defer u.pendingLock.Unlock()
+	for _, id := range changeIDs {
+		u.pending = append(u.pending, pendingChange{id: id, zone: zone, submitted: u.now()})
+	}
+	pendingChangesGauge.Set(float64(len(u.pending)))
+}
+
+// checkPendingChanges queries Route53 for the status of each pending change, and removes those in sync.
+func (u *updater) checkPendingChanges() {
+	u.pendingLock.Lock()
+	pending := make([]pendingChange, len(u.pending))
+	copy(pending, u.pending)
+	u.pendingLock.Unlock()
+
+	inSync := make(map[string]bool)
+	for _, change := range pending {
+		synced, err := change.zone.r53Sdk.IsChangeInSync(change.id)
+		if err != nil {
+			log.Warnf("Unable to check propagation of change %s: %v", change.id, err)
+			continue
+		}
+		if synced {
+			latency := u.now().Sub(change.submitted)
+			log.Infof("Change %s to hosted zone %s is in sync after %v", change.id, change.zone.id, latency)
+			propagationHistogram.Observe(latency.Seconds())
+			inSync[change.id] = true
+		}
+	}
+
+	u.pendingLock.Lock()
+	defer u.pendingLock.Unlock()
+	var stillPending []pendingChange
+	for _, change := range u.pending {
+		if !inSync[change.id] {
+			stillPending = append(stillPending, change)
+		}
+	}
+	u.pending = stillPending
+	pendingChangesGauge.Set(float64(len(u.pending)))
+}
+
 func (u *updater) Stop() error {
+	u.stopOnce.Do(func() { close(u.doneCh) })
 	return nil
 }
 
 func (u *updater) Health() error {
+	if !u.waitForSync {
+		return nil
+	}
+
+	u.pendingLock.Lock()
+	defer u.pendingLock.Unlock()
+	if len(u.pending) > 0 {
+		return fmt.Errorf("waiting for %d Route53 changes to be in sync", len(u.pending))
+	}
 	return nil
 }
 
 func (u *updater) Update(update controller.IngressUpdate) error {
-	aRecords, err := u.r53Sdk.GetARecords()
-	if err != nil {
-		log.Warn("Unable to get A records from Route53. Not updating Route53.", err)
-		return err
+	frontends := u.frontends
+	var waiting []controller.IngressEntry
+	if u.frontendsInStatus {
+		frontends, update, waiting = u.frontendsFromStatus(update)
+	}
+
+	zoneEntries := u.entriesByZone(update)
+	zoneServiceEntries, serviceFrontends := u.serviceEntriesByZone(update.Services)
+	if len(serviceFrontends) > 0 {
+		merged := make(map[string]elb.LoadBalancerDetails)
+		for key, frontend := range frontends {
+			merged[key] = frontend
+		}
+		for key, frontend := range serviceFrontends {
+			merged[key] = frontend
+		}
+		frontends = merged
+	}
+	var deletionErr error
+
+	for _, zone := range u.zones {
+		records, err := zone.r53Sdk.GetRecords()
+		if err != nil {
+			log.Warnf("Unable to get records from Route53 hosted zone %s. Not updating Route53. %v", zone.id, err)
+			return err
+		}
+		records = ownedRecords(records, u.setIdentifier)
+		existing := len(records)
+		// hosts still waiting for their load balancer keep their records until it's known
+		records, waitingHealthCheckIDs := withoutRecordsOf(records, waiting, zone.domain)
+
+		zoneUpdate := controller.IngressUpdate{Entries: zoneEntries[zone]}
+		var healthCheckIDs map[string]string
+		if u.healthChecks {
+			if healthCheckIDs, err = u.ensureHealthChecks(zone, zoneUpdate); err != nil {
+				return err
+			}
+		}
+
+		// services alias their own load balancers, and don't get health checks
+		allEntries := append(append([]controller.IngressEntry{}, zoneEntries[zone]...), zoneServiceEntries[zone]...)
+		changes, err := calculateChanges(frontends, records, controller.IngressUpdate{Entries: allEntries},
+			zone.domain, u.setIdentifier, healthCheckIDs)
+		if err != nil {
+			return err
+		}
+
+		var missingSince map[recordKey]time.Time
+		var deferredHealthCheckIDs []string
+		if u.gracePeriod > 0 {
+			changes, missingSince, deferredHealthCheckIDs = u.deferDeletions(zone, changes)
+		}
+
+		changes, refusedErr := u.refuseMassDeletion(zone, existing, changes)
+		if refusedErr != nil {
+			log.Error(refusedErr)
+			deletionErr = refusedErr
+		}
+
+		var graceRecord *route53.ResourceRecordSet
+		if u.gracePeriod > 0 {
+			var graceChange *route53.Change
+			graceRecord, graceChange = u.updateGraceRecord(zone, missingSince)
+			if graceChange != nil {
+				changes = append(changes, graceChange)
+			}
+		}
+
+		if len(changes) > 0 {
+			changeIDs, err := zone.r53Sdk.UpdateRecordSets(changes)
+			u.addPendingChanges(zone, changeIDs)
+			if err != nil {
+				return fmt.Errorf("unable to update hosted zone %s: %v", zone.id, err)
+			}
+		}
+
+		if u.gracePeriod > 0 {
+			zone.missingSince = missingSince
+			zone.graceRecord = graceRecord
+		}
+
+		// refused deletions leave records that still use their health checks
+		if u.healthChecks && refusedErr == nil {
+			u.deleteOrphanedHealthChecks(zone, healthCheckIDs, append(deferredHealthCheckIDs, waitingHealthCheckIDs...))
+		}
+	}
+
+	return deletionErr
+}
+
+// frontendsFromStatus returns the front end ELB of each scheme from the ingress status, the entries that
+// have one, and the entries still waiting for one. Every ingress of a scheme is expected to have the same
+// front end, so the first one found is used.
+func (u *updater) frontendsFromStatus(update controller.IngressUpdate) (map[string]elb.LoadBalancerDetails,
+	controller.IngressUpdate, []controller.IngressEntry) {
+
+	frontends := make(map[string]elb.LoadBalancerDetails)
+	var entries, waiting []controller.IngressEntry
+	for _, entry := range update.Entries {
+		hostname := statusHostname(entry)
+		if hostname == "" {
+			log.Infof("Ingress entry %s has no load balancer hostname in its status yet, so its records are kept",
+				entry.Name)
+			waiting = append(waiting, entry)
+			continue
+		}
+
+		frontend, exists := frontends[entry.ELbScheme]
+		if !exists {
+			frontend = elb.LoadBalancerDetails{
+				DNSName:      hostname,
+				HostedZoneID: u.elbHostedZoneID,
+				Scheme:       entry.ELbScheme,
+			}
+			frontends[entry.ELbScheme] = frontend
+		} else if !strings.EqualFold(frontend.DNSName, hostname) {
+			log.Warnf("Ingress entry %s has load balancer %s in its status, but %s ingresses use %s",
+				entry.Name, hostname, entry.ELbScheme, frontend.DNSName)
+		}
+		entries = append(entries, entry)
+	}
+	return frontends, controller.IngressUpdate{Entries: entries, Services: update.Services}, waiting
+}
+
+// withoutRecordsOf returns the records that aren't for hosts of the entries, leaving those records alone, along
+// with the health checks the records left alone still use.
+func withoutRecordsOf(records []*route53.ResourceRecordSet, entries []controller.IngressEntry,
+	domain string) ([]*route53.ResourceRecordSet, []string) {
+
+	if len(entries) == 0 {
+		return records, nil
+	}
+	hosts := make(map[string]bool)
+	for _, entry := range dnsEntries(controller.IngressUpdate{Entries: entries}) {
+		if host, valid := hostInDomain(entry.Host, domain); valid {
+			hosts[strings.ToLower(host)] = true
+		}
+	}
+
+	var remaining []*route53.ResourceRecordSet
+	var healthCheckIDs []string
+	for _, recordSet := range records {
+		if !hosts[keyOf(recordSet).name] {
+			remaining = append(remaining, recordSet)
+		} else if id := aws.StringValue(recordSet.HealthCheckId); id != "" {
+			healthCheckIDs = append(healthCheckIDs, id)
+		}
+	}
+	return remaining, healthCheckIDs
+}
+
+// statusHostname returns the first hostname in the entry's ingress status, as ELBs can only be aliased by name.
+func statusHostname(entry controller.IngressEntry) string {
+	for _, address := range entry.LoadBalancerAddresses {
+		if net.ParseIP(address) == nil {
+			return address
+		}
+	}
+	return ""
+}
+
+// deferDeletions holds back the deletion of records until they've been unwanted for the whole grace period.
+// It returns the changes to make now, when each record still awaiting deletion was first unwanted, and the
+// health checks those records still use.
+func (u *updater) deferDeletions(zone *hostedZone,
+	changes []*route53.Change) ([]*route53.Change, map[recordKey]time.Time, []string) {
+
+	now := u.now()
+	missingSince := make(map[recordKey]time.Time)
+	var allowed []*route53.Change
+	var healthCheckIDs []string
+	for _, change := range changes {
+		if aws.StringValue(change.Action) != "DELETE" {
+			allowed = append(allowed, change)
+			continue
+		}
+
+		// records stay in missingSince until they're actually gone
+		key := keyOf(change.ResourceRecordSet)
+		since, ok := zone.missingSince[key]
+		if !ok {
+			since = now
+		}
+		missingSince[key] = since
+
+		if now.Sub(since) >= u.gracePeriod {
+			allowed = append(allowed, change)
+		} else {
+			log.Infof("Deferring deletion of %s record %s until %v", key.recordType, key.name,
+				since.Add(u.gracePeriod))
+			if id := aws.StringValue(change.ResourceRecordSet.HealthCheckId); id != "" {
+				healthCheckIDs = append(healthCheckIDs, id)
+			}
+		}
+	}
+	return allowed, missingSince, healthCheckIDs
+}
+
+// updateGraceRecord returns the TXT record persisting the records awaiting deletion, so the grace period
+// survives restarts, along with the change needed to update it. The change is nil if there's nothing to do.
+func (u *updater) updateGraceRecord(zone *hostedZone,
+	missingSince map[recordKey]time.Time) (*route53.ResourceRecordSet, *route53.Change) {
+
+	if len(missingSince) == 0 {
+		if zone.graceRecord == nil {
+			return nil, nil
+		}
+		return nil, &route53.Change{Action: aws.String("DELETE"), ResourceRecordSet: zone.graceRecord}
+	}
+
+	var values []string
+	for key, since := range missingSince {
+		values = append(values, txtValue(fmt.Sprintf("%s %s %d", key.name, key.recordType, since.Unix())))
+	}
+	sort.Strings(values)
+
+	recordSet := &route53.ResourceRecordSet{
+		Name: aws.String(graceRecordPrefix + zone.domain),
+		Type: aws.String(route53.RRTypeTxt),
+		TTL:  aws.Int64(graceRecordTTL),
 	}
+	for _, value := range values {
+		recordSet.ResourceRecords = append(recordSet.ResourceRecords, &route53.ResourceRecord{Value: aws.String(value)})
+	}
+	// each cluster has its own grace record, in a weighted set that is never queried
+	setRouting(recordSet, u.setIdentifier, controller.DNSRouting{Weight: 0})
+
+	if zone.graceRecord != nil && graceValues(zone.graceRecord) == strings.Join(values, ",") {
+		return zone.graceRecord, nil
+	}
+	return recordSet, &route53.Change{Action: aws.String("UPSERT"), ResourceRecordSet: recordSet}
+}
 
-	changes, err := calculateChanges(u.frontends, aRecords, update, u.domain)
+// loadGraceRecord reads the records awaiting deletion from the zone's TXT record.
+func (u *updater) loadGraceRecord(zone *hostedZone) error {
+	recordSet, err := zone.r53Sdk.GetRecord(graceRecordPrefix+zone.domain, route53.RRTypeTxt, u.setIdentifier)
 	if err != nil {
 		return err
 	}
 
-	u.r53Sdk.UpdateRecordSets(changes)
+	zone.graceRecord = recordSet
+	zone.missingSince = make(map[recordKey]time.Time)
+	if recordSet == nil {
+		return nil
+	}
 
+	for _, record := range recordSet.ResourceRecords {
+		fields := strings.Fields(txtText(aws.StringValue(record.Value)))
+		if len(fields) != 3 {
+			log.Warnf("Ignoring invalid pending deletion %s", aws.StringValue(record.Value))
+			continue
+		}
+		since, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			log.Warnf("Ignoring invalid pending deletion %s", aws.StringValue(record.Value))
+			continue
+		}
+		zone.missingSince[recordKey{name: fields[0], recordType: fields[1]}] = time.Unix(since, 0)
+	}
+	log.Infof("Found %d records awaiting deletion in hosted zone %s", len(zone.missingSince), zone.id)
 	return nil
 }
 
+// txtValue quotes the text as a TXT record value, split into as many character strings as needed to fit.
+func txtValue(text string) string {
+	var strs []string
+	for len(text) > maxTXTStringLength {
+		strs = append(strs, text[:maxTXTStringLength])
+		text = text[maxTXTStringLength:]
+	}
+	return "\"" + strings.Join(append(strs, text), "\" \"") + "\""
+}
+
+// txtText joins the character strings of a TXT record value back into the text.
+func txtText(value string) string {
+	return strings.Join(strings.Split(strings.Trim(value, "\""), "\" \""), "")
+}
+
+func graceValues(recordSet *route53.ResourceRecordSet) string {
+	var values []string
+	for _, record := range recordSet.ResourceRecords {
+		values = append(values, aws.StringValue(record.Value))
+	}
+	sort.Strings(values)
+	return strings.Join(values, ",")
+}
+
+// healthCheckPrefix is the caller reference prefix of health checks created for the hosted zone's records,
+// distinguishing them from those of other zones and clusters.
+func (u *updater) healthCheckPrefix(zone *hostedZone) string {
+	h := fnv.New32a()
+	h.Write([]byte(zone.id + "/" + u.setIdentifier))
+	return fmt.Sprintf("feed-%08x-", h.Sum32())
+}
+
+// ensureHealthChecks finds or creates a health check for each host, returning their IDs by host.
+// Route53's health checkers are on the internet, so hosts in private zones or on internal frontends aren't checked,
+// as they would never be reachable.
+func (u *updater) ensureHealthChecks(zone *hostedZone, update controller.IngressUpdate) (map[string]string, error) {
+	ids := make(map[string]string)
+	if zone.private {
+		return ids, nil
+	}
+	for _, entry := range dnsEntries(update) {
+		host, valid := hostInDomain(entry.Host, zone.domain)
+		if !valid || ids[host] != "" {
+			continue
+		}
+		// a wildcard isn't a host that can be checked
+		if strings.HasPrefix(host, "*.") || entry.ELbScheme == internalScheme {
+			continue
+		}
+
+		config := u.healthCheckConfig(entry)
+		for _, healthCheck := range zone.healthChecks {
+			if healthCheckMatches(healthCheck.HealthCheckConfig, config) {
+				ids[host] = aws.StringValue(healthCheck.Id)
+				break
+			}
+		}
+		if ids[host] != "" {
+			continue
+		}
+
+		callerReference := fmt.Sprintf("%s%d", u.healthCheckPrefix(zone), u.now().UnixNano())
+		id, err := zone.r53Sdk.CreateHealthCheck(callerReference, config)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create health check for %s: %v", entry.Host, err)
+		}
+		log.Infof("Created health check %s for %s", id, entry.Host)
+		zone.healthChecks = append(zone.healthChecks, &route53.HealthCheck{
+			Id:                aws.String(id),
+			CallerReference:   aws.String(callerReference),
+			HealthCheckConfig: config,
+		})
+		ids[host] = id
+	}
+	return ids, nil
+}
+
+// deleteOrphanedHealthChecks deletes the zone's health checks that are no longer used by any host, or by any
+// record awaiting deletion. Deletion is retried on the next update if it fails, such as when a record using it
+// is still being deleted.
+func (u *updater) deleteOrphanedHealthChecks(zone *hostedZone, inUse map[string]string, deferred []string) {
+	used := make(map[string]bool)
+	for _, id := range inUse {
+		used[id] = true
+	}
+	for _, id := range deferred {
+		used[id] = true
+	}
+
+	var remaining []*route53.HealthCheck
+	for _, healthCheck := range zone.healthChecks {
+		id := aws.StringValue(healthCheck.Id)
+		if !used[id] {
+			if err := zone.r53Sdk.DeleteHealthCheck(id); err != nil {
+				log.Warnf("Unable to delete orphaned health check, will retry: %v", err)
+			} else {
+				log.Infof("Deleted orphaned health check %s", id)
+				continue
+			}
+		}
+		remaining = append(remaining, healthCheck)
+	}
+	zone.healthChecks = remaining
+}
+
+// healthCheckConfig is an HTTP health check of the entry's host, requesting its health check path, or the
+// entry's own path if none is set.
+func (u *updater) healthCheckConfig(entry controller.IngressEntry) *route53.HealthCheckConfig {
+	path := entry.DNSHealthCheckPath
+	if path == "" {
+		path = entry.Path
+	}
+	if path == "" {
+		path = "/"
+	}
+	return &route53.HealthCheckConfig{
+		Type:                     aws.String(route53.HealthCheckTypeHttp),
+		FullyQualifiedDomainName: aws.String(strings.ToLower(entry.Host)),
+		Port:                     aws.Int64(int64(u.healthCheckPort)),
+		ResourcePath:             aws.String(path),
+		RequestInterval:          aws.Int64(healthCheckInterval),
+		FailureThreshold:         aws.Int64(healthCheckFailureThreshold),
+	}
+}
+
+func healthCheckMatches(existing, wanted *route53.HealthCheckConfig) bool {
+	return existing != nil &&
+		aws.StringValue(existing.Type) == aws.StringValue(wanted.Type) &&
+		normaliseDNSName(aws.StringValue(existing.FullyQualifiedDomainName)) ==
+			normaliseDNSName(aws.StringValue(wanted.FullyQualifiedDomainName)) &&
+		aws.Int64Value(existing.Port) == aws.Int64Value(wanted.Port) &&
+		aws.StringValue(existing.ResourcePath) == aws.StringValue(wanted.ResourcePath)
+}
+
+// refuseMassDeletion drops all deletions from the changes if they would remove more than the maximum
+// percentage of existing records. This protects against an empty or partial update, such as during an
+// apiserver outage, wiping out a hosted zone.
+func (u *updater) refuseMassDeletion(zone *hostedZone, existing int,
+	changes []*route53.Change) ([]*route53.Change, error) {
+
+	if u.maxDeletionPct <= 0 {
+		return changes, nil
+	}
+
+	var deletions int
+	var remaining []*route53.Change
+	for _, change := range changes {
+		if aws.StringValue(change.Action) == "DELETE" {
+			deletions++
+		} else {
+			remaining = append(remaining, change)
+		}
+	}
+
+	if !isMassDeletion(deletions, existing, u.maxDeletionPct) {
+		return changes, nil
+	}
+
+	return remaining, fmt.Errorf("refusing to delete %d of %d records in hosted zone %s, as it exceeds %d%%",
+		deletions, existing, zone.id, u.maxDeletionPct)
+}
+
+// MinMassDeletion is the fewest deletions that can be refused as a mass deletion. Below it, removing a host
+// from a zone with only a few records would always exceed the percentage, and so never happen.
+const MinMassDeletion = 5
+
+// isMassDeletion is true if deleting the records would remove more than the maximum percentage of the
+// existing records, and at least MinMassDeletion of them. A maximum of zero or less allows any deletion.
+func isMassDeletion(deletions, existing, maxPercent int) bool {
+	return maxPercent > 0 && deletions >= MinMassDeletion && deletions*100 > maxPercent*existing
+}
+
+// ownedRecords filters out records belonging to other clusters, which have a different set identifier.
+func ownedRecords(records []*route53.ResourceRecordSet, setIdentifier string) []*route53.ResourceRecordSet {
+	var owned []*route53.ResourceRecordSet
+	for _, recordSet := range records {
+		if aws.StringValue(recordSet.SetIdentifier) == setIdentifier {
+			owned = append(owned, recordSet)
+		}
+	}
+	return owned
+}
+
+// entriesByZone assigns each entry to the hosted zone with the longest domain suffix of its host.
+// Entries outside of every hosted zone are dropped.
+func (u *updater) entriesByZone(update controller.IngressUpdate) map[*hostedZone][]controller.IngressEntry {
+	zoneEntries := make(map[*hostedZone][]controller.IngressEntry)
+	for _, entry := range dnsEntries(update) {
+		zone := u.zoneForEntry(entry)
+		if zone == nil {
+			log.Warnf("Ingress entry %s host %s is not in any managed hosted zone", entry.Name, entry.Host)
+			continue
+		}
+		zoneEntries[zone] = append(zoneEntries[zone], entry)
+	}
+	return zoneEntries
+}
+
+// serviceEntriesByZone returns an entry for each host of the services, assigned to hosted zones in the same way
+// as ingress entries. Each service aliases its own load balancer, so the entries use the service name as their
+// scheme, which is the key of the returned load balancers.
+func (u *updater) serviceEntriesByZone(services []controller.ServiceEntry) (map[*hostedZone][]controller.IngressEntry,
+	map[string]elb.LoadBalancerDetails) {
+
+	zoneEntries := make(map[*hostedZone][]controller.IngressEntry)
+	frontends := make(map[string]elb.LoadBalancerDetails)
+	for _, service := range services {
+		hostname := ""
+		for _, address := range service.LoadBalancerAddresses {
+			if net.ParseIP(address) == nil {
+				hostname = address
+				break
+			}
+		}
+		if hostname == "" {
+			log.Warnf("Service %s has no load balancer hostname to alias", service.Name)
+			continue
+		}
+		hostedZoneID, ok := loadBalancerHostedZoneID(hostname)
+		if !ok {
+			log.Warnf("Service %s has load balancer %s, which isn't a known AWS load balancer to alias",
+				service.Name, hostname)
+			continue
+		}
+
+		key := serviceFrontendPrefix + service.Name
+		frontends[key] = elb.LoadBalancerDetails{DNSName: hostname, HostedZoneID: hostedZoneID, Scheme: service.ELbScheme}
+
+		for _, host := range service.Hosts {
+			entry := controller.IngressEntry{
+				Name:       service.Name,
+				Host:       host,
+				ELbScheme:  service.ELbScheme,
+				DNSRouting: service.DNSRouting,
+			}
+			zone := u.zoneForEntry(entry)
+			if zone == nil {
+				log.Warnf("Service %s host %s is not in any managed hosted zone", service.Name, host)
+				continue
+			}
+			entry.ELbScheme = key
+			zoneEntries[zone] = append(zoneEntries[zone], entry)
+		}
+	}
+	return zoneEntries, frontends
+}
+
+// loadBalancerHostedZoneID returns the canonical hosted zone ID of an AWS load balancer from its DNS name,
+// which is either <name>.<region>.elb.amazonaws.com for classic and application load balancers, or
+// <name>.elb.<region>.amazonaws.com for network load balancers.
+func loadBalancerHostedZoneID(hostname string) (string, bool) {
+	labels := strings.Split(strings.ToLower(strings.TrimSuffix(hostname, ".")), ".")
+	n := len(labels)
+	if n < 5 || labels[n-2] != "amazonaws" || labels[n-1] != "com" {
+		return "", false
+	}
+
+	var id string
+	switch {
+	case labels[n-3] == "elb":
+		id = elbHostedZoneIDs[labels[n-4]]
+	case labels[n-4] == "elb":
+		id = nlbHostedZoneIDs[labels[n-3]]
+	}
+	return id, id != ""
+}
+
+// zoneForEntry finds the most specific hosted zone for the entry's host. If a public and a private
+// zone share the same domain, internal entries go to the private zone and all others to the public zone.
+func (u *updater) zoneForEntry(entry controller.IngressEntry) *hostedZone {
+	host := entry.Host + "."
+	wantPrivate := entry.ELbScheme == internalScheme
+
+	var best *hostedZone
+	for _, zone := range u.zones {
+		if !strings.HasSuffix(host, "."+zone.domain) {
+			continue
+		}
+		if best == nil || len(zone.domain) > len(best.domain) ||
+			(len(zone.domain) == len(best.domain) && zone.private == wantPrivate) {
+			best = zone
+		}
+	}
+	return best
+}
+
 // a private function rather than a method on updater to allow isolated testing, however...
 // todo make a private method and test through the public interface
 func calculateChanges(frontEnds map[string]elb.LoadBalancerDetails,
-	aRecords []*route53.ResourceRecordSet,
+	records []*route53.ResourceRecordSet,
 	update controller.IngressUpdate,
-	domain string) ([]*route53.Change, error) {
+	domain string,
+	setIdentifier string,
+	healthCheckIDs map[string]string) ([]*route53.Change, error) {
 
-	log.Info("Current a records: ", aRecords)
+	log.Info("Current records: ", records)
 	log.Info("Processing ingress update: ", update)
 	changes := []*route53.Change{}
+	existingRecords := make(map[recordKey]*route53.ResourceRecordSet)
+	for _, recordSet := range records {
+		existingRecords[keyOf(recordSet)] = recordSet
+	}
 	hostToIngresEntry := make(map[string]controller.IngressEntry)
-	for _, ingressEntry := range update.Entries {
+	wantedRecords := make(map[recordKey]bool)
+	for _, ingressEntry := range dnsEntries(update) {
 		log.Infof("Processing entry %v", ingressEntry)
-		// Ingress entries in k8s aren't allowed to have the . on the end
-		// AWS adds it regardless of whether you specify it
-		hostNameWithPeriod := ingressEntry.Host + "."
-		// Want to match blah.james.com not blahjames.com for domain james.com
-		domainWithLeadingPeriod := "." + domain
+		hostNameWithPeriod, valid := hostInDomain(ingressEntry.Host, domain)
+		if !valid {
+			continue
+		}
 
-		log.Infof("Checking if ingress entry has valid host name (%s, %s)", hostNameWithPeriod, domainWithLeadingPeriod)
-		// First we check if this host is actually in the hosted zone's domain
-		if !strings.HasSuffix(hostNameWithPeriod, domainWithLeadingPeriod) {
-			log.Warnf("Ingress entry does not have a valid hostname for the hosted zone (%s, %s)", hostNameWithPeriod, domainWithLeadingPeriod)
-			break
+		// Multiple paths for the same host only need a single record
+		if _, processed := hostToIngresEntry[hostNameWithPeriod]; processed {
+			continue
 		}
 
 		hostToIngresEntry[hostNameWithPeriod] = ingressEntry
@@ -109,19 +977,46 @@ func calculateChanges(frontEnds map[string]elb.LoadBalancerDetails,
 		if !exists {
 			return nil, fmt.Errorf("unable to find front end load balancer with scheme: %v", ingressEntry.ELbScheme)
 		}
-		changes = append(changes,
-			newChange("UPSERT", ingressEntry.Host, frontEnd.DNSName, frontEnd.HostedZoneID))
+
+		for _, target := range aliasTargets(ingressEntry, frontEnd) {
+			key := recordKey{name: hostNameWithPeriod, recordType: target.recordType}
+			wantedRecords[key] = true
+
+			change := newChange("UPSERT", ingressEntry.Host, target.recordType, target.dnsName, frontEnd.HostedZoneID)
+			setRouting(change.ResourceRecordSet, setIdentifier, ingressEntry.DNSRouting)
+			if id, ok := healthCheckIDs[hostNameWithPeriod]; ok {
+				change.ResourceRecordSet.HealthCheckId = aws.String(id)
+			}
+
+			if recordMatches(existingRecords[key], change.ResourceRecordSet) {
+				log.Debugf("%s record for %s is already up to date", target.recordType, hostNameWithPeriod)
+				continue
+			}
+
+			changes = append(changes, change)
+		}
 	}
 
 	log.Info("Host to ingress entry: ", hostToIngresEntry)
 
-	for _, recordSet := range aRecords {
-		if _, contains := hostToIngresEntry[*recordSet.Name]; !contains {
-			changes = append(changes, newChange(
+	for _, recordSet := range records {
+		if recordSet.AliasTarget == nil {
+			log.Debugf("Ignoring %s as it isn't an alias record", aws.StringValue(recordSet.Name))
+			continue
+		}
+		if !wantedRecords[keyOf(recordSet)] {
+			change := newChange(
 				"DELETE",
 				*recordSet.Name,
+				*recordSet.Type,
 				*recordSet.AliasTarget.DNSName,
-				*recordSet.AliasTarget.HostedZoneId))
+				*recordSet.AliasTarget.HostedZoneId)
+			// deletes must match the existing record exactly
+			change.ResourceRecordSet.SetIdentifier = recordSet.SetIdentifier
+			change.ResourceRecordSet.Weight = recordSet.Weight
+			change.ResourceRecordSet.Failover = recordSet.Failover
+			change.ResourceRecordSet.HealthCheckId = recordSet.HealthCheckId
+			changes = append(changes, change)
 		}
 	}
 
@@ -129,12 +1024,133 @@ func calculateChanges(frontEnds map[string]elb.LoadBalancerDetails,
 	return changes, nil
 }
 
-func newChange(action string, host string, targetElbDNSName string, targetElbHostedZoneID string) *route53.Change {
+// dnsEntries returns the entries that should have DNS records, with a copy of the entry for each of its aliases.
+func dnsEntries(update controller.IngressUpdate) []controller.IngressEntry {
+	var entries []controller.IngressEntry
+	for _, entry := range update.Entries {
+		if entry.DNSIgnore {
+			log.Debugf("Ignoring entry %s as it has DNS disabled", entry.Name)
+			continue
+		}
+		aliases := entry.DNSAliases
+		entry.DNSAliases = nil
+		entries = append(entries, entry)
+		for _, alias := range aliases {
+			aliasEntry := entry
+			aliasEntry.Host = alias
+			entries = append(entries, aliasEntry)
+		}
+	}
+	return entries
+}
+
+// hostInDomain returns the fully qualified host name, and whether it's within the domain.
+func hostInDomain(host string, domain string) (string, bool) {
+	// Ingress entries in k8s aren't allowed to have the . on the end
+	// AWS adds it regardless of whether you specify it
+	hostNameWithPeriod := host + "."
+	// Want to match blah.james.com not blahjames.com for domain james.com
+	domainWithLeadingPeriod := "." + domain
+
+	log.Infof("Checking if ingress entry has valid host name (%s, %s)", hostNameWithPeriod, domainWithLeadingPeriod)
+	if !strings.HasSuffix(hostNameWithPeriod, domainWithLeadingPeriod) {
+		log.Warnf("Ingress entry does not have a valid hostname for the hosted zone (%s, %s)", hostNameWithPeriod, domainWithLeadingPeriod)
+		return "", false
+	}
+	return hostNameWithPeriod, true
+}
+
+type recordKey struct {
+	name       string
+	recordType string
+}
+
+func keyOf(recordSet *route53.ResourceRecordSet) recordKey {
+	name := strings.ToLower(unescapeDNSName(aws.StringValue(recordSet.Name)))
+	return recordKey{name: name, recordType: aws.StringValue(recordSet.Type)}
+}
+
+// unescapeDNSName replaces the \ooo octal escapes in names returned by Route53, such as \052 for the * of
+// wildcard records, so they can be compared with ingress hosts.
+func unescapeDNSName(name string) string {
+	if !strings.Contains(name, "\\") {
+		return name
+	}
+	var unescaped []byte
+	for i := 0; i < len(name); i++ {
+		if name[i] == '\\' && i+3 < len(name) {
+			if c, err := strconv.ParseUint(name[i+1:i+4], 8, 8); err == nil {
+				unescaped = append(unescaped, byte(c))
+				i += 3
+				continue
+			}
+		}
+		unescaped = append(unescaped, name[i])
+	}
+	return string(unescaped)
+}
+
+type aliasTarget struct {
+	recordType string
+	dnsName    string
+}
+
+// aliasTargets returns the records needed for the entry. Dualstack entries get an additional AAAA record,
+// pointing at the IPv6 enabled dualstack name of the ELB.
+func aliasTargets(entry controller.IngressEntry, frontEnd elb.LoadBalancerDetails) []aliasTarget {
+	targets := []aliasTarget{{recordType: route53.RRTypeA, dnsName: frontEnd.DNSName}}
+	if entry.DualStack {
+		dualStackName := frontEnd.DNSName
+		if !strings.HasPrefix(normaliseDNSName(dualStackName), dualStackPrefix) {
+			dualStackName = dualStackPrefix + dualStackName
+		}
+		targets = append(targets, aliasTarget{recordType: route53.RRTypeAaaa, dnsName: dualStackName})
+	}
+	return targets
+}
+
+// setRouting makes the record set part of a weighted or failover set, if there is a set identifier.
+func setRouting(recordSet *route53.ResourceRecordSet, setIdentifier string, routing controller.DNSRouting) {
+	if setIdentifier == "" {
+		return
+	}
+	recordSet.SetIdentifier = aws.String(setIdentifier)
+	if routing.Failover != "" {
+		recordSet.Failover = aws.String(routing.Failover)
+	} else {
+		recordSet.Weight = aws.Int64(routing.Weight)
+	}
+}
+
+// recordMatches returns true if the existing record set is already the wanted alias, so doesn't need updating.
+func recordMatches(existing, wanted *route53.ResourceRecordSet) bool {
+	if existing == nil || existing.AliasTarget == nil {
+		return false
+	}
+	alias := existing.AliasTarget
+	wantedAlias := wanted.AliasTarget
+	return normaliseDNSName(aws.StringValue(alias.DNSName)) == normaliseDNSName(aws.StringValue(wantedAlias.DNSName)) &&
+		aws.StringValue(alias.HostedZoneId) == aws.StringValue(wantedAlias.HostedZoneId) &&
+		aws.BoolValue(alias.EvaluateTargetHealth) == aws.BoolValue(wantedAlias.EvaluateTargetHealth) &&
+		aws.StringValue(existing.SetIdentifier) == aws.StringValue(wanted.SetIdentifier) &&
+		aws.StringValue(existing.Failover) == aws.StringValue(wanted.Failover) &&
+		aws.StringValue(existing.HealthCheckId) == aws.StringValue(wanted.HealthCheckId) &&
+		(existing.Weight == nil) == (wanted.Weight == nil) &&
+		aws.Int64Value(existing.Weight) == aws.Int64Value(wanted.Weight)
+}
+
+// normaliseDNSName strips the trailing period and lowercases, as Route53 does for alias targets.
+func normaliseDNSName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+func newChange(action string, host string, recordType string, targetElbDNSName string,
+	targetElbHostedZoneID string) *route53.Change {
 	return &route53.Change{
 		Action: aws.String(action),
 		ResourceRecordSet: &route53.ResourceRecordSet{
 			Name: aws.String(host),
-			Type: aws.String("A"),
+			Type: aws.String(recordType),
 			AliasTarget: &route53.AliasTarget{
 				DNSName:              aws.String(targetElbDNSName),
 				HostedZoneId:         aws.String(targetElbHostedZoneID),
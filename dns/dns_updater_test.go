@@ -6,10 +6,13 @@ import (
 	"errors"
 
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/route53"
 	"github.com/sky-uk/feed/controller"
+	"github.com/sky-uk/feed/dns/r53"
 	"github.com/sky-uk/feed/elb"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -40,12 +43,25 @@ func (m *fakeR53Client) GetHostedZoneDomain() (string, error) {
 	return args.String(0), args.Error(1)
 }
 
-func (m *fakeR53Client) UpdateRecordSets(changes []*route53.Change) error {
+func (m *fakeR53Client) IsPrivateHostedZone() (bool, error) {
+	args := m.Called()
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *fakeR53Client) UpdateRecordSets(changes []*route53.Change) ([]string, error) {
 	args := m.Called(changes)
-	return args.Error(0)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *fakeR53Client) IsChangeInSync(changeID string) (bool, error) {
+	args := m.Called(changeID)
+	return args.Bool(0), args.Error(1)
 }
 
-func (m *fakeR53Client) GetARecords() ([]*route53.ResourceRecordSet, error) {
+func (m *fakeR53Client) GetRecords() ([]*route53.ResourceRecordSet, error) {
 	args := m.Called()
 	if args.Error(1) != nil {
 		return nil, args.Error(1)
@@ -54,17 +70,59 @@ func (m *fakeR53Client) GetARecords() ([]*route53.ResourceRecordSet, error) {
 	return args.Get(0).([]*route53.ResourceRecordSet), args.Error(1)
 }
 
-func createDNSUpdater() (*updater, *fakeR53Client) {
-	dnsUpdater := New(r53Zone, awsRegion, elbName).(*updater)
+func (m *fakeR53Client) GetRecord(name, recordType, setIdentifier string) (*route53.ResourceRecordSet, error) {
+	args := m.Called(name, recordType, setIdentifier)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*route53.ResourceRecordSet), args.Error(1)
+}
+
+func (m *fakeR53Client) GetHealthChecks(callerReferencePrefix string) ([]*route53.HealthCheck, error) {
+	args := m.Called(callerReferencePrefix)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*route53.HealthCheck), args.Error(1)
+}
+
+func (m *fakeR53Client) CreateHealthCheck(callerReference string, config *route53.HealthCheckConfig) (string, error) {
+	args := m.Called(callerReference, config)
+	return args.String(0), args.Error(1)
+}
+
+func (m *fakeR53Client) DeleteHealthCheck(id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func newDNSUpdater(zones map[string]*fakeR53Client) *updater {
+	var zoneIDs []string
+	for id := range zones {
+		zoneIDs = append(zoneIDs, id)
+	}
+	dnsUpdater := New(Config{HostedZones: zoneIDs, ElbRegion: awsRegion, ElbLabelValue: elbName}).(*updater)
 	dnsUpdater.findElbs = func(elb.ELB, string) (map[string]elb.LoadBalancerDetails, error) {
 		return defaultFrontends, nil
 	}
-	fakeR53Client := new(fakeR53Client)
-	dnsUpdater.r53Sdk = fakeR53Client
-	fakeR53Client.On("GetHostedZoneDomain").Return(domain, nil)
-	fakeR53Client.On("GetARecords").Return([]*route53.ResourceRecordSet{}, nil)
-	//fakeR53Client.On("UpdateRecordSets", mock.Anything).Return(nil)
-	return dnsUpdater, fakeR53Client
+	dnsUpdater.newR53Client = func(region, hostedZone string) r53.Route53Client {
+		return zones[hostedZone]
+	}
+	return dnsUpdater
+}
+
+func newFakeR53Client(zoneDomain string, private bool) *fakeR53Client {
+	fakeR53 := new(fakeR53Client)
+	fakeR53.On("GetHostedZoneDomain").Return(zoneDomain, nil)
+	fakeR53.On("IsPrivateHostedZone").Return(private, nil)
+	fakeR53.On("GetRecords").Return([]*route53.ResourceRecordSet{}, nil)
+	return fakeR53
+}
+
+func createDNSUpdater() (*updater, *fakeR53Client) {
+	fakeR53 := newFakeR53Client(domain, false)
+	dnsUpdater := newDNSUpdater(map[string]*fakeR53Client{r53Zone: fakeR53})
+	return dnsUpdater, fakeR53
 }
 
 func TestQueryFrontendsOnStartup(t *testing.T) {
@@ -87,6 +145,197 @@ func TestQueryFrontendsFails(t *testing.T) {
 	assert.EqualError(t, err, "unable to find front end load balancers: No elbs for you")
 }
 
+func TestFrontendsFromIngressStatusSkipsElbLookup(t *testing.T) {
+	dnsUpdater, _ := createDNSUpdater()
+	dnsUpdater.frontendsInStatus = true
+	dnsUpdater.elbHostedZoneID = "elb-hosted-zone-id"
+	dnsUpdater.findElbs = func(elb.ELB, string) (map[string]elb.LoadBalancerDetails, error) {
+		return nil, errors.New("not allowed to describe elbs")
+	}
+
+	assert.NoError(t, dnsUpdater.Start())
+}
+
+func TestFrontendsFromIngressStatusNeedsElbHostedZoneID(t *testing.T) {
+	dnsUpdater, _ := createDNSUpdater()
+	dnsUpdater.frontendsInStatus = true
+
+	assert.EqualError(t, dnsUpdater.Start(), "unknown ELB hosted zone ID for region awsRegion")
+}
+
+func TestElbHostedZoneIDDefaultsToRegion(t *testing.T) {
+	assert.Equal(t, "Z32O12XQLNTSW2", elbHostedZoneID(Config{ElbRegion: "eu-west-1"}))
+	assert.Equal(t, "override", elbHostedZoneID(Config{ElbRegion: "eu-west-1", ElbHostedZoneID: "override"}))
+}
+
+func TestRecordsPointAtLoadBalancerInIngressStatus(t *testing.T) {
+	// given
+	dnsUpdater, fakeR53 := createDNSUpdater()
+	dnsUpdater.frontendsInStatus = true
+	dnsUpdater.elbHostedZoneID = "elb-hosted-zone-id"
+	assert.NoError(t, dnsUpdater.Start())
+
+	update := controller.IngressUpdate{Entries: []controller.IngressEntry{
+		{Name: "with-status", Host: "foo.james.com", ELbScheme: "internal",
+			LoadBalancerAddresses: []string{"10.0.0.1", "internal-elb.eu-west-1.elb.amazonaws.com"}},
+		{Name: "other-with-status", Host: "bar.james.com", ELbScheme: "internal",
+			LoadBalancerAddresses: []string{"different-elb.eu-west-1.elb.amazonaws.com"}},
+		{Name: "without-status", Host: "baz.james.com", ELbScheme: "internal"},
+	}}
+	fakeR53.On("UpdateRecordSets", []*route53.Change{
+		newChange("UPSERT", "foo.james.com", "A", "internal-elb.eu-west-1.elb.amazonaws.com", "elb-hosted-zone-id"),
+		newChange("UPSERT", "bar.james.com", "A", "internal-elb.eu-west-1.elb.amazonaws.com", "elb-hosted-zone-id"),
+	}).Return([]string{}, nil)
+
+	// when
+	err := dnsUpdater.Update(update)
+
+	// then
+	assert.NoError(t, err)
+	fakeR53.AssertExpectations(t)
+}
+
+func TestRecordsAreKeptForEntriesWaitingForIngressStatus(t *testing.T) {
+	// given
+	fakeR53 := new(fakeR53Client)
+	fakeR53.On("GetHostedZoneDomain").Return(domain, nil)
+	fakeR53.On("IsPrivateHostedZone").Return(false, nil)
+	fakeR53.On("GetRecords").Return([]*route53.ResourceRecordSet{
+		newChange("UPSERT", "baz.james.com.", "A", "internal-elb.eu-west-1.elb.amazonaws.com",
+			"elb-hosted-zone-id").ResourceRecordSet,
+	}, nil)
+	dnsUpdater := newDNSUpdater(map[string]*fakeR53Client{r53Zone: fakeR53})
+	dnsUpdater.frontendsInStatus = true
+	dnsUpdater.elbHostedZoneID = "elb-hosted-zone-id"
+	assert.NoError(t, dnsUpdater.Start())
+
+	update := controller.IngressUpdate{Entries: []controller.IngressEntry{
+		{Name: "without-status", Host: "baz.james.com", ELbScheme: "internal"},
+	}}
+
+	// when
+	err := dnsUpdater.Update(update)
+
+	// then
+	assert.NoError(t, err)
+	fakeR53.AssertNotCalled(t, "UpdateRecordSets", mock.Anything)
+}
+
+func TestLoadBalancerServicesAliasTheirOwnLoadBalancer(t *testing.T) {
+	// given
+	dnsUpdater, fakeR53 := createDNSUpdater()
+	assert.NoError(t, dnsUpdater.Start())
+
+	update := controller.IngressUpdate{
+		Entries: []controller.IngressEntry{{Name: "ingress", Host: "foo.james.com", ELbScheme: "internal"}},
+		Services: []controller.ServiceEntry{
+			{Name: "ns/nlb", Hosts: []string{"tcp.james.com", "outside.com"}, ELbScheme: "internet-facing",
+				LoadBalancerAddresses: []string{"nlb-123.elb.eu-west-1.amazonaws.com"}},
+			{Name: "ns/elb", Hosts: []string{"elb.james.com"}, ELbScheme: "internal",
+				LoadBalancerAddresses: []string{"internal-elb-123.us-east-1.elb.amazonaws.com"}},
+			{Name: "ns/ip-only", Hosts: []string{"ip.james.com"}, LoadBalancerAddresses: []string{"10.0.0.1"}},
+			{Name: "ns/unknown", Hosts: []string{"unknown.james.com"}, LoadBalancerAddresses: []string{"lb.example.com"}},
+		},
+	}
+	fakeR53.On("UpdateRecordSets", []*route53.Change{
+		newChange("UPSERT", "foo.james.com", "A", elbDNSName, r53Zone),
+		newChange("UPSERT", "tcp.james.com", "A", "nlb-123.elb.eu-west-1.amazonaws.com", "Z2IFOLAFXWLO4F"),
+		newChange("UPSERT", "elb.james.com", "A", "internal-elb-123.us-east-1.elb.amazonaws.com", "Z35SXDOTRQ7X7K"),
+	}).Return([]string{}, nil)
+
+	// when
+	err := dnsUpdater.Update(update)
+
+	// then
+	assert.NoError(t, err)
+	fakeR53.AssertExpectations(t)
+}
+
+func TestLoadBalancerHostedZoneID(t *testing.T) {
+	var tests = []struct {
+		hostname string
+		id       string
+		ok       bool
+	}{
+		{"my-elb-123.eu-west-1.elb.amazonaws.com", "Z32O12XQLNTSW2", true},
+		{"internal-my-elb-123.eu-west-1.elb.amazonaws.com.", "Z32O12XQLNTSW2", true},
+		{"my-nlb-123.elb.us-west-2.amazonaws.com", "Z18D5FSROUN65G", true},
+		{"MY-NLB-123.ELB.US-WEST-2.AMAZONAWS.COM", "Z18D5FSROUN65G", true},
+		{"my-elb-123.mars-1.elb.amazonaws.com", "", false},
+		{"elb.amazonaws.com", "", false},
+		{"lb.example.com", "", false},
+	}
+
+	for _, test := range tests {
+		id, ok := loadBalancerHostedZoneID(test.hostname)
+		assert.Equal(t, test.id, id, test.hostname)
+		assert.Equal(t, test.ok, ok, test.hostname)
+	}
+}
+
+func TestWildcardRecordsMatchRoute53EscapedNames(t *testing.T) {
+	// given
+	records := []*route53.ResourceRecordSet{
+		newChange("UPSERT", "\\052.apps.james.com.", "A", "elb-dnsname", "elb-hosted-zone-id").ResourceRecordSet,
+		newChange("UPSERT", "\\052.old.james.com.", "A", "elb-dnsname", "elb-hosted-zone-id").ResourceRecordSet,
+	}
+	frontEnds := map[string]elb.LoadBalancerDetails{
+		"internal": {DNSName: "elb-dnsname", HostedZoneID: "elb-hosted-zone-id"},
+	}
+	update := controller.IngressUpdate{Entries: []controller.IngressEntry{
+		{Name: "wildcard", Host: "*.apps.james.com", ELbScheme: "internal"},
+	}}
+
+	// when
+	changes, err := calculateChanges(frontEnds, records, update, domain, "", nil)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, []*route53.Change{
+		newChange("DELETE", "\\052.old.james.com.", "A", "elb-dnsname", "elb-hosted-zone-id"),
+	}, changes)
+}
+
+func TestUnescapeDNSName(t *testing.T) {
+	assert.Equal(t, "*.james.com.", unescapeDNSName("\\052.james.com."))
+	assert.Equal(t, "a b.james.com.", unescapeDNSName("a\\040b.james.com."))
+	assert.Equal(t, "plain.james.com.", unescapeDNSName("plain.james.com."))
+	assert.Equal(t, "bad\\9.james.com.", unescapeDNSName("bad\\9.james.com."))
+	assert.Equal(t, "end\\05", unescapeDNSName("end\\05"))
+}
+
+func TestNoHealthChecksForWildcardHosts(t *testing.T) {
+	dnsUpdater, fakeR53 := createDNSUpdater()
+	dnsUpdater.healthChecks = true
+	zone := &hostedZone{id: r53Zone, domain: domain, r53Sdk: fakeR53}
+
+	ids, err := dnsUpdater.ensureHealthChecks(zone, controller.IngressUpdate{Entries: []controller.IngressEntry{
+		{Name: "wildcard", Host: "*.apps.james.com", ELbScheme: "internet-facing"},
+	}})
+
+	assert.NoError(t, err)
+	assert.Empty(t, ids)
+	fakeR53.AssertNotCalled(t, "CreateHealthCheck", mock.Anything, mock.Anything)
+}
+
+func TestNoHealthChecksForInternalHostsOrPrivateZones(t *testing.T) {
+	dnsUpdater, fakeR53 := createDNSUpdater()
+	dnsUpdater.healthChecks = true
+	publicZone := &hostedZone{id: r53Zone, domain: domain, r53Sdk: fakeR53}
+	privateZone := &hostedZone{id: r53Zone, domain: domain, r53Sdk: fakeR53, private: true}
+
+	internalIDs, err := dnsUpdater.ensureHealthChecks(publicZone, controller.IngressUpdate{
+		Entries: []controller.IngressEntry{{Name: "internal", Host: "foo.james.com", ELbScheme: "internal"}}})
+	assert.NoError(t, err)
+	privateIDs, err := dnsUpdater.ensureHealthChecks(privateZone, controller.IngressUpdate{
+		Entries: []controller.IngressEntry{{Name: "external", Host: "foo.james.com", ELbScheme: "internet-facing"}}})
+	assert.NoError(t, err)
+
+	assert.Empty(t, internalIDs)
+	assert.Empty(t, privateIDs)
+	fakeR53.AssertNotCalled(t, "CreateHealthCheck", mock.Anything, mock.Anything)
+}
+
 func TestGetsDomainName(t *testing.T) {
 	dnsUpdater, fakeR53Client := createDNSUpdater()
 
@@ -95,17 +344,16 @@ func TestGetsDomainName(t *testing.T) {
 	err := dnsUpdater.Start()
 
 	assert.NoError(t, err)
-	assert.Equal(t, domain, dnsUpdater.domain)
+	assert.Equal(t, domain, dnsUpdater.zones[0].domain)
 }
 
 func TestGetsDomainNameFails(t *testing.T) {
-	fakeR53Client := new(fakeR53Client)
-	dnsUpdater := New(domain, awsRegion, elbName).(*updater)
+	fakeR53 := new(fakeR53Client)
+	dnsUpdater := newDNSUpdater(map[string]*fakeR53Client{r53Zone: fakeR53})
 	dnsUpdater.findElbs = func(elb.ELB, string) (map[string]elb.LoadBalancerDetails, error) {
 		return nil, nil
 	}
-	dnsUpdater.r53Sdk = fakeR53Client
-	fakeR53Client.On("GetHostedZoneDomain").Return("", errors.New("No domain for you"))
+	fakeR53.On("GetHostedZoneDomain").Return("", errors.New("No domain for you"))
 
 	err := dnsUpdater.Start()
 
@@ -140,7 +388,7 @@ func TestRemovesHostsWithInvalidHost(t *testing.T) {
 			},
 		},
 	}
-	fakeR53.On("UpdateRecordSets", expectedRecordSetsInput).Return(nil)
+	fakeR53.On("UpdateRecordSets", expectedRecordSetsInput).Return(nil, nil)
 
 	// when
 	dnsUpdater.Start()
@@ -151,6 +399,228 @@ func TestRemovesHostsWithInvalidHost(t *testing.T) {
 	fakeR53.AssertCalled(t, "UpdateRecordSets", expectedRecordSetsInput)
 }
 
+func TestDiscoversHostedZonesByDomain(t *testing.T) {
+	// given
+	dnsUpdater := newDNSUpdater(map[string]*fakeR53Client{
+		"public-james":  newFakeR53Client(domain, false),
+		"private-james": newFakeR53Client(domain, true),
+	})
+	dnsUpdater.hostedZoneIDs = nil
+	dnsUpdater.hostedZoneDomains = []string{"james.com"}
+	dnsUpdater.findHostedZoneIDs = func(region string, domains []string) ([]string, error) {
+		assert.Equal(t, []string{"james.com"}, domains)
+		return []string{"public-james", "private-james"}, nil
+	}
+
+	// when
+	err := dnsUpdater.Start()
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, []*hostedZone{
+		{id: "public-james", domain: domain, r53Sdk: dnsUpdater.zones[0].r53Sdk},
+		{id: "private-james", domain: domain, private: true, r53Sdk: dnsUpdater.zones[1].r53Sdk},
+	}, dnsUpdater.zones)
+}
+
+func TestHostedZoneDiscoveryFails(t *testing.T) {
+	dnsUpdater, _ := createDNSUpdater()
+	dnsUpdater.hostedZoneDomains = []string{"james.com"}
+	dnsUpdater.findHostedZoneIDs = func(string, []string) ([]string, error) {
+		return nil, errors.New("No zones for you")
+	}
+
+	err := dnsUpdater.Start()
+
+	assert.EqualError(t, err, "unable to find hosted zones: No zones for you")
+}
+
+func TestEntriesAreAssignedToMostSpecificHostedZone(t *testing.T) {
+	// given
+	frontends := map[string]elb.LoadBalancerDetails{
+		"internal":        {DNSName: "internal-elb", HostedZoneID: "internal-elb-zone", Scheme: "internal"},
+		"internet-facing": {DNSName: "public-elb", HostedZoneID: "public-elb-zone", Scheme: "internet-facing"},
+	}
+	publicZone := newFakeR53Client("james.com.", false)
+	privateZone := newFakeR53Client("james.com.", true)
+	subZone := newFakeR53Client("sub.james.com.", false)
+	otherZone := newFakeR53Client("chris.com.", false)
+	dnsUpdater := newDNSUpdater(map[string]*fakeR53Client{
+		"public": publicZone, "private": privateZone, "sub": subZone, "other": otherZone,
+	})
+	dnsUpdater.findElbs = func(elb.ELB, string) (map[string]elb.LoadBalancerDetails, error) {
+		return frontends, nil
+	}
+
+	update := controller.IngressUpdate{
+		Entries: []controller.IngressEntry{
+			{Name: "internal", Host: "foo.james.com", ELbScheme: "internal"},
+			{Name: "public", Host: "bar.james.com", ELbScheme: "internet-facing"},
+			{Name: "sub", Host: "foo.sub.james.com", ELbScheme: "internal"},
+			{Name: "none", Host: "foo.lalala.com", ELbScheme: "internal"},
+		},
+	}
+
+	expectedChange := func(host, elbDNSName, elbZone string) []*route53.Change {
+		return []*route53.Change{newChange("UPSERT", host, "A", elbDNSName, elbZone)}
+	}
+	privateZone.On("UpdateRecordSets", expectedChange("foo.james.com", "internal-elb", "internal-elb-zone")).Return(nil, nil)
+	publicZone.On("UpdateRecordSets", expectedChange("bar.james.com", "public-elb", "public-elb-zone")).Return(nil, nil)
+	subZone.On("UpdateRecordSets", expectedChange("foo.sub.james.com", "internal-elb", "internal-elb-zone")).Return(nil, nil)
+
+	// when
+	assert.NoError(t, dnsUpdater.Start())
+	err := dnsUpdater.Update(update)
+
+	// then
+	assert.NoError(t, err)
+	privateZone.AssertExpectations(t)
+	publicZone.AssertExpectations(t)
+	subZone.AssertExpectations(t)
+	otherZone.AssertNotCalled(t, "UpdateRecordSets", mock.Anything)
+}
+
+func TestUnhealthyUntilChangesAreInSync(t *testing.T) {
+	// given
+	assert := assert.New(t)
+	dnsUpdater, fakeR53 := createDNSUpdater()
+	dnsUpdater.waitForSync = true
+	update := controller.IngressUpdate{
+		Entries: []controller.IngressEntry{{Host: "foo.james.com", ELbScheme: "internal"}},
+	}
+	fakeR53.On("UpdateRecordSets", mock.Anything).Return([]string{"change-1", "change-2"}, nil)
+	fakeR53.On("IsChangeInSync", "change-1").Return(true, nil)
+	fakeR53.On("IsChangeInSync", "change-2").Return(false, nil).Once()
+	fakeR53.On("IsChangeInSync", "change-2").Return(true, nil)
+
+	// when
+	assert.NoError(dnsUpdater.Start())
+	assert.NoError(dnsUpdater.Health(), "should be healthy with no changes")
+	assert.NoError(dnsUpdater.Update(update))
+
+	// then
+	assert.EqualError(dnsUpdater.Health(), "waiting for 2 Route53 changes to be in sync")
+	dnsUpdater.checkPendingChanges()
+	assert.EqualError(dnsUpdater.Health(), "waiting for 1 Route53 changes to be in sync")
+	dnsUpdater.checkPendingChanges()
+	assert.NoError(dnsUpdater.Health())
+	assert.NoError(dnsUpdater.Stop())
+	assert.NoError(dnsUpdater.Stop(), "stopping twice is harmless")
+}
+
+func TestHealthyWithPendingChangesIfNotWaitingForSync(t *testing.T) {
+	dnsUpdater, fakeR53 := createDNSUpdater()
+	update := controller.IngressUpdate{
+		Entries: []controller.IngressEntry{{Host: "foo.james.com", ELbScheme: "internal"}},
+	}
+	fakeR53.On("UpdateRecordSets", mock.Anything).Return([]string{"change-1"}, nil)
+
+	assert.NoError(t, dnsUpdater.Start())
+	assert.NoError(t, dnsUpdater.Update(update))
+
+	assert.NoError(t, dnsUpdater.Health())
+	assert.Len(t, dnsUpdater.pending, 1)
+}
+
+func TestPendingChangesUseTheUpdaterClock(t *testing.T) {
+	dnsUpdater, fakeR53 := createDNSUpdater()
+	submitted := time.Unix(1000, 0)
+	dnsUpdater.now = func() time.Time { return submitted }
+	update := controller.IngressUpdate{
+		Entries: []controller.IngressEntry{{Host: "foo.james.com", ELbScheme: "internal"}},
+	}
+	fakeR53.On("UpdateRecordSets", mock.Anything).Return([]string{"change-1"}, nil)
+
+	assert.NoError(t, dnsUpdater.Start())
+	assert.NoError(t, dnsUpdater.Update(update))
+
+	assert.Len(t, dnsUpdater.pending, 1)
+	assert.Equal(t, submitted, dnsUpdater.pending[0].submitted)
+}
+
+func TestRefusesMassDeletion(t *testing.T) {
+	// given
+	aliasRecord := func(host string) *route53.ResourceRecordSet {
+		return &route53.ResourceRecordSet{
+			Name: aws.String(host),
+			Type: aws.String("A"),
+			AliasTarget: &route53.AliasTarget{
+				DNSName:              aws.String(elbDNSName),
+				HostedZoneId:         aws.String(r53Zone),
+				EvaluateTargetHealth: aws.Bool(true),
+			},
+		}
+	}
+	fakeR53 := new(fakeR53Client)
+	fakeR53.On("GetHostedZoneDomain").Return(domain, nil)
+	fakeR53.On("IsPrivateHostedZone").Return(false, nil)
+	fakeR53.On("GetRecords").Return([]*route53.ResourceRecordSet{
+		aliasRecord("foo.james.com."), aliasRecord("bar.james.com."), aliasRecord("baz.james.com."),
+		aliasRecord("qux.james.com."), aliasRecord("quux.james.com."),
+	}, nil)
+	dnsUpdater := newDNSUpdater(map[string]*fakeR53Client{r53Zone: fakeR53})
+	dnsUpdater.maxDeletionPct = 50
+
+	update := controller.IngressUpdate{
+		Entries: []controller.IngressEntry{{Host: "new.james.com", ELbScheme: "internal"}},
+	}
+	upsertOnly := []*route53.Change{newChange("UPSERT", "new.james.com", "A", elbDNSName, r53Zone)}
+	fakeR53.On("UpdateRecordSets", upsertOnly).Return(nil, nil)
+
+	// when
+	assert.NoError(t, dnsUpdater.Start())
+	err := dnsUpdater.Update(update)
+
+	// then
+	assert.EqualError(t, err, "refusing to delete 5 of 5 records in hosted zone 1234, as it exceeds 50%")
+	fakeR53.AssertExpectations(t)
+}
+
+func TestDeletesTheOnlyRecordOfASmallZone(t *testing.T) {
+	// given
+	fakeR53 := new(fakeR53Client)
+	fakeR53.On("GetHostedZoneDomain").Return(domain, nil)
+	fakeR53.On("IsPrivateHostedZone").Return(false, nil)
+	fakeR53.On("GetRecords").Return([]*route53.ResourceRecordSet{{
+		Name: aws.String("foo.james.com."),
+		Type: aws.String("A"),
+		AliasTarget: &route53.AliasTarget{
+			DNSName:              aws.String(elbDNSName),
+			HostedZoneId:         aws.String(r53Zone),
+			EvaluateTargetHealth: aws.Bool(true),
+		},
+	}}, nil)
+	dnsUpdater := newDNSUpdater(map[string]*fakeR53Client{r53Zone: fakeR53})
+	dnsUpdater.maxDeletionPct = 50
+	fakeR53.On("UpdateRecordSets", []*route53.Change{
+		newChange("DELETE", "foo.james.com.", "A", elbDNSName, r53Zone),
+	}).Return(nil, nil)
+
+	// when
+	assert.NoError(t, dnsUpdater.Start())
+	err := dnsUpdater.Update(controller.IngressUpdate{})
+
+	// then
+	assert.NoError(t, err, "deleting fewer than MinMassDeletion records is always allowed")
+	fakeR53.AssertExpectations(t)
+}
+
+func TestAllowsDeletionWithinThreshold(t *testing.T) {
+	// given
+	dnsUpdater := &updater{maxDeletionPct: 50}
+	changes := []*route53.Change{
+		newChange("UPSERT", "foo.james.com", "A", elbDNSName, r53Zone),
+		newChange("DELETE", "bar.james.com", "A", elbDNSName, r53Zone),
+	}
+
+	// when
+	allowed, err := dnsUpdater.refuseMassDeletion(&hostedZone{id: r53Zone}, 2, changes)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, changes, allowed)
+}
+
 // calculateChanges tests with no external dependencies
 func TestEmptyIngressUpdateResultsInNoChange(t *testing.T) {
 	// given
@@ -169,7 +639,7 @@ func TestEmptyIngressUpdateResultsInNoChange(t *testing.T) {
 	}
 
 	// when
-	actualChanges, _ := calculateChanges(frontEnds, aRecords, update, domain)
+	actualChanges, _ := calculateChanges(frontEnds, aRecords, update, domain, "", nil)
 
 	// then
 	expectedRecordSetsInput := []*route53.Change{}
@@ -202,7 +672,7 @@ func TestUpdateAddsMissingRecordSet(t *testing.T) {
 	}
 
 	// when
-	actualChanges, _ := calculateChanges(frontEnds, aRecords, update, domain)
+	actualChanges, _ := calculateChanges(frontEnds, aRecords, update, domain, "", nil)
 
 	// then
 	expectedRecordSetsInput := []*route53.Change{
@@ -243,6 +713,7 @@ func TestUpdatingExistingRecordSet(t *testing.T) {
 	aRecords := []*route53.ResourceRecordSet{
 		{
 			Name: aws.String("foo.james.com."),
+			Type: aws.String("A"),
 			AliasTarget: &route53.AliasTarget{
 				DNSName:              aws.String("elb-dnsname"),
 				HostedZoneId:         aws.String("elb-hosted-zone-id"),
@@ -264,7 +735,7 @@ func TestUpdatingExistingRecordSet(t *testing.T) {
 	}
 
 	// when
-	actualChanges, _ := calculateChanges(frontEnds, aRecords, update, domain)
+	actualChanges, _ := calculateChanges(frontEnds, aRecords, update, domain, "", nil)
 
 	// then
 	expectedRecordSetsInput := []*route53.Change{
@@ -285,6 +756,237 @@ func TestUpdatingExistingRecordSet(t *testing.T) {
 	assert.Equal(t, expectedRecordSetsInput, actualChanges)
 }
 
+func TestUnchangedRecordSetIsNotUpdated(t *testing.T) {
+	// given
+	frontEnds := map[string]elb.LoadBalancerDetails{
+		"internal": elb.LoadBalancerDetails{
+			Name:         "elb-name",
+			DNSName:      "ELB-dnsname",
+			HostedZoneID: "elb-hosted-zone-id",
+			Scheme:       "internal",
+		},
+	}
+
+	aRecords := []*route53.ResourceRecordSet{
+		{
+			Name: aws.String("foo.james.com."),
+			Type: aws.String("A"),
+			AliasTarget: &route53.AliasTarget{
+				DNSName:              aws.String("elb-dnsname."),
+				HostedZoneId:         aws.String("elb-hosted-zone-id"),
+				EvaluateTargetHealth: aws.Bool(true),
+			},
+		},
+	}
+
+	update := controller.IngressUpdate{
+		Entries: []controller.IngressEntry{
+			controller.IngressEntry{
+				Name:      "test-entry",
+				Host:      "foo.james.com",
+				Path:      "/",
+				ELbScheme: "internal",
+			},
+			controller.IngressEntry{
+				Name:      "test-entry",
+				Host:      "foo.james.com",
+				Path:      "/another-path",
+				ELbScheme: "internal",
+			},
+		},
+	}
+
+	// when
+	actualChanges, err := calculateChanges(frontEnds, aRecords, update, domain, "", nil)
+
+	// then
+	assert.NoError(t, err)
+	assert.Empty(t, actualChanges)
+}
+
+func TestRecordSetWithoutTargetHealthIsUpdated(t *testing.T) {
+	// given
+	frontEnds := map[string]elb.LoadBalancerDetails{
+		"internal": elb.LoadBalancerDetails{
+			Name:         "elb-name",
+			DNSName:      "elb-dnsname",
+			HostedZoneID: "elb-hosted-zone-id",
+			Scheme:       "internal",
+		},
+	}
+
+	aRecords := []*route53.ResourceRecordSet{
+		{
+			Name: aws.String("foo.james.com."),
+			Type: aws.String("A"),
+			AliasTarget: &route53.AliasTarget{
+				DNSName:              aws.String("elb-dnsname."),
+				HostedZoneId:         aws.String("elb-hosted-zone-id"),
+				EvaluateTargetHealth: aws.Bool(false),
+			},
+		},
+		{
+			Name: aws.String("not-an-alias.james.com."),
+		},
+	}
+
+	update := controller.IngressUpdate{
+		Entries: []controller.IngressEntry{
+			controller.IngressEntry{
+				Name:      "test-entry",
+				Host:      "foo.james.com",
+				Path:      "/",
+				ELbScheme: "internal",
+			},
+		},
+	}
+
+	// when
+	actualChanges, err := calculateChanges(frontEnds, aRecords, update, domain, "", nil)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, []*route53.Change{
+		newChange("UPSERT", "foo.james.com", "A", "elb-dnsname", "elb-hosted-zone-id"),
+	}, actualChanges)
+}
+
+func TestDualStackEntriesGetAAAARecords(t *testing.T) {
+	// given
+	frontEnds := map[string]elb.LoadBalancerDetails{
+		"internal": elb.LoadBalancerDetails{
+			Name:         "elb-name",
+			DNSName:      "elb-dnsname",
+			HostedZoneID: "elb-hosted-zone-id",
+			Scheme:       "internal",
+		},
+	}
+
+	records := []*route53.ResourceRecordSet{
+		{
+			Name: aws.String("foo.james.com."),
+			Type: aws.String("A"),
+			AliasTarget: &route53.AliasTarget{
+				DNSName:              aws.String("elb-dnsname."),
+				HostedZoneId:         aws.String("elb-hosted-zone-id"),
+				EvaluateTargetHealth: aws.Bool(true),
+			},
+		},
+		{
+			Name: aws.String("bar.james.com."),
+			Type: aws.String("AAAA"),
+			AliasTarget: &route53.AliasTarget{
+				DNSName:              aws.String("dualstack.elb-dnsname."),
+				HostedZoneId:         aws.String("elb-hosted-zone-id"),
+				EvaluateTargetHealth: aws.Bool(true),
+			},
+		},
+	}
+
+	update := controller.IngressUpdate{
+		Entries: []controller.IngressEntry{
+			{Name: "dualstack-entry", Host: "foo.james.com", ELbScheme: "internal", DualStack: true},
+			{Name: "ipv4-entry", Host: "bar.james.com", ELbScheme: "internal"},
+		},
+	}
+
+	// when
+	actualChanges, err := calculateChanges(frontEnds, records, update, domain, "", nil)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, []*route53.Change{
+		newChange("UPSERT", "foo.james.com", "AAAA", "dualstack.elb-dnsname", "elb-hosted-zone-id"),
+		newChange("UPSERT", "bar.james.com", "A", "elb-dnsname", "elb-hosted-zone-id"),
+		newChange("DELETE", "bar.james.com.", "AAAA", "dualstack.elb-dnsname.", "elb-hosted-zone-id"),
+	}, actualChanges)
+}
+
+func TestWeightedRecordsOfOtherClustersAreLeftAlone(t *testing.T) {
+	// given
+	weightedRecord := func(host, setIdentifier string, weight int64) *route53.ResourceRecordSet {
+		return &route53.ResourceRecordSet{
+			Name:          aws.String(host),
+			Type:          aws.String("A"),
+			SetIdentifier: aws.String(setIdentifier),
+			Weight:        aws.Int64(weight),
+			AliasTarget: &route53.AliasTarget{
+				DNSName:              aws.String(elbDNSName),
+				HostedZoneId:         aws.String(r53Zone),
+				EvaluateTargetHealth: aws.Bool(true),
+			},
+		}
+	}
+	fakeR53 := new(fakeR53Client)
+	fakeR53.On("GetHostedZoneDomain").Return(domain, nil)
+	fakeR53.On("IsPrivateHostedZone").Return(false, nil)
+	fakeR53.On("GetRecords").Return([]*route53.ResourceRecordSet{
+		weightedRecord("foo.james.com.", "cluster-a", 1),
+		weightedRecord("foo.james.com.", "cluster-b", 1),
+		weightedRecord("bar.james.com.", "cluster-a", 1),
+		weightedRecord("bar.james.com.", "cluster-b", 1),
+		weightedRecord("baz.james.com.", "cluster-b", 1),
+	}, nil)
+	dnsUpdater := newDNSUpdater(map[string]*fakeR53Client{r53Zone: fakeR53})
+	dnsUpdater.setIdentifier = "cluster-a"
+
+	update := controller.IngressUpdate{
+		Entries: []controller.IngressEntry{
+			{Host: "foo.james.com", ELbScheme: "internal", DNSRouting: controller.DNSRouting{Weight: 1}},
+			{Host: "baz.james.com", ELbScheme: "internal", DNSRouting: controller.DNSRouting{Failover: "PRIMARY"}},
+		},
+	}
+
+	upsert := newChange("UPSERT", "baz.james.com", "A", elbDNSName, r53Zone)
+	upsert.ResourceRecordSet.SetIdentifier = aws.String("cluster-a")
+	upsert.ResourceRecordSet.Failover = aws.String("PRIMARY")
+	deletion := &route53.Change{Action: aws.String("DELETE"), ResourceRecordSet: weightedRecord("bar.james.com.", "cluster-a", 1)}
+	fakeR53.On("UpdateRecordSets", []*route53.Change{upsert, deletion}).Return(nil, nil)
+
+	// when
+	assert.NoError(t, dnsUpdater.Start())
+	err := dnsUpdater.Update(update)
+
+	// then
+	assert.NoError(t, err)
+	fakeR53.AssertExpectations(t)
+}
+
+func TestChangedWeightIsUpdated(t *testing.T) {
+	// given
+	frontEnds := map[string]elb.LoadBalancerDetails{
+		"internal": {DNSName: "elb-dnsname", HostedZoneID: "elb-hosted-zone-id", Scheme: "internal"},
+	}
+	records := []*route53.ResourceRecordSet{
+		{
+			Name:          aws.String("foo.james.com."),
+			Type:          aws.String("A"),
+			SetIdentifier: aws.String("cluster-a"),
+			Weight:        aws.Int64(1),
+			AliasTarget: &route53.AliasTarget{
+				DNSName:              aws.String("elb-dnsname."),
+				HostedZoneId:         aws.String("elb-hosted-zone-id"),
+				EvaluateTargetHealth: aws.Bool(true),
+			},
+		},
+	}
+	update := controller.IngressUpdate{
+		Entries: []controller.IngressEntry{
+			{Host: "foo.james.com", ELbScheme: "internal", DNSRouting: controller.DNSRouting{Weight: 0}},
+		},
+	}
+
+	// when
+	actualChanges, err := calculateChanges(frontEnds, records, update, domain, "cluster-a", nil)
+
+	// then
+	expected := newChange("UPSERT", "foo.james.com", "A", "elb-dnsname", "elb-hosted-zone-id")
+	expected.ResourceRecordSet.SetIdentifier = aws.String("cluster-a")
+	expected.ResourceRecordSet.Weight = aws.Int64(0)
+	assert.NoError(t, err)
+	assert.Equal(t, []*route53.Change{expected}, actualChanges)
+}
+
 func TestDeletingExistingRecordSet(t *testing.T) {
 	// given
 	frontEnds := map[string]elb.LoadBalancerDetails{
@@ -299,6 +1001,7 @@ func TestDeletingExistingRecordSet(t *testing.T) {
 	aRecords := []*route53.ResourceRecordSet{
 		{
 			Name: aws.String("foo.com"),
+			Type: aws.String("A"),
 			AliasTarget: &route53.AliasTarget{
 				DNSName:              aws.String("elb-dnsname"),
 				HostedZoneId:         aws.String("elb-hosted-zone-id"),
@@ -312,7 +1015,7 @@ func TestDeletingExistingRecordSet(t *testing.T) {
 	}
 
 	// when
-	actualChanges, _ := calculateChanges(frontEnds, aRecords, update, domain)
+	actualChanges, _ := calculateChanges(frontEnds, aRecords, update, domain, "", nil)
 
 	// then
 	expectedRecordSetsInput := []*route53.Change{
@@ -347,6 +1050,7 @@ func TestDeletingAndAddingADifferentRecordSet(t *testing.T) {
 	aRecords := []*route53.ResourceRecordSet{
 		{
 			Name: aws.String("bar.james.com"),
+			Type: aws.String("A"),
 			AliasTarget: &route53.AliasTarget{
 				DNSName:              aws.String("elb-dnsname"),
 				HostedZoneId:         aws.String("elb-hosted-zone-id"),
@@ -368,7 +1072,7 @@ func TestDeletingAndAddingADifferentRecordSet(t *testing.T) {
 	}
 
 	// when
-	actualChanges, err := calculateChanges(frontEnds, aRecords, update, domain)
+	actualChanges, err := calculateChanges(frontEnds, aRecords, update, domain, "", nil)
 
 	// then
 	assert.NoError(t, err)
@@ -421,7 +1125,7 @@ func TestErrorResponseWhenElbNotFound(t *testing.T) {
 	}
 
 	// when
-	_, err := calculateChanges(frontEnds, aRecords, update, domain)
+	_, err := calculateChanges(frontEnds, aRecords, update, domain, "", nil)
 
 	// then
 	assert.Error(t, err, "Expecting an error when load balancer could not be found.")
@@ -445,8 +1149,314 @@ func TestIngressWithNoFrontEndsAreIgnored(t *testing.T) {
 	}
 
 	// when
-	actualChanges, _ := calculateChanges(frontEnds, aRecords, update, domain)
+	actualChanges, _ := calculateChanges(frontEnds, aRecords, update, domain, "", nil)
 
 	// then
 	assert.Empty(t, actualChanges)
 }
+
+func TestIgnoredEntriesAndAliases(t *testing.T) {
+	// given
+	existing := []*route53.ResourceRecordSet{{
+		Name: aws.String("ignored.james.com."),
+		Type: aws.String("A"),
+		AliasTarget: &route53.AliasTarget{
+			DNSName:              aws.String(elbDNSName),
+			HostedZoneId:         aws.String(r53Zone),
+			EvaluateTargetHealth: aws.Bool(true),
+		},
+	}}
+	update := controller.IngressUpdate{
+		Entries: []controller.IngressEntry{
+			{Name: "ignored", Host: "ignored.james.com", ELbScheme: "internal", DNSIgnore: true},
+			{
+				Name:       "aliased",
+				Host:       "cats.james.com",
+				ELbScheme:  "internal",
+				DNSAliases: []string{"www.cats.james.com", "cats.notjames.com"},
+			},
+		},
+	}
+
+	// when
+	changes, err := calculateChanges(defaultFrontends, existing, update, domain, "", nil)
+
+	// then
+	assert.NoError(t, err)
+	deleteIgnored := newChange("DELETE", "ignored.james.com.", "A", elbDNSName, r53Zone)
+	assert.Equal(t, []*route53.Change{
+		newChange("UPSERT", "cats.james.com", "A", elbDNSName, r53Zone),
+		newChange("UPSERT", "www.cats.james.com", "A", elbDNSName, r53Zone),
+		deleteIgnored,
+	}, changes)
+}
+
+func TestAliasesAreAssignedToTheirOwnHostedZone(t *testing.T) {
+	// given
+	jamesZone := newFakeR53Client("james.com.", false)
+	catsZone := newFakeR53Client("cats.com.", false)
+	dnsUpdater := newDNSUpdater(map[string]*fakeR53Client{"james": jamesZone, "cats": catsZone})
+	update := controller.IngressUpdate{Entries: []controller.IngressEntry{
+		{Host: "foo.james.com", ELbScheme: "internal", DNSAliases: []string{"foo.cats.com"}},
+	}}
+	jamesZone.On("UpdateRecordSets",
+		[]*route53.Change{newChange("UPSERT", "foo.james.com", "A", elbDNSName, r53Zone)}).Return(nil, nil)
+	catsZone.On("UpdateRecordSets",
+		[]*route53.Change{newChange("UPSERT", "foo.cats.com", "A", elbDNSName, r53Zone)}).Return(nil, nil)
+
+	// when
+	assert.NoError(t, dnsUpdater.Start())
+	err := dnsUpdater.Update(update)
+
+	// then
+	assert.NoError(t, err)
+	jamesZone.AssertExpectations(t)
+	catsZone.AssertExpectations(t)
+}
+
+func TestHealthChecksAreCreatedAssociatedAndCleanedUp(t *testing.T) {
+	// given
+	fakeR53 := newFakeR53Client(domain, false)
+	dnsUpdater := newDNSUpdater(map[string]*fakeR53Client{r53Zone: fakeR53})
+	dnsUpdater.healthChecks = true
+	dnsUpdater.healthCheckPort = 80
+	dnsUpdater.findElbs = func(elb.ELB, string) (map[string]elb.LoadBalancerDetails, error) {
+		return map[string]elb.LoadBalancerDetails{"internet-facing": defaultFrontends["internal"]}, nil
+	}
+
+	existingConfig := func(host, path string) *route53.HealthCheckConfig {
+		return dnsUpdater.healthCheckConfig(controller.IngressEntry{Host: host, Path: path})
+	}
+	fakeR53.On("GetHealthChecks", mock.Anything).Return([]*route53.HealthCheck{
+		{Id: aws.String("foo-check"), HealthCheckConfig: existingConfig("foo.james.com", "/foo/")},
+		{Id: aws.String("orphan-check"), HealthCheckConfig: existingConfig("gone.james.com", "/")},
+	}, nil)
+	dnsUpdater.now = func() time.Time { return time.Unix(0, 1500000000000000000) }
+	fakeR53.On("CreateHealthCheck", dnsUpdater.healthCheckPrefix(&hostedZone{id: r53Zone})+"1500000000000000000",
+		&route53.HealthCheckConfig{
+			Type:                     aws.String("HTTP"),
+			FullyQualifiedDomainName: aws.String("bar.james.com"),
+			Port:                     aws.Int64(80),
+			ResourcePath:             aws.String("/healthz"),
+			RequestInterval:          aws.Int64(30),
+			FailureThreshold:         aws.Int64(3),
+		}).Return("bar-check", nil)
+
+	fooChange := newChange("UPSERT", "foo.james.com", "A", elbDNSName, r53Zone)
+	fooChange.ResourceRecordSet.HealthCheckId = aws.String("foo-check")
+	barChange := newChange("UPSERT", "bar.james.com", "A", elbDNSName, r53Zone)
+	barChange.ResourceRecordSet.HealthCheckId = aws.String("bar-check")
+	fakeR53.On("UpdateRecordSets", []*route53.Change{fooChange, barChange}).Return(nil, nil)
+	fakeR53.On("DeleteHealthCheck", "orphan-check").Return(nil)
+
+	update := controller.IngressUpdate{Entries: []controller.IngressEntry{
+		{Host: "foo.james.com", Path: "/foo/", ELbScheme: "internet-facing"},
+		{Host: "bar.james.com", Path: "/bar/", ELbScheme: "internet-facing", DNSHealthCheckPath: "/healthz"},
+	}}
+
+	// when
+	assert.NoError(t, dnsUpdater.Start())
+	err := dnsUpdater.Update(update)
+
+	// then
+	assert.NoError(t, err)
+	fakeR53.AssertExpectations(t)
+	assert.Len(t, dnsUpdater.zones[0].healthChecks, 2)
+}
+
+func TestFailedHealthCheckDeletionIsRetried(t *testing.T) {
+	// given
+	fakeR53 := newFakeR53Client(domain, false)
+	dnsUpdater := newDNSUpdater(map[string]*fakeR53Client{r53Zone: fakeR53})
+	dnsUpdater.healthChecks = true
+	fakeR53.On("GetHealthChecks", mock.Anything).Return([]*route53.HealthCheck{{Id: aws.String("orphan-check")}}, nil)
+	fakeR53.On("DeleteHealthCheck", "orphan-check").Return(errors.New("HealthCheckInUse")).Once()
+	fakeR53.On("DeleteHealthCheck", "orphan-check").Return(nil).Once()
+
+	// when
+	assert.NoError(t, dnsUpdater.Start())
+	assert.NoError(t, dnsUpdater.Update(controller.IngressUpdate{}))
+	assert.Len(t, dnsUpdater.zones[0].healthChecks, 1)
+	assert.NoError(t, dnsUpdater.Update(controller.IngressUpdate{}))
+
+	// then
+	fakeR53.AssertExpectations(t)
+	assert.Empty(t, dnsUpdater.zones[0].healthChecks)
+}
+
+func TestHealthCheckPrefixDependsOnZoneAndSetIdentifier(t *testing.T) {
+	clusterA := &updater{setIdentifier: "a"}
+	clusterB := &updater{setIdentifier: "b"}
+	zone1 := &hostedZone{id: "zone-1"}
+	zone2 := &hostedZone{id: "zone-2"}
+
+	assert.Equal(t, clusterA.healthCheckPrefix(zone1), clusterA.healthCheckPrefix(zone1))
+	assert.NotEqual(t, clusterA.healthCheckPrefix(zone1), clusterB.healthCheckPrefix(zone1))
+	assert.NotEqual(t, clusterA.healthCheckPrefix(zone1), clusterA.healthCheckPrefix(zone2))
+}
+
+func TestDeletionsAreDeferredForGracePeriod(t *testing.T) {
+	// given
+	assert := assert.New(t)
+	oldRecord := &route53.ResourceRecordSet{
+		Name: aws.String("old.james.com."),
+		Type: aws.String("A"),
+		AliasTarget: &route53.AliasTarget{
+			DNSName:              aws.String(elbDNSName),
+			HostedZoneId:         aws.String(r53Zone),
+			EvaluateTargetHealth: aws.Bool(true),
+		},
+	}
+	fakeR53 := new(fakeR53Client)
+	fakeR53.On("GetHostedZoneDomain").Return(domain, nil)
+	fakeR53.On("IsPrivateHostedZone").Return(false, nil)
+	fakeR53.On("GetRecord", "_feed-dns-grace.james.com.", "TXT", "").Return(nil, nil)
+	fakeR53.On("GetRecords").Return([]*route53.ResourceRecordSet{oldRecord}, nil).Times(3)
+	fakeR53.On("GetRecords").Return([]*route53.ResourceRecordSet{}, nil).Once()
+	dnsUpdater := newDNSUpdater(map[string]*fakeR53Client{r53Zone: fakeR53})
+	dnsUpdater.gracePeriod = time.Minute
+	start := time.Unix(1000, 0)
+	dnsUpdater.now = func() time.Time { return start }
+
+	graceRecord := &route53.ResourceRecordSet{
+		Name:            aws.String("_feed-dns-grace.james.com."),
+		Type:            aws.String("TXT"),
+		TTL:             aws.Int64(300),
+		ResourceRecords: []*route53.ResourceRecord{{Value: aws.String(`"old.james.com. A 1000"`)}},
+	}
+	upsertGrace := &route53.Change{Action: aws.String("UPSERT"), ResourceRecordSet: graceRecord}
+	deleteOld := newChange("DELETE", "old.james.com.", "A", elbDNSName, r53Zone)
+	deleteGrace := &route53.Change{Action: aws.String("DELETE"), ResourceRecordSet: graceRecord}
+	fakeR53.On("UpdateRecordSets", []*route53.Change{upsertGrace}).Return(nil, nil).Once()
+	fakeR53.On("UpdateRecordSets", []*route53.Change{deleteOld}).Return(nil, nil).Once()
+	fakeR53.On("UpdateRecordSets", []*route53.Change{deleteGrace}).Return(nil, nil).Once()
+
+	// when
+	assert.NoError(dnsUpdater.Start())
+	// first missing, deletion is deferred
+	assert.NoError(dnsUpdater.Update(controller.IngressUpdate{}))
+	// still within the grace period, nothing changes
+	dnsUpdater.now = func() time.Time { return start.Add(30 * time.Second) }
+	assert.NoError(dnsUpdater.Update(controller.IngressUpdate{}))
+	// grace period has passed, deleted
+	dnsUpdater.now = func() time.Time { return start.Add(time.Minute) }
+	assert.NoError(dnsUpdater.Update(controller.IngressUpdate{}))
+	// record is gone, so is no longer pending deletion
+	assert.NoError(dnsUpdater.Update(controller.IngressUpdate{}))
+
+	// then
+	fakeR53.AssertExpectations(t)
+	assert.Empty(dnsUpdater.zones[0].missingSince)
+	assert.Nil(dnsUpdater.zones[0].graceRecord)
+}
+
+func TestHealthChecksOfRecordsAwaitingDeletionAreKept(t *testing.T) {
+	// given
+	oldRecord := &route53.ResourceRecordSet{
+		Name: aws.String("old.james.com."),
+		Type: aws.String("A"),
+		AliasTarget: &route53.AliasTarget{
+			DNSName:              aws.String(elbDNSName),
+			HostedZoneId:         aws.String(r53Zone),
+			EvaluateTargetHealth: aws.Bool(true),
+		},
+		HealthCheckId: aws.String("old-check"),
+	}
+	fakeR53 := new(fakeR53Client)
+	fakeR53.On("GetHostedZoneDomain").Return(domain, nil)
+	fakeR53.On("IsPrivateHostedZone").Return(false, nil)
+	fakeR53.On("GetRecord", "_feed-dns-grace.james.com.", "TXT", "").Return(nil, nil)
+	fakeR53.On("GetHealthChecks", mock.Anything).Return([]*route53.HealthCheck{{Id: aws.String("old-check")}}, nil)
+	fakeR53.On("GetRecords").Return([]*route53.ResourceRecordSet{oldRecord}, nil)
+	fakeR53.On("UpdateRecordSets", mock.Anything).Return(nil, nil)
+	dnsUpdater := newDNSUpdater(map[string]*fakeR53Client{r53Zone: fakeR53})
+	dnsUpdater.healthChecks = true
+	dnsUpdater.gracePeriod = time.Minute
+	dnsUpdater.now = func() time.Time { return time.Unix(1000, 0) }
+
+	// when
+	assert.NoError(t, dnsUpdater.Start())
+	assert.NoError(t, dnsUpdater.Update(controller.IngressUpdate{}))
+
+	// then
+	fakeR53.AssertNotCalled(t, "DeleteHealthCheck", mock.Anything)
+	assert.Len(t, dnsUpdater.zones[0].healthChecks, 1)
+}
+
+func TestLongGraceValuesAreSplitIntoCharacterStrings(t *testing.T) {
+	text := strings.Repeat("a", 300) + ".james.com. A 1000"
+
+	value := txtValue(text)
+
+	assert.Equal(t, `"`+strings.Repeat("a", 255)+`" "`+strings.Repeat("a", 45)+`.james.com. A 1000"`, value)
+	assert.Equal(t, text, txtText(value))
+	assert.Equal(t, "old.james.com. A 1000", txtText(txtValue("old.james.com. A 1000")))
+}
+
+func TestGracePeriodSurvivesRestarts(t *testing.T) {
+	// given
+	oldRecord := &route53.ResourceRecordSet{
+		Name: aws.String("old.james.com."),
+		Type: aws.String("A"),
+		AliasTarget: &route53.AliasTarget{
+			DNSName:              aws.String(elbDNSName),
+			HostedZoneId:         aws.String(r53Zone),
+			EvaluateTargetHealth: aws.Bool(true),
+		},
+	}
+	graceRecord := &route53.ResourceRecordSet{
+		Name:            aws.String("_feed-dns-grace.james.com."),
+		Type:            aws.String("TXT"),
+		TTL:             aws.Int64(300),
+		SetIdentifier:   aws.String("cluster-a"),
+		Weight:          aws.Int64(0),
+		ResourceRecords: []*route53.ResourceRecord{{Value: aws.String(`"old.james.com. A 1000"`)}},
+	}
+	fakeR53 := new(fakeR53Client)
+	fakeR53.On("GetHostedZoneDomain").Return(domain, nil)
+	fakeR53.On("IsPrivateHostedZone").Return(false, nil)
+	fakeR53.On("GetRecord", "_feed-dns-grace.james.com.", "TXT", "cluster-a").Return(graceRecord, nil)
+	oldRecord.SetIdentifier = aws.String("cluster-a")
+	fakeR53.On("GetRecords").Return([]*route53.ResourceRecordSet{oldRecord}, nil)
+	dnsUpdater := newDNSUpdater(map[string]*fakeR53Client{r53Zone: fakeR53})
+	dnsUpdater.setIdentifier = "cluster-a"
+	dnsUpdater.gracePeriod = time.Minute
+	dnsUpdater.now = func() time.Time { return time.Unix(1059, 0) }
+
+	// when
+	assert.NoError(t, dnsUpdater.Start())
+	err := dnsUpdater.Update(controller.IngressUpdate{})
+
+	// then
+	assert.NoError(t, err)
+	fakeR53.AssertNotCalled(t, "UpdateRecordSets", mock.Anything)
+	assert.Equal(t, map[recordKey]time.Time{{name: "old.james.com.", recordType: "A"}: time.Unix(1000, 0)},
+		dnsUpdater.zones[0].missingSince)
+}
+
+func TestReturningHostIsNoLongerPendingDeletion(t *testing.T) {
+	// given
+	graceRecord := &route53.ResourceRecordSet{
+		Name:            aws.String("_feed-dns-grace.james.com."),
+		Type:            aws.String("TXT"),
+		ResourceRecords: []*route53.ResourceRecord{{Value: aws.String(`"foo.james.com. A 1000"`)}},
+	}
+	fakeR53 := newFakeR53Client(domain, false)
+	fakeR53.On("GetRecord", "_feed-dns-grace.james.com.", "TXT", "").Return(graceRecord, nil)
+	dnsUpdater := newDNSUpdater(map[string]*fakeR53Client{r53Zone: fakeR53})
+	dnsUpdater.gracePeriod = time.Minute
+	fakeR53.On("UpdateRecordSets", []*route53.Change{
+		newChange("UPSERT", "foo.james.com", "A", elbDNSName, r53Zone),
+		{Action: aws.String("DELETE"), ResourceRecordSet: graceRecord},
+	}).Return(nil, nil)
+
+	// when
+	assert.NoError(t, dnsUpdater.Start())
+	err := dnsUpdater.Update(controller.IngressUpdate{Entries: []controller.IngressEntry{
+		{Host: "foo.james.com", ELbScheme: "internal"},
+	}})
+
+	// then
+	assert.NoError(t, err)
+	fakeR53.AssertExpectations(t)
+}
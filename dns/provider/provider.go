@@ -0,0 +1,63 @@
+/*
+Package provider defines DNS backends that feed-dns writes plain records to, such as DNS servers or zone files,
+as opposed to Route53 which uses aliases to ELBs.
+*/
+package provider
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Record is a DNS record set, all the records with the same name and type.
+type Record struct {
+	// Name is the fully qualified name, with a trailing period.
+	Name string
+	// Type is A, AAAA, CNAME, or TXT.
+	Type string
+	// TTL in seconds.
+	TTL uint32
+	// Values are IP addresses for A and AAAA records, a fully qualified name with a trailing period for CNAME,
+	// or a single string without spaces or quotes for each TXT record.
+	Values []string
+}
+
+// Provider is a DNS backend that holds the records for ingress hosts in a single zone.
+type Provider interface {
+	// Domain returns the domain of the zone, with a trailing period.
+	Domain() string
+	// GetRecords returns all the A, AAAA, CNAME, and TXT record sets in the zone.
+	GetRecords() ([]Record, error)
+	// UpdateRecords deletes the removed record sets, then adds the added record sets.
+	UpdateRecords(remove, add []Record) error
+}
+
+// Equal returns true if the record sets have the same name, type, ttl and values, in any order.
+func (r Record) Equal(other Record) bool {
+	if !strings.EqualFold(r.Name, other.Name) || r.Type != other.Type || r.TTL != other.TTL ||
+		len(r.Values) != len(other.Values) {
+		return false
+	}
+	values := sortedLower(r.Values)
+	otherValues := sortedLower(other.Values)
+	for i := range values {
+		if values[i] != otherValues[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (r Record) String() string {
+	return fmt.Sprintf("%s %d %s %s", r.Name, r.TTL, r.Type, strings.Join(r.Values, ","))
+}
+
+func sortedLower(values []string) []string {
+	sorted := make([]string, len(values))
+	for i, v := range values {
+		sorted[i] = strings.ToLower(v)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
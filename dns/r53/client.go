@@ -2,6 +2,7 @@ package r53
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -9,13 +10,22 @@ import (
 	"github.com/sky-uk/feed/util"
 )
 
-const maxRecordChanges = 100
+const (
+	maxRecordChanges = 100
+	hostedZonePrefix = "/hostedzone/"
+)
 
 // Route53Client is the public interface
 type Route53Client interface {
 	GetHostedZoneDomain() (string, error)
-	UpdateRecordSets(changes []*route53.Change) error
-	GetARecords() ([]*route53.ResourceRecordSet, error)
+	IsPrivateHostedZone() (bool, error)
+	UpdateRecordSets(changes []*route53.Change) ([]string, error)
+	GetRecords() ([]*route53.ResourceRecordSet, error)
+	GetRecord(name, recordType, setIdentifier string) (*route53.ResourceRecordSet, error)
+	IsChangeInSync(changeID string) (bool, error)
+	GetHealthChecks(callerReferencePrefix string) ([]*route53.HealthCheck, error)
+	CreateHealthCheck(callerReference string, config *route53.HealthCheckConfig) (string, error)
+	DeleteHealthCheck(id string) error
 }
 
 // r53 interface exposes the subset of methods we use of the aws sdk
@@ -23,6 +33,11 @@ type r53 interface {
 	GetHostedZone(input *route53.GetHostedZoneInput) (*route53.GetHostedZoneOutput, error)
 	ChangeResourceRecordSets(input *route53.ChangeResourceRecordSetsInput) (*route53.ChangeResourceRecordSetsOutput, error)
 	ListResourceRecordSets(input *route53.ListResourceRecordSetsInput) (*route53.ListResourceRecordSetsOutput, error)
+	ListHostedZonesByName(input *route53.ListHostedZonesByNameInput) (*route53.ListHostedZonesByNameOutput, error)
+	GetChange(input *route53.GetChangeInput) (*route53.GetChangeOutput, error)
+	ListHealthChecks(input *route53.ListHealthChecksInput) (*route53.ListHealthChecksOutput, error)
+	CreateHealthCheck(input *route53.CreateHealthCheckInput) (*route53.CreateHealthCheckOutput, error)
+	DeleteHealthCheck(input *route53.DeleteHealthCheckInput) (*route53.DeleteHealthCheckOutput, error)
 }
 
 // Route53Client enables interaction with aws route53
@@ -35,24 +50,94 @@ type client struct {
 // New creates a route53 client used to interact with aws
 func New(region string, hostedZone string) Route53Client {
 	return &client{
-		r53:              route53.New(session.New(), &aws.Config{Region: aws.String(region)}),
+		r53:              newSdk(region),
 		hostedZone:       hostedZone,
 		maxRecordChanges: maxRecordChanges,
 	}
 }
 
-// GetHostedZone gets the domain for the hosted zone
+func newSdk(region string) r53 {
+	return route53.New(session.New(), &aws.Config{Region: aws.String(region)})
+}
+
+// FindHostedZoneIDs returns the IDs of all hosted zones, public and private, whose domain
+// exactly matches one of the given domains.
+func FindHostedZoneIDs(region string, domains []string) ([]string, error) {
+	return findHostedZoneIDs(newSdk(region), domains)
+}
+
+func findHostedZoneIDs(r53 r53, domains []string) ([]string, error) {
+	var ids []string
+	for _, domain := range domains {
+		domain = strings.TrimSuffix(domain, ".") + "."
+		request := &route53.ListHostedZonesByNameInput{DNSName: aws.String(domain)}
+		found := 0
+
+	pages:
+		for {
+			output, err := r53.ListHostedZonesByName(request)
+			if err != nil {
+				return nil, fmt.Errorf("unable to list hosted zones for %s: %v", domain, err)
+			}
+
+			// zones are returned in order of name, starting from the requested domain
+			for _, zone := range output.HostedZones {
+				if aws.StringValue(zone.Name) != domain {
+					break pages
+				}
+				ids = append(ids, strings.TrimPrefix(aws.StringValue(zone.Id), hostedZonePrefix))
+				found++
+			}
+
+			if !aws.BoolValue(output.IsTruncated) {
+				break
+			}
+
+			request = &route53.ListHostedZonesByNameInput{
+				DNSName:      output.NextDNSName,
+				HostedZoneId: output.NextHostedZoneId,
+			}
+		}
+
+		if found == 0 {
+			return nil, fmt.Errorf("no hosted zones found for %s", domain)
+		}
+	}
+
+	return ids, nil
+}
+
+// GetHostedZoneDomain gets the domain for the hosted zone
 func (dns *client) GetHostedZoneDomain() (string, error) {
+	hostedZone, err := dns.getHostedZone()
+	if err != nil {
+		return "", err
+	}
+	return *hostedZone.Name, nil
+}
+
+// IsPrivateHostedZone returns true if the hosted zone is associated with VPCs rather than the internet.
+func (dns *client) IsPrivateHostedZone() (bool, error) {
+	hostedZone, err := dns.getHostedZone()
+	if err != nil {
+		return false, err
+	}
+	return hostedZone.Config != nil && aws.BoolValue(hostedZone.Config.PrivateZone), nil
+}
+
+func (dns *client) getHostedZone() (*route53.HostedZone, error) {
 	input := &route53.GetHostedZoneInput{Id: aws.String(dns.hostedZone)}
 	hostedZone, err := dns.r53.GetHostedZone(input)
 	if err != nil {
-		return "", fmt.Errorf("unable to get Hosted Zone Info: %v", err)
+		return nil, fmt.Errorf("unable to get Hosted Zone Info: %v", err)
 	}
-	return *hostedZone.HostedZone.Name, nil
+	return hostedZone.HostedZone, nil
 }
 
-// UpdateRecordSets updates records in aws based on the change list.
-func (dns *client) UpdateRecordSets(changes []*route53.Change) error {
+// UpdateRecordSets updates records in aws based on the change list. It returns the IDs of the
+// submitted change batches, which can be checked with IsChangeInSync.
+func (dns *client) UpdateRecordSets(changes []*route53.Change) ([]string, error) {
+	var changeIDs []string
 	partitions := util.Partition(len(changes), dns.maxRecordChanges)
 	for _, partition := range partitions {
 		batch := changes[partition.Low:partition.High]
@@ -63,19 +148,32 @@ func (dns *client) UpdateRecordSets(changes []*route53.Change) error {
 			},
 		}
 
-		_, err := dns.r53.ChangeResourceRecordSets(recordSetsInput)
+		output, err := dns.r53.ChangeResourceRecordSets(recordSetsInput)
 
 		if err != nil {
-			return fmt.Errorf("failed to create A record: %v", err)
+			return changeIDs, fmt.Errorf("failed to create A record: %v", err)
+		}
+
+		if output.ChangeInfo != nil {
+			changeIDs = append(changeIDs, aws.StringValue(output.ChangeInfo.Id))
 		}
 	}
 
-	return nil
+	return changeIDs, nil
 }
 
-// GetARecords gets a list of A Records from aws.
-func (dns *client) GetARecords() ([]*route53.ResourceRecordSet, error) {
-	aRecords := []*route53.ResourceRecordSet{}
+// IsChangeInSync returns true once a submitted change batch has propagated to all Route53 DNS servers.
+func (dns *client) IsChangeInSync(changeID string) (bool, error) {
+	output, err := dns.r53.GetChange(&route53.GetChangeInput{Id: aws.String(changeID)})
+	if err != nil {
+		return false, fmt.Errorf("unable to get status of change %s: %v", changeID, err)
+	}
+	return aws.StringValue(output.ChangeInfo.Status) == route53.ChangeStatusInsync, nil
+}
+
+// GetRecords gets a list of A and AAAA Records from aws.
+func (dns *client) GetRecords() ([]*route53.ResourceRecordSet, error) {
+	records := []*route53.ResourceRecordSet{}
 	request := &route53.ListResourceRecordSetsInput{
 		HostedZoneId: aws.String(dns.hostedZone),
 	}
@@ -83,14 +181,14 @@ func (dns *client) GetARecords() ([]*route53.ResourceRecordSet, error) {
 		recordSetsOutput, err := dns.r53.ListResourceRecordSets(request)
 
 		if err != nil {
-			return nil, fmt.Errorf("failed to fetch A records: %v", err)
+			return nil, fmt.Errorf("failed to fetch records: %v", err)
 		}
 
 		recordSets := recordSetsOutput.ResourceRecordSets
 
 		for _, recordSet := range recordSets {
-			if *recordSet.Type == route53.RRTypeA {
-				aRecords = append(aRecords, recordSet)
+			if *recordSet.Type == route53.RRTypeA || *recordSet.Type == route53.RRTypeAaaa {
+				records = append(records, recordSet)
 			}
 		}
 
@@ -105,5 +203,89 @@ func (dns *client) GetARecords() ([]*route53.ResourceRecordSet, error) {
 		}
 	}
 
-	return aRecords, nil
+	return records, nil
+}
+
+// GetRecord gets a single record set by name, type and set identifier. It returns nil if there is no such
+// record set.
+func (dns *client) GetRecord(name, recordType, setIdentifier string) (*route53.ResourceRecordSet, error) {
+	request := &route53.ListResourceRecordSetsInput{
+		HostedZoneId:    aws.String(dns.hostedZone),
+		StartRecordName: aws.String(name),
+		StartRecordType: aws.String(recordType),
+	}
+	for {
+		output, err := dns.r53.ListResourceRecordSets(request)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch %s record %s: %v", recordType, name, err)
+		}
+
+		// record sets are returned in order, starting from the requested name and type
+		for _, recordSet := range output.ResourceRecordSets {
+			if aws.StringValue(recordSet.Name) != name || aws.StringValue(recordSet.Type) != recordType {
+				return nil, nil
+			}
+			if aws.StringValue(recordSet.SetIdentifier) == setIdentifier {
+				return recordSet, nil
+			}
+		}
+
+		if !aws.BoolValue(output.IsTruncated) {
+			return nil, nil
+		}
+
+		request = &route53.ListResourceRecordSetsInput{
+			HostedZoneId:          aws.String(dns.hostedZone),
+			StartRecordName:       output.NextRecordName,
+			StartRecordType:       output.NextRecordType,
+			StartRecordIdentifier: output.NextRecordIdentifier,
+		}
+	}
+}
+
+// GetHealthChecks returns the health checks whose caller reference starts with the prefix.
+func (dns *client) GetHealthChecks(callerReferencePrefix string) ([]*route53.HealthCheck, error) {
+	var healthChecks []*route53.HealthCheck
+	request := &route53.ListHealthChecksInput{}
+	for {
+		output, err := dns.r53.ListHealthChecks(request)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch health checks: %v", err)
+		}
+
+		for _, healthCheck := range output.HealthChecks {
+			if strings.HasPrefix(aws.StringValue(healthCheck.CallerReference), callerReferencePrefix) {
+				healthChecks = append(healthChecks, healthCheck)
+			}
+		}
+
+		if !aws.BoolValue(output.IsTruncated) {
+			break
+		}
+
+		request = &route53.ListHealthChecksInput{Marker: output.NextMarker}
+	}
+
+	return healthChecks, nil
+}
+
+// CreateHealthCheck creates a health check, returning its ID. The caller reference must be unique.
+func (dns *client) CreateHealthCheck(callerReference string, config *route53.HealthCheckConfig) (string, error) {
+	output, err := dns.r53.CreateHealthCheck(&route53.CreateHealthCheckInput{
+		CallerReference:   aws.String(callerReference),
+		HealthCheckConfig: config,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create health check: %v", err)
+	}
+	return aws.StringValue(output.HealthCheck.Id), nil
+}
+
+// DeleteHealthCheck deletes a health check. It fails if the health check is still used by a record.
+func (dns *client) DeleteHealthCheck(id string) error {
+	_, err := dns.r53.DeleteHealthCheck(&route53.DeleteHealthCheckInput{HealthCheckId: aws.String(id)})
+	if err != nil {
+		return fmt.Errorf("failed to delete health check %s: %v", id, err)
+	}
+	return nil
 }
@@ -44,6 +44,51 @@ func (m *fake53) ListResourceRecordSets(input *route53.ListResourceRecordSetsInp
 	return args.Get(0).(*route53.ListResourceRecordSetsOutput), err
 }
 
+func (m *fake53) ListHostedZonesByName(input *route53.ListHostedZonesByNameInput) (*route53.ListHostedZonesByNameOutput, error) {
+	args := m.Called(input)
+	err := args.Error(1)
+	if err != nil {
+		return nil, err
+	}
+	return args.Get(0).(*route53.ListHostedZonesByNameOutput), err
+}
+
+func (m *fake53) GetChange(input *route53.GetChangeInput) (*route53.GetChangeOutput, error) {
+	args := m.Called(input)
+	err := args.Error(1)
+	if err != nil {
+		return nil, err
+	}
+	return args.Get(0).(*route53.GetChangeOutput), err
+}
+
+func (m *fake53) ListHealthChecks(input *route53.ListHealthChecksInput) (*route53.ListHealthChecksOutput, error) {
+	args := m.Called(input)
+	err := args.Error(1)
+	if err != nil {
+		return nil, err
+	}
+	return args.Get(0).(*route53.ListHealthChecksOutput), err
+}
+
+func (m *fake53) CreateHealthCheck(input *route53.CreateHealthCheckInput) (*route53.CreateHealthCheckOutput, error) {
+	args := m.Called(input)
+	err := args.Error(1)
+	if err != nil {
+		return nil, err
+	}
+	return args.Get(0).(*route53.CreateHealthCheckOutput), err
+}
+
+func (m *fake53) DeleteHealthCheck(input *route53.DeleteHealthCheckInput) (*route53.DeleteHealthCheckOutput, error) {
+	args := m.Called(input)
+	err := args.Error(1)
+	if err != nil {
+		return nil, err
+	}
+	return args.Get(0).(*route53.DeleteHealthCheckOutput), err
+}
+
 func TestGetHostedZoneDomain(t *testing.T) {
 	zoneDomain := "james.com"
 	client, fake53 := createClient()
@@ -68,7 +113,91 @@ func TestGetHostedZoneDomainError(t *testing.T) {
 	assert.EqualError(t, err, "unable to get Hosted Zone Info: james says no")
 }
 
-func TestGetARecords(t *testing.T) {
+func TestIsPrivateHostedZone(t *testing.T) {
+	var tests = []struct {
+		name     string
+		config   *route53.HostedZoneConfig
+		expected bool
+	}{
+		{"no config", nil, false},
+		{"public zone", &route53.HostedZoneConfig{PrivateZone: aws.Bool(false)}, false},
+		{"private zone", &route53.HostedZoneConfig{PrivateZone: aws.Bool(true)}, true},
+	}
+
+	for _, test := range tests {
+		client, fake53 := createClient()
+		fake53.On("GetHostedZone", &route53.GetHostedZoneInput{Id: aws.String(hostedZone)}).Return(&route53.GetHostedZoneOutput{
+			HostedZone: &route53.HostedZone{
+				Name:   aws.String("james.com."),
+				Config: test.config,
+			},
+		}, nil)
+
+		private, err := client.IsPrivateHostedZone()
+
+		assert.NoError(t, err, test.name)
+		assert.Equal(t, test.expected, private, test.name)
+	}
+}
+
+func TestFindHostedZoneIDs(t *testing.T) {
+	// given
+	fake53 := new(fake53)
+	fake53.On("ListHostedZonesByName", &route53.ListHostedZonesByNameInput{
+		DNSName: aws.String("james.com."),
+	}).Return(&route53.ListHostedZonesByNameOutput{
+		HostedZones: []*route53.HostedZone{
+			{Id: aws.String("/hostedzone/public-james"), Name: aws.String("james.com.")},
+		},
+		IsTruncated:      aws.Bool(true),
+		NextDNSName:      aws.String("james.com."),
+		NextHostedZoneId: aws.String("/hostedzone/private-james"),
+	}, nil)
+	fake53.On("ListHostedZonesByName", &route53.ListHostedZonesByNameInput{
+		DNSName:      aws.String("james.com."),
+		HostedZoneId: aws.String("/hostedzone/private-james"),
+	}).Return(&route53.ListHostedZonesByNameOutput{
+		HostedZones: []*route53.HostedZone{
+			{Id: aws.String("/hostedzone/private-james"), Name: aws.String("james.com.")},
+			{Id: aws.String("/hostedzone/jamesy"), Name: aws.String("jamesy.com.")},
+		},
+		IsTruncated: aws.Bool(true),
+	}, nil)
+	fake53.On("ListHostedZonesByName", &route53.ListHostedZonesByNameInput{
+		DNSName: aws.String("sub.chris.com."),
+	}).Return(&route53.ListHostedZonesByNameOutput{
+		HostedZones: []*route53.HostedZone{
+			{Id: aws.String("/hostedzone/sub-chris"), Name: aws.String("sub.chris.com.")},
+		},
+		IsTruncated: aws.Bool(false),
+	}, nil)
+
+	// when
+	ids, err := findHostedZoneIDs(fake53, []string{"james.com", "sub.chris.com."})
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"public-james", "private-james", "sub-chris"}, ids)
+}
+
+func TestFindHostedZoneIDsFailsIfDomainHasNoZones(t *testing.T) {
+	// given
+	fake53 := new(fake53)
+	fake53.On("ListHostedZonesByName", mock.Anything).Return(&route53.ListHostedZonesByNameOutput{
+		HostedZones: []*route53.HostedZone{
+			{Id: aws.String("/hostedzone/jamesy"), Name: aws.String("jamesy.com.")},
+		},
+		IsTruncated: aws.Bool(false),
+	}, nil)
+
+	// when
+	_, err := findHostedZoneIDs(fake53, []string{"james.com"})
+
+	// then
+	assert.EqualError(t, err, "no hosted zones found for james.com.")
+}
+
+func TestGetRecords(t *testing.T) {
 	// given
 	client, fake53 := createClient()
 	expectedRecords := []*route53.ResourceRecordSet{
@@ -82,39 +211,43 @@ func TestGetARecords(t *testing.T) {
 	}).Return(&route53.ListResourceRecordSetsOutput{ResourceRecordSets: expectedRecords}, nil)
 
 	// when
-	records, err := client.GetARecords()
+	records, err := client.GetRecords()
 
 	// then
 	assert.NoError(t, err)
 	assert.Equal(t, expectedRecords, records)
 }
 
-func TestGetARecordsFiltersOutNonARecords(t *testing.T) {
+func TestGetRecordsFiltersOutNonAddressRecords(t *testing.T) {
 	// given
 	client, fake53 := createClient()
 	aRecord := &route53.ResourceRecordSet{
 		Name: aws.String("james.com"),
 		Type: aws.String("A"),
 	}
+	aaaaRecord := &route53.ResourceRecordSet{
+		Name: aws.String("james.com"),
+		Type: aws.String("AAAA"),
+	}
 	cRecord := &route53.ResourceRecordSet{
 		Name: aws.String("james2.com"),
 		Type: aws.String("C"),
 	}
-	allRecords := []*route53.ResourceRecordSet{aRecord, cRecord}
+	allRecords := []*route53.ResourceRecordSet{aRecord, aaaaRecord, cRecord}
 	fake53.On("ListResourceRecordSets", &route53.ListResourceRecordSetsInput{
 		HostedZoneId: aws.String(hostedZone),
 	}).Return(&route53.ListResourceRecordSetsOutput{ResourceRecordSets: allRecords}, nil)
 
 	// when
-	records, err := client.GetARecords()
+	records, err := client.GetRecords()
 
 	// then
-	aRecords := []*route53.ResourceRecordSet{aRecord}
+	addressRecords := []*route53.ResourceRecordSet{aRecord, aaaaRecord}
 	assert.NoError(t, err)
-	assert.Equal(t, aRecords, records)
+	assert.Equal(t, addressRecords, records)
 }
 
-func TestGetARecordPages(t *testing.T) {
+func TestGetRecordPages(t *testing.T) {
 	// given
 	client, fake53 := createClient()
 	firstRecord := &route53.ResourceRecordSet{
@@ -143,7 +276,7 @@ func TestGetARecordPages(t *testing.T) {
 	}, nil)
 
 	// when
-	records, err := client.GetARecords()
+	records, err := client.GetRecords()
 
 	// then
 	allRecords := []*route53.ResourceRecordSet{firstRecord, secondRecord}
@@ -160,7 +293,7 @@ func TestUpdateRecordSetsFull(t *testing.T) {
 	secondChange := &route53.Change{Action: aws.String("DELETE")}
 
 	// when
-	err := client.UpdateRecordSets([]*route53.Change{firstChange, secondChange})
+	_, err := client.UpdateRecordSets([]*route53.Change{firstChange, secondChange})
 
 	// then
 	assert.NoError(t, err)
@@ -184,7 +317,7 @@ func TestUpdateRecordSetsPartial(t *testing.T) {
 	thirdChange := &route53.Change{Action: aws.String("EAT")}
 
 	// when
-	err := client.UpdateRecordSets([]*route53.Change{firstChange, secondChange, thirdChange})
+	_, err := client.UpdateRecordSets([]*route53.Change{firstChange, secondChange, thirdChange})
 
 	// then
 	assert.NoError(t, err)
@@ -198,6 +331,151 @@ func TestUpdateRecordSetsPartial(t *testing.T) {
 	})
 }
 
+func TestUpdateRecordSetsReturnsChangeIDs(t *testing.T) {
+	// given
+	client, fake53 := createClient()
+	client.maxRecordChanges = 1
+	firstChange := &route53.Change{Action: aws.String("UPDATE")}
+	secondChange := &route53.Change{Action: aws.String("DELETE")}
+	fake53.On("ChangeResourceRecordSets", &route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(hostedZone),
+		ChangeBatch:  &route53.ChangeBatch{Changes: []*route53.Change{firstChange}},
+	}).Return(&route53.ChangeResourceRecordSetsOutput{ChangeInfo: &route53.ChangeInfo{Id: aws.String("change-1")}}, nil)
+	fake53.On("ChangeResourceRecordSets", &route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(hostedZone),
+		ChangeBatch:  &route53.ChangeBatch{Changes: []*route53.Change{secondChange}},
+	}).Return(&route53.ChangeResourceRecordSetsOutput{ChangeInfo: &route53.ChangeInfo{Id: aws.String("change-2")}}, nil)
+
+	// when
+	changeIDs, err := client.UpdateRecordSets([]*route53.Change{firstChange, secondChange})
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"change-1", "change-2"}, changeIDs)
+}
+
+func TestIsChangeInSync(t *testing.T) {
+	var tests = []struct {
+		status   string
+		expected bool
+	}{
+		{route53.ChangeStatusPending, false},
+		{route53.ChangeStatusInsync, true},
+	}
+
+	for _, test := range tests {
+		client, fake53 := createClient()
+		fake53.On("GetChange", &route53.GetChangeInput{Id: aws.String("change-1")}).Return(&route53.GetChangeOutput{
+			ChangeInfo: &route53.ChangeInfo{Id: aws.String("change-1"), Status: aws.String(test.status)},
+		}, nil)
+
+		inSync, err := client.IsChangeInSync("change-1")
+
+		assert.NoError(t, err)
+		assert.Equal(t, test.expected, inSync, test.status)
+	}
+}
+
+func TestIsChangeInSyncError(t *testing.T) {
+	client, fake53 := createClient()
+	fake53.On("GetChange", mock.Anything).Return(nil, errors.New("james says no"))
+
+	_, err := client.IsChangeInSync("change-1")
+
+	assert.EqualError(t, err, "unable to get status of change change-1: james says no")
+}
+
+func TestGetRecord(t *testing.T) {
+	// given
+	client, fake53 := createClient()
+	txt := func(setIdentifier string) *route53.ResourceRecordSet {
+		return &route53.ResourceRecordSet{
+			Name:          aws.String("_grace.james.com."),
+			Type:          aws.String("TXT"),
+			SetIdentifier: aws.String(setIdentifier),
+		}
+	}
+	fake53.On("ListResourceRecordSets", &route53.ListResourceRecordSetsInput{
+		HostedZoneId:    aws.String(hostedZone),
+		StartRecordName: aws.String("_grace.james.com."),
+		StartRecordType: aws.String("TXT"),
+	}).Return(&route53.ListResourceRecordSetsOutput{
+		ResourceRecordSets:   []*route53.ResourceRecordSet{txt("cluster-a")},
+		IsTruncated:          aws.Bool(true),
+		NextRecordName:       aws.String("_grace.james.com."),
+		NextRecordType:       aws.String("TXT"),
+		NextRecordIdentifier: aws.String("cluster-b"),
+	}, nil)
+	fake53.On("ListResourceRecordSets", &route53.ListResourceRecordSetsInput{
+		HostedZoneId:          aws.String(hostedZone),
+		StartRecordName:       aws.String("_grace.james.com."),
+		StartRecordType:       aws.String("TXT"),
+		StartRecordIdentifier: aws.String("cluster-b"),
+	}).Return(&route53.ListResourceRecordSetsOutput{
+		ResourceRecordSets: []*route53.ResourceRecordSet{
+			txt("cluster-b"),
+			{Name: aws.String("zzz.james.com."), Type: aws.String("A")},
+		},
+		IsTruncated: aws.Bool(false),
+	}, nil)
+
+	// when
+	clusterB, errB := client.GetRecord("_grace.james.com.", "TXT", "cluster-b")
+	clusterC, errC := client.GetRecord("_grace.james.com.", "TXT", "cluster-c")
+
+	// then
+	assert.NoError(t, errB)
+	assert.Equal(t, txt("cluster-b"), clusterB)
+	assert.NoError(t, errC)
+	assert.Nil(t, clusterC)
+}
+
+func TestGetHealthChecksFiltersByCallerReference(t *testing.T) {
+	// given
+	client, fake53 := createClient()
+	ours1 := &route53.HealthCheck{Id: aws.String("1"), CallerReference: aws.String("feed-abc-1")}
+	ours2 := &route53.HealthCheck{Id: aws.String("2"), CallerReference: aws.String("feed-abc-2")}
+	theirs := &route53.HealthCheck{Id: aws.String("3"), CallerReference: aws.String("someone-else")}
+	fake53.On("ListHealthChecks", &route53.ListHealthChecksInput{}).Return(&route53.ListHealthChecksOutput{
+		HealthChecks: []*route53.HealthCheck{ours1, theirs},
+		IsTruncated:  aws.Bool(true),
+		NextMarker:   aws.String("next"),
+	}, nil)
+	fake53.On("ListHealthChecks", &route53.ListHealthChecksInput{Marker: aws.String("next")}).Return(
+		&route53.ListHealthChecksOutput{
+			HealthChecks: []*route53.HealthCheck{ours2},
+			IsTruncated:  aws.Bool(false),
+		}, nil)
+
+	// when
+	healthChecks, err := client.GetHealthChecks("feed-abc-")
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, []*route53.HealthCheck{ours1, ours2}, healthChecks)
+}
+
+func TestCreateAndDeleteHealthCheck(t *testing.T) {
+	// given
+	client, fake53 := createClient()
+	config := &route53.HealthCheckConfig{Type: aws.String(route53.HealthCheckTypeHttp)}
+	fake53.On("CreateHealthCheck", &route53.CreateHealthCheckInput{
+		CallerReference:   aws.String("feed-abc-1"),
+		HealthCheckConfig: config,
+	}).Return(&route53.CreateHealthCheckOutput{HealthCheck: &route53.HealthCheck{Id: aws.String("check-1")}}, nil)
+	fake53.On("DeleteHealthCheck", &route53.DeleteHealthCheckInput{HealthCheckId: aws.String("check-1")}).Return(
+		nil, errors.New("in use"))
+
+	// when
+	id, err := client.CreateHealthCheck("feed-abc-1", config)
+	deleteErr := client.DeleteHealthCheck(id)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, "check-1", id)
+	assert.EqualError(t, deleteErr, "failed to delete health check check-1: in use")
+}
+
 func createClient() (*client, *fake53) {
 	client := New("fake", hostedZone).(*client)
 	fake53 := new(fake53)
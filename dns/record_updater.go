@@ -0,0 +1,337 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/sky-uk/feed/controller"
+	"github.com/sky-uk/feed/dns/provider"
+)
+
+const (
+	typeA     = "A"
+	typeAAAA  = "AAAA"
+	typeCNAME = "CNAME"
+	typeTXT   = "TXT"
+
+	// servicesRecordPrefix names the TXT record listing the service hosts feed-dns manages in a zone. Service
+	// records point at load balancers rather than the frontends, so can't otherwise be told apart from records
+	// created by someone else.
+	servicesRecordPrefix = "_feed-dns-services."
+)
+
+// recordUpdater keeps plain A, AAAA, and CNAME records for the ingress hosts, pointing at the frontend
+// addresses, for providers where there are no ELBs to alias. Hosts of services of type LoadBalancer point at the
+// service's own load balancer instead.
+type recordUpdater struct {
+	provider       provider.Provider
+	frontends      map[string][]string
+	ttl            uint32
+	maxDeletionPct int
+}
+
+func newRecordUpdater(p provider.Provider, conf Config) *recordUpdater {
+	return &recordUpdater{
+		provider:       p,
+		frontends:      conf.FrontendAddresses,
+		ttl:            uint32(conf.TTL),
+		maxDeletionPct: conf.MaxDeletionPercent,
+	}
+}
+
+func (u *recordUpdater) Start() error {
+	log.Infof("Starting dns updater for %s", u.provider.Domain())
+	for scheme, addresses := range u.frontends {
+		if err := validateFrontendAddresses(addresses); err != nil {
+			return fmt.Errorf("invalid frontend addresses for scheme %s: %v", scheme, err)
+		}
+	}
+	if _, err := u.provider.GetRecords(); err != nil {
+		return fmt.Errorf("unable to get records for %s: %v", u.provider.Domain(), err)
+	}
+	log.Info("Dns updater started")
+	return nil
+}
+
+func (u *recordUpdater) Stop() error {
+	return nil
+}
+
+func (u *recordUpdater) Health() error {
+	return nil
+}
+
+func (u *recordUpdater) Update(update controller.IngressUpdate) error {
+	domain := u.provider.Domain()
+	existing, err := u.provider.GetRecords()
+	if err != nil {
+		log.Warnf("Unable to get records for %s. Not updating DNS. %v", domain, err)
+		return err
+	}
+
+	wanted, err := u.wantedRecords(update, domain)
+	if err != nil {
+		return err
+	}
+	serviceRecords, serviceHosts := u.serviceRecords(update, domain, wanted)
+	wanted = append(wanted, serviceRecords...)
+
+	wantedKeys := make(map[recordKey]bool)
+	for _, record := range wanted {
+		wantedKeys[recordKeyOf(record)] = true
+	}
+
+	previousHosts, servicesRecord := previousServiceHosts(existing, domain)
+	owned := u.ownedRecords(existing, previousHosts)
+	var deletions []provider.Record
+	for _, record := range owned {
+		if !wantedKeys[recordKeyOf(record)] {
+			deletions = append(deletions, record)
+		}
+	}
+
+	var deletionErr error
+	if isMassDeletion(len(deletions), len(owned), u.maxDeletionPct) {
+		deletionErr = fmt.Errorf("refusing to delete %d of %d records in %s, as it exceeds %d%%",
+			len(deletions), len(owned), domain, u.maxDeletionPct)
+		log.Error(deletionErr)
+		// keep owning the service records that weren't deleted
+		for host := range previousHosts {
+			serviceHosts[host] = true
+		}
+		deletions = nil
+	}
+
+	if len(serviceHosts) > 0 {
+		wanted = append(wanted, servicesHostsRecord(domain, serviceHosts, u.ttl))
+	} else if servicesRecord != nil {
+		deletions = append(deletions, *servicesRecord)
+	}
+
+	existingByKey := make(map[recordKey]provider.Record)
+	for _, record := range existing {
+		existingByKey[recordKeyOf(record)] = record
+	}
+
+	var remove, add []provider.Record
+	for _, record := range wanted {
+		current, exists := existingByKey[recordKeyOf(record)]
+		if exists && current.Equal(record) {
+			continue
+		}
+		if exists {
+			remove = append(remove, current)
+		}
+		add = append(add, record)
+	}
+	remove = append(remove, deletions...)
+
+	if len(remove) == 0 && len(add) == 0 {
+		log.Debugf("Records for %s are up to date", domain)
+		return deletionErr
+	}
+
+	if err := u.provider.UpdateRecords(remove, add); err != nil {
+		return fmt.Errorf("unable to update records for %s: %v", domain, err)
+	}
+	return deletionErr
+}
+
+// wantedRecords returns the records pointing each valid host at the frontend for its scheme.
+func (u *recordUpdater) wantedRecords(update controller.IngressUpdate, domain string) ([]provider.Record, error) {
+	var wanted []provider.Record
+	processed := make(map[string]bool)
+	for _, entry := range dnsEntries(update) {
+		host, valid := hostInDomain(entry.Host, domain)
+		if !valid {
+			continue
+		}
+
+		// Multiple paths for the same host only need a single record
+		host = strings.ToLower(host)
+		if processed[host] {
+			continue
+		}
+		processed[host] = true
+
+		addresses, exists := u.frontends[entry.ELbScheme]
+		if !exists {
+			return nil, fmt.Errorf("no frontend addresses for scheme: %v", entry.ELbScheme)
+		}
+
+		ttl := u.ttl
+		if entry.DNSTTL > 0 {
+			ttl = uint32(entry.DNSTTL)
+		}
+		wanted = append(wanted, frontendRecords(host, addresses, ttl)...)
+	}
+	return wanted, nil
+}
+
+// serviceRecords returns the records pointing each host of the services at the service's load balancer, and
+// the hosts they are for. Hosts that already have a record for an ingress are skipped.
+func (u *recordUpdater) serviceRecords(update controller.IngressUpdate, domain string,
+	ingressRecords []provider.Record) ([]provider.Record, map[string]bool) {
+
+	processed := make(map[string]bool)
+	for _, record := range ingressRecords {
+		processed[strings.ToLower(record.Name)] = true
+	}
+
+	var records []provider.Record
+	hosts := make(map[string]bool)
+	for _, service := range update.Services {
+		addresses := loadBalancerAddresses(service)
+		if len(addresses) == 0 {
+			log.Warnf("Service %s has no load balancer address to point at", service.Name)
+			continue
+		}
+		for _, serviceHost := range service.Hosts {
+			host, valid := hostInDomain(serviceHost, domain)
+			if !valid {
+				continue
+			}
+			host = strings.ToLower(host)
+			if processed[host] {
+				log.Warnf("Not adding a record for service %s, as %s already has one", service.Name, host)
+				continue
+			}
+			processed[host] = true
+			hosts[host] = true
+			records = append(records, frontendRecords(host, addresses, u.ttl)...)
+		}
+	}
+	return records, hosts
+}
+
+// loadBalancerAddresses returns the first hostname of the service's load balancer, or else all of its IPs.
+func loadBalancerAddresses(service controller.ServiceEntry) []string {
+	var ips []string
+	for _, address := range service.LoadBalancerAddresses {
+		if net.ParseIP(address) == nil {
+			return []string{address}
+		}
+		ips = append(ips, address)
+	}
+	return ips
+}
+
+// previousServiceHosts returns the service hosts listed in the zone's TXT record by the last update, and the
+// record itself if it exists.
+func previousServiceHosts(records []provider.Record, domain string) (map[string]bool, *provider.Record) {
+	hosts := make(map[string]bool)
+	name := strings.ToLower(servicesRecordPrefix + domain)
+	for i, record := range records {
+		if record.Type == typeTXT && strings.ToLower(record.Name) == name {
+			for _, host := range record.Values {
+				hosts[strings.ToLower(host)] = true
+			}
+			return hosts, &records[i]
+		}
+	}
+	return hosts, nil
+}
+
+func servicesHostsRecord(domain string, hosts map[string]bool, ttl uint32) provider.Record {
+	var values []string
+	for host := range hosts {
+		values = append(values, host)
+	}
+	sort.Strings(values)
+	return provider.Record{Name: servicesRecordPrefix + domain, Type: typeTXT, TTL: ttl, Values: values}
+}
+
+// ownedRecords returns the records that point at the frontends, or are for service hosts feed-dns previously
+// added, so were created by feed-dns. Others in the zone are left alone, unless they are replaced by a record
+// for an ingress or service host.
+func (u *recordUpdater) ownedRecords(records []provider.Record, serviceHosts map[string]bool) []provider.Record {
+	frontendValues := make(map[string]bool)
+	for _, addresses := range u.frontends {
+		for _, address := range addresses {
+			frontendValues[normaliseAddress(address)] = true
+		}
+	}
+
+	var owned []provider.Record
+	for _, record := range records {
+		if record.Type != typeA && record.Type != typeAAAA && record.Type != typeCNAME {
+			continue
+		}
+		if serviceHosts[strings.ToLower(record.Name)] {
+			owned = append(owned, record)
+			continue
+		}
+		pointsAtFrontend := len(record.Values) > 0
+		for _, value := range record.Values {
+			if !frontendValues[normaliseAddress(value)] {
+				pointsAtFrontend = false
+			}
+		}
+		if pointsAtFrontend {
+			owned = append(owned, record)
+		}
+	}
+	return owned
+}
+
+// frontendRecords returns A and AAAA records for the IP addresses, or a CNAME record for a hostname.
+func frontendRecords(host string, addresses []string, ttl uint32) []provider.Record {
+	if len(addresses) == 1 && net.ParseIP(addresses[0]) == nil {
+		return []provider.Record{{Name: host, Type: typeCNAME, TTL: ttl, Values: []string{fqdn(addresses[0])}}}
+	}
+
+	var records []provider.Record
+	ipv4 := provider.Record{Name: host, Type: typeA, TTL: ttl}
+	ipv6 := provider.Record{Name: host, Type: typeAAAA, TTL: ttl}
+	for _, address := range addresses {
+		if net.ParseIP(address).To4() != nil {
+			ipv4.Values = append(ipv4.Values, address)
+		} else {
+			ipv6.Values = append(ipv6.Values, address)
+		}
+	}
+	for _, record := range []provider.Record{ipv4, ipv6} {
+		if len(record.Values) > 0 {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// validateFrontendAddresses checks the addresses are either IPs, or a single hostname.
+func validateFrontendAddresses(addresses []string) error {
+	if len(addresses) == 0 {
+		return fmt.Errorf("no addresses")
+	}
+	if len(addresses) == 1 {
+		return nil
+	}
+	for _, address := range addresses {
+		if net.ParseIP(address) == nil {
+			return fmt.Errorf("%s is not an IP address, and only a single hostname is allowed", address)
+		}
+	}
+	return nil
+}
+
+func recordKeyOf(record provider.Record) recordKey {
+	return recordKey{name: strings.ToLower(record.Name), recordType: record.Type}
+}
+
+// normaliseAddress makes IP addresses and hostnames comparable.
+func normaliseAddress(address string) string {
+	if ip := net.ParseIP(address); ip != nil {
+		return ip.String()
+	}
+	return normaliseDNSName(address)
+}
+
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
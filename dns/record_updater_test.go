@@ -0,0 +1,316 @@
+package dns
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sky-uk/feed/controller"
+	"github.com/sky-uk/feed/dns/provider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+const (
+	frontendIP       = "10.0.0.1"
+	frontendHostname = "ingress.james.com"
+)
+
+type fakeProvider struct {
+	mock.Mock
+}
+
+func (m *fakeProvider) Domain() string {
+	return domain
+}
+
+func (m *fakeProvider) GetRecords() ([]provider.Record, error) {
+	args := m.Called()
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]provider.Record), args.Error(1)
+}
+
+func (m *fakeProvider) UpdateRecords(remove, add []provider.Record) error {
+	args := m.Called(remove, add)
+	return args.Error(0)
+}
+
+func newRecordUpdaterWithFake(records ...provider.Record) (*recordUpdater, *fakeProvider) {
+	fake := new(fakeProvider)
+	fake.On("GetRecords").Return(records, nil)
+	return newRecordUpdater(fake, Config{
+		FrontendAddresses: map[string][]string{
+			"internal":        {frontendIP, "2001:db8::1"},
+			"internet-facing": {frontendHostname},
+		},
+		TTL:                60,
+		MaxDeletionPercent: 50,
+	}), fake
+}
+
+func aRecord(host string, ips ...string) provider.Record {
+	return provider.Record{Name: host, Type: "A", TTL: 60, Values: ips}
+}
+
+func TestNewSelectsProvider(t *testing.T) {
+	assert.IsType(t, &updater{}, New(Config{}))
+	assert.IsType(t, &updater{}, New(Config{Provider: Route53Provider}))
+	assert.IsType(t, &recordUpdater{}, New(Config{Provider: RFC2136Provider}))
+	assert.IsType(t, &recordUpdater{}, New(Config{Provider: ZoneFileProvider}))
+}
+
+func TestRecordUpdaterStartChecksProvider(t *testing.T) {
+	fake := new(fakeProvider)
+	fake.On("GetRecords").Return(nil, errors.New("no transfer for you"))
+	dnsUpdater := newRecordUpdater(fake, Config{FrontendAddresses: map[string][]string{"internal": {frontendIP}}})
+
+	assert.EqualError(t, dnsUpdater.Start(), "unable to get records for james.com.: no transfer for you")
+}
+
+func TestRecordUpdaterStartValidatesFrontends(t *testing.T) {
+	dnsUpdater, _ := newRecordUpdaterWithFake()
+	dnsUpdater.frontends["internal"] = []string{frontendIP, frontendHostname}
+
+	assert.EqualError(t, dnsUpdater.Start(), "invalid frontend addresses for scheme internal: "+
+		"ingress.james.com is not an IP address, and only a single hostname is allowed")
+}
+
+func TestRecordUpdaterAddsRecordsForFrontends(t *testing.T) {
+	// given
+	dnsUpdater, fake := newRecordUpdaterWithFake()
+	update := controller.IngressUpdate{Entries: []controller.IngressEntry{
+		{Host: "foo.james.com", ELbScheme: "internal", Path: "/a"},
+		{Host: "foo.james.com", ELbScheme: "internal", Path: "/b"},
+		{Host: "Bar.james.com", ELbScheme: "internet-facing"},
+		{Host: "foo.notjames.com", ELbScheme: "internal"},
+	}}
+	fake.On("UpdateRecords", []provider.Record(nil), []provider.Record{
+		aRecord("foo.james.com.", frontendIP),
+		{Name: "foo.james.com.", Type: "AAAA", TTL: 60, Values: []string{"2001:db8::1"}},
+		{Name: "bar.james.com.", Type: "CNAME", TTL: 60, Values: []string{"ingress.james.com."}},
+	}).Return(nil)
+
+	// when
+	assert.NoError(t, dnsUpdater.Start())
+	err := dnsUpdater.Update(update)
+
+	// then
+	assert.NoError(t, err)
+	fake.AssertExpectations(t)
+}
+
+func TestRecordUpdaterSkipsUnchangedRecords(t *testing.T) {
+	// given
+	dnsUpdater, fake := newRecordUpdaterWithFake(
+		provider.Record{Name: "bar.james.com.", Type: "CNAME", TTL: 60, Values: []string{"Ingress.james.com."}})
+	update := controller.IngressUpdate{Entries: []controller.IngressEntry{
+		{Host: "bar.james.com", ELbScheme: "internet-facing"},
+	}}
+
+	// when
+	err := dnsUpdater.Update(update)
+
+	// then
+	assert.NoError(t, err)
+	fake.AssertNotCalled(t, "UpdateRecords", mock.Anything, mock.Anything)
+}
+
+func TestRecordUpdaterReplacesChangedAndRemovesStaleRecords(t *testing.T) {
+	// given
+	changed := aRecord("foo.james.com.", "10.0.0.99")
+	stale := aRecord("old.james.com.", frontendIP)
+	unowned := aRecord("other.james.com.", "10.0.0.99")
+	kept := aRecord("keep.james.com.", frontendIP)
+	keptAAAA := provider.Record{Name: "keep.james.com.", Type: "AAAA", TTL: 60, Values: []string{"2001:db8::1"}}
+	dnsUpdater, fake := newRecordUpdaterWithFake(changed, stale, unowned, kept, keptAAAA)
+	update := controller.IngressUpdate{Entries: []controller.IngressEntry{
+		{Host: "foo.james.com", ELbScheme: "internet-facing"},
+		{Host: "keep.james.com", ELbScheme: "internal"},
+	}}
+	fake.On("UpdateRecords",
+		[]provider.Record{stale},
+		[]provider.Record{{Name: "foo.james.com.", Type: "CNAME", TTL: 60, Values: []string{"ingress.james.com."}}},
+	).Return(nil)
+
+	// when
+	err := dnsUpdater.Update(update)
+
+	// then
+	assert.NoError(t, err)
+	fake.AssertExpectations(t)
+}
+
+func TestRecordUpdaterRefusesMassDeletion(t *testing.T) {
+	// given
+	dnsUpdater, fake := newRecordUpdaterWithFake(
+		aRecord("foo.james.com.", frontendIP), aRecord("bar.james.com.", frontendIP),
+		aRecord("baz.james.com.", frontendIP), aRecord("qux.james.com.", frontendIP),
+		aRecord("quux.james.com.", frontendIP), aRecord("corge.james.com.", frontendIP))
+	fake.On("UpdateRecords", []provider.Record(nil), []provider.Record{
+		{Name: "foo.james.com.", Type: "AAAA", TTL: 60, Values: []string{"2001:db8::1"}},
+	}).Return(nil)
+	update := controller.IngressUpdate{Entries: []controller.IngressEntry{
+		{Host: "foo.james.com", ELbScheme: "internal"},
+	}}
+	dnsUpdater.maxDeletionPct = 20
+
+	// when
+	err := dnsUpdater.Update(update)
+
+	// then
+	assert.EqualError(t, err, "refusing to delete 5 of 6 records in james.com., as it exceeds 20%")
+	fake.AssertExpectations(t)
+}
+
+func TestRecordUpdaterDeletesTheOnlyRecordOfASmallZone(t *testing.T) {
+	// given
+	stale := aRecord("foo.james.com.", frontendIP)
+	dnsUpdater, fake := newRecordUpdaterWithFake(stale)
+	fake.On("UpdateRecords", []provider.Record{stale}, []provider.Record(nil)).Return(nil)
+
+	// when
+	err := dnsUpdater.Update(controller.IngressUpdate{})
+
+	// then
+	assert.NoError(t, err)
+	fake.AssertExpectations(t)
+}
+
+func TestRecordUpdaterErrorsForUnknownScheme(t *testing.T) {
+	dnsUpdater, fake := newRecordUpdaterWithFake()
+	dnsUpdater.frontends = map[string][]string{"internet-facing": {frontendHostname}}
+
+	err := dnsUpdater.Update(controller.IngressUpdate{Entries: []controller.IngressEntry{
+		{Host: "foo.james.com", ELbScheme: "internal"},
+	}})
+
+	assert.EqualError(t, err, "no frontend addresses for scheme: internal")
+	fake.AssertNotCalled(t, "UpdateRecords", mock.Anything, mock.Anything)
+}
+
+func TestRecordUpdaterUpdateFails(t *testing.T) {
+	dnsUpdater, fake := newRecordUpdaterWithFake()
+	fake.On("UpdateRecords", mock.Anything, mock.Anything).Return(errors.New("REFUSED"))
+
+	err := dnsUpdater.Update(controller.IngressUpdate{Entries: []controller.IngressEntry{
+		{Host: "foo.james.com", ELbScheme: "internal"},
+	}})
+
+	assert.EqualError(t, err, "unable to update records for james.com.: REFUSED")
+}
+
+func TestRecordUpdaterHonoursDNSAnnotations(t *testing.T) {
+	// given
+	dnsUpdater, fake := newRecordUpdaterWithFake()
+	update := controller.IngressUpdate{Entries: []controller.IngressEntry{
+		{Host: "foo.james.com", ELbScheme: "internet-facing", DNSTTL: 30, DNSAliases: []string{"www.james.com"}},
+		{Host: "bar.james.com", ELbScheme: "internet-facing", DNSIgnore: true},
+	}}
+	fake.On("UpdateRecords", []provider.Record(nil), []provider.Record{
+		{Name: "foo.james.com.", Type: "CNAME", TTL: 30, Values: []string{"ingress.james.com."}},
+		{Name: "www.james.com.", Type: "CNAME", TTL: 30, Values: []string{"ingress.james.com."}},
+	}).Return(nil)
+
+	// when
+	err := dnsUpdater.Update(update)
+
+	// then
+	assert.NoError(t, err)
+	fake.AssertExpectations(t)
+}
+
+func servicesRecord(hosts ...string) provider.Record {
+	return provider.Record{Name: "_feed-dns-services.james.com.", Type: "TXT", TTL: 60, Values: hosts}
+}
+
+func TestRecordUpdaterAddsRecordsForServices(t *testing.T) {
+	// given
+	dnsUpdater, fake := newRecordUpdaterWithFake()
+	update := controller.IngressUpdate{
+		Entries: []controller.IngressEntry{{Host: "foo.james.com", ELbScheme: "internal"}},
+		Services: []controller.ServiceEntry{
+			{
+				Name:                  "default/lb",
+				Hosts:                 []string{"Lb.james.com", "lb.notjames.com", "foo.james.com"},
+				LoadBalancerAddresses: []string{"10.1.0.1", "lb-123.eu-west-1.elb.amazonaws.com"},
+			},
+			{Name: "default/ips", Hosts: []string{"ips.james.com"}, LoadBalancerAddresses: []string{"10.2.0.1"}},
+			{Name: "default/pending", Hosts: []string{"pending.james.com"}},
+		},
+	}
+	fake.On("UpdateRecords", []provider.Record(nil), []provider.Record{
+		aRecord("foo.james.com.", frontendIP),
+		{Name: "foo.james.com.", Type: "AAAA", TTL: 60, Values: []string{"2001:db8::1"}},
+		{Name: "lb.james.com.", Type: "CNAME", TTL: 60, Values: []string{"lb-123.eu-west-1.elb.amazonaws.com."}},
+		aRecord("ips.james.com.", "10.2.0.1"),
+		servicesRecord("ips.james.com.", "lb.james.com."),
+	}).Return(nil)
+
+	// when
+	err := dnsUpdater.Update(update)
+
+	// then
+	assert.NoError(t, err)
+	fake.AssertExpectations(t)
+}
+
+func TestRecordUpdaterRemovesRecordsOfDeletedServices(t *testing.T) {
+	// given
+	stale := provider.Record{Name: "lb.james.com.", Type: "CNAME", TTL: 60, Values: []string{"lb.example.com."}}
+	kept := aRecord("ips.james.com.", "10.2.0.1")
+	unowned := provider.Record{Name: "other.james.com.", Type: "CNAME", TTL: 60, Values: []string{"lb.example.com."}}
+	dnsUpdater, fake := newRecordUpdaterWithFake(stale, kept, unowned, servicesRecord("ips.james.com.", "lb.james.com."))
+	update := controller.IngressUpdate{Services: []controller.ServiceEntry{
+		{Name: "default/ips", Hosts: []string{"ips.james.com"}, LoadBalancerAddresses: []string{"10.2.0.1"}},
+	}}
+	fake.On("UpdateRecords",
+		[]provider.Record{servicesRecord("ips.james.com.", "lb.james.com."), stale},
+		[]provider.Record{servicesRecord("ips.james.com.")},
+	).Return(nil)
+
+	// when
+	err := dnsUpdater.Update(update)
+
+	// then
+	assert.NoError(t, err)
+	fake.AssertExpectations(t)
+}
+
+func TestRecordUpdaterKeepsOwningServiceRecordsWhenRefusingMassDeletion(t *testing.T) {
+	// given
+	var records []provider.Record
+	var hosts []string
+	for _, host := range []string{"a.james.com.", "b.james.com.", "c.james.com.", "d.james.com.", "e.james.com."} {
+		records = append(records, aRecord(host, "10.2.0.1"))
+		hosts = append(hosts, host)
+	}
+	dnsUpdater, fake := newRecordUpdaterWithFake(append(records, servicesRecord(hosts...))...)
+	update := controller.IngressUpdate{Services: []controller.ServiceEntry{
+		{Name: "default/new", Hosts: []string{"new.james.com"}, LoadBalancerAddresses: []string{"10.2.0.1"}},
+	}}
+	fake.On("UpdateRecords",
+		[]provider.Record{servicesRecord(hosts...)},
+		[]provider.Record{aRecord("new.james.com.", "10.2.0.1"), servicesRecord(append(hosts, "new.james.com.")...)},
+	).Return(nil)
+
+	// when
+	err := dnsUpdater.Update(update)
+
+	// then
+	assert.EqualError(t, err, "refusing to delete 5 of 5 records in james.com., as it exceeds 50%")
+	fake.AssertExpectations(t)
+}
+
+func TestRecordUpdaterRemovesServicesRecordWhenThereAreNoServices(t *testing.T) {
+	// given
+	dnsUpdater, fake := newRecordUpdaterWithFake(servicesRecord())
+	fake.On("UpdateRecords", []provider.Record{servicesRecord()}, []provider.Record(nil)).Return(nil)
+
+	// when
+	err := dnsUpdater.Update(controller.IngressUpdate{})
+
+	// then
+	assert.NoError(t, err)
+	fake.AssertExpectations(t)
+}
@@ -0,0 +1,314 @@
+/*
+Package rfc2136 manages records on DNS servers such as BIND and PowerDNS, using dynamic updates as described
+in RFC2136. Records are read with a zone transfer. Both are sent over TCP and signed with TSIG when a key is
+configured.
+*/
+package rfc2136
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/sky-uk/feed/dns/provider"
+)
+
+const defaultTimeout = 10 * time.Second
+
+// Conf for connecting to a DNS server.
+type Conf struct {
+	// Server is the host:port of the primary DNS server for the zone.
+	Server string
+	// Zone is the domain of the zone to update.
+	Zone string
+	// TSIGKeyName is the name of the key used to sign messages. Leave empty to send unsigned messages.
+	TSIGKeyName string
+	// TSIGSecret is the base64 encoded secret of the key.
+	TSIGSecret string
+	// TSIGAlgorithm is the algorithm used to sign messages, such as hmac-sha256.
+	TSIGAlgorithm string
+	// Timeout for each exchange with the server. Defaults to 10 seconds.
+	Timeout time.Duration
+}
+
+type client struct {
+	server  string
+	zone    string
+	key     *tsigKey
+	keyErr  error
+	timeout time.Duration
+	now     func() time.Time
+	newID   func() uint16
+}
+
+// New creates a provider that updates a zone on a DNS server.
+func New(conf Conf) provider.Provider {
+	c := &client{
+		server:  conf.Server,
+		zone:    fqdn(strings.ToLower(conf.Zone)),
+		timeout: conf.Timeout,
+		now:     time.Now,
+		newID:   newID,
+	}
+	if c.timeout == 0 {
+		c.timeout = defaultTimeout
+	}
+	if conf.TSIGKeyName != "" {
+		c.key, c.keyErr = newTSIGKey(conf.TSIGKeyName, conf.TSIGAlgorithm, conf.TSIGSecret)
+	}
+	return c
+}
+
+// Domain returns the zone being updated.
+func (c *client) Domain() string {
+	return c.zone
+}
+
+// GetRecords transfers the zone from the server and returns its A, AAAA, CNAME, and TXT record sets.
+func (c *client) GetRecords() ([]provider.Record, error) {
+	query := &message{
+		id:       c.newID(),
+		flags:    opcodeQuery << 11,
+		question: []question{{name: c.zone, qtype: typeAXFR, qclass: classINET}},
+	}
+
+	conn, requestMAC, err := c.send(query)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var rrs []rr
+	var soas int
+	var unsigned []byte
+	priorMAC := requestMAC
+	for first := true; soas < 2; first = false {
+		m, err := c.receive(conn, query.id)
+		if err != nil {
+			return nil, fmt.Errorf("zone transfer of %s failed: %v", c.zone, err)
+		}
+
+		// later messages may be unsigned, in which case they're covered by the next signature
+		if c.key != nil {
+			unsigned = append(unsigned, m.unsigned...)
+			if m.tsig == nil && first {
+				return nil, fmt.Errorf("zone transfer of %s failed: response is not signed", c.zone)
+			}
+			if m.tsig != nil {
+				if err := c.key.verify(unsigned, priorMAC, !first, m.tsig, c.now()); err != nil {
+					return nil, fmt.Errorf("zone transfer of %s failed: %v", c.zone, err)
+				}
+				priorMAC = m.tsig.mac
+				unsigned = nil
+			}
+		}
+
+		for _, r := range m.answer {
+			if r.rtype == typeSOA {
+				soas++
+				continue
+			}
+			rrs = append(rrs, r)
+		}
+		if first && soas == 0 {
+			return nil, fmt.Errorf("zone transfer of %s failed: response does not start with SOA", c.zone)
+		}
+	}
+
+	if c.key != nil && unsigned != nil {
+		return nil, fmt.Errorf("zone transfer of %s failed: final message is not signed", c.zone)
+	}
+
+	return toRecords(rrs)
+}
+
+// UpdateRecords sends a single update that deletes the removed record sets, then adds the added record
+// sets, so the change is applied atomically by the server.
+func (c *client) UpdateRecords(remove, add []provider.Record) error {
+	update := &message{
+		id:       c.newID(),
+		flags:    opcodeUpdate << 11,
+		question: []question{{name: c.zone, qtype: typeSOA, qclass: classINET}},
+	}
+
+	for _, record := range remove {
+		rtype, err := recordType(record)
+		if err != nil {
+			return err
+		}
+		update.authority = append(update.authority, rr{name: record.Name, rtype: rtype, class: classANY})
+	}
+	for _, record := range add {
+		rtype, err := recordType(record)
+		if err != nil {
+			return err
+		}
+		for _, value := range record.Values {
+			rdata, err := encodeRData(rtype, value)
+			if err != nil {
+				return fmt.Errorf("invalid record %v: %v", record, err)
+			}
+			update.authority = append(update.authority,
+				rr{name: record.Name, rtype: rtype, class: classINET, ttl: record.TTL, rdata: rdata})
+		}
+	}
+
+	log.Infof("Sending update of %s to %s, removing %v and adding %v", c.zone, c.server, remove, add)
+	conn, requestMAC, err := c.send(update)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	response, err := c.receive(conn, update.id)
+	if err != nil {
+		return fmt.Errorf("update of %s failed: %v", c.zone, err)
+	}
+	if c.key != nil {
+		if response.tsig == nil {
+			return fmt.Errorf("update of %s failed: response is not signed", c.zone)
+		}
+		if err := c.key.verify(response.unsigned, requestMAC, false, response.tsig, c.now()); err != nil {
+			return fmt.Errorf("update of %s failed: %v", c.zone, err)
+		}
+	}
+	return nil
+}
+
+// send connects to the server and sends the signed request, returning the connection and the request's MAC.
+func (c *client) send(request *message) (net.Conn, []byte, error) {
+	if c.keyErr != nil {
+		return nil, nil, c.keyErr
+	}
+
+	msg, err := request.pack()
+	if err != nil {
+		return nil, nil, err
+	}
+	var mac []byte
+	if c.key != nil {
+		if msg, mac, err = c.key.sign(msg, nil, false, c.now()); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	conn, err := net.DialTimeout("tcp", c.server, c.timeout)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to connect to %s: %v", c.server, err)
+	}
+	conn.SetDeadline(time.Now().Add(c.timeout))
+
+	if err := writeMessage(conn, msg); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("unable to send to %s: %v", c.server, err)
+	}
+	return conn, mac, nil
+}
+
+// receive reads a response to the request, and checks it succeeded.
+func (c *client) receive(conn net.Conn, id uint16) (*message, error) {
+	buf, err := readMessage(conn)
+	if err != nil {
+		return nil, err
+	}
+	m, err := unpack(buf)
+	if err != nil {
+		return nil, err
+	}
+	if m.id != id || m.flags&flagResponse == 0 {
+		return nil, fmt.Errorf("unexpected message with id %d", m.id)
+	}
+	if m.rcode() != 0 {
+		return nil, fmt.Errorf("server responded with %s", rcodeName(m.rcode()))
+	}
+	if m.tsig == nil {
+		m.unsigned = buf
+	}
+	return m, nil
+}
+
+func writeMessage(w io.Writer, msg []byte) error {
+	_, err := w.Write(append(appendUint16(nil, uint16(len(msg))), msg...))
+	return err
+}
+
+func readMessage(r io.Reader) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// toRecords groups resource records into record sets, ignoring types that aren't managed.
+func toRecords(rrs []rr) ([]provider.Record, error) {
+	sets := make(map[recordKey]*provider.Record)
+	var keys recordKeys
+
+	for _, r := range rrs {
+		var typeName string
+		for name, rtype := range recordTypes {
+			if rtype == r.rtype {
+				typeName = name
+			}
+		}
+		if typeName == "" || r.class != classINET {
+			continue
+		}
+		value, err := decodeRData(r.rtype, r.rdata)
+		if err != nil {
+			return nil, fmt.Errorf("invalid record for %s: %v", r.name, err)
+		}
+
+		k := recordKey{strings.ToLower(r.name), typeName}
+		set, ok := sets[k]
+		if !ok {
+			set = &provider.Record{Name: k.name, Type: typeName, TTL: r.ttl}
+			sets[k] = set
+			keys = append(keys, k)
+		}
+		set.Values = append(set.Values, value)
+	}
+
+	sort.Sort(keys)
+	var records []provider.Record
+	for _, k := range keys {
+		records = append(records, *sets[k])
+	}
+	return records, nil
+}
+
+type recordKey struct {
+	name  string
+	rtype string
+}
+
+type recordKeys []recordKey
+
+func (k recordKeys) Len() int      { return len(k) }
+func (k recordKeys) Swap(i, j int) { k[i], k[j] = k[j], k[i] }
+func (k recordKeys) Less(i, j int) bool {
+	return k[i].name < k[j].name || (k[i].name == k[j].name && k[i].rtype < k[j].rtype)
+}
+
+func recordType(record provider.Record) (uint16, error) {
+	rtype, ok := recordTypes[record.Type]
+	if !ok {
+		return 0, fmt.Errorf("unsupported record type %s for %s", record.Type, record.Name)
+	}
+	return rtype, nil
+}
+
+func newID() uint16 {
+	return uint16(rand.Intn(1 << 16))
+}
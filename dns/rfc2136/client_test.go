@@ -0,0 +1,327 @@
+package rfc2136
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sky-uk/feed/dns/provider"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	zone       = "james.com."
+	keyName    = "feed-key."
+	secret     = "c2VjcmV0LXNoYXJlZC13aXRoLXRoZS1zZXJ2ZXI="
+	wrongKey   = "d3Jvbmctc2VjcmV0"
+	otherValue = "10.0.0.9"
+)
+
+// fakeServer is an in-process authoritative DNS server that supports zone transfers and dynamic updates over TCP.
+type fakeServer struct {
+	listener net.Listener
+	key      *tsigKey
+	rcode    int
+	tamper   bool
+	records  []rr
+	updates  int
+	lock     sync.Mutex
+}
+
+func newFakeServer(t *testing.T, key *tsigKey, records ...rr) *fakeServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{listener: listener, key: key, records: records}
+	go s.serve()
+	return s
+}
+
+func (s *fakeServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeServer) close() {
+	s.listener.Close()
+}
+
+func (s *fakeServer) address() string {
+	return s.listener.Addr().String()
+}
+
+func (s *fakeServer) handle(conn net.Conn) {
+	defer conn.Close()
+	buf, err := readMessage(conn)
+	if err != nil {
+		return
+	}
+	request, err := unpack(buf)
+	if err != nil {
+		return
+	}
+
+	var requestMAC []byte
+	if s.key != nil {
+		if request.tsig == nil || s.key.verify(request.unsigned, nil, false, request.tsig, time.Now()) != nil {
+			s.respond(conn, &message{id: request.id, flags: flagResponse | 9}, nil, false)
+			return
+		}
+		requestMAC = request.tsig.mac
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.rcode != 0 {
+		s.respond(conn, &message{id: request.id, flags: flagResponse | uint16(s.rcode)}, requestMAC, false)
+		return
+	}
+
+	if request.opcode() == opcodeUpdate {
+		s.applyUpdate(request.authority)
+		s.respond(conn, &message{id: request.id, flags: flagResponse | opcodeUpdate<<11}, requestMAC, false)
+		return
+	}
+
+	// split the transfer over two messages, to check multi-message signing
+	soa := rr{name: zone, rtype: typeSOA, class: classINET, ttl: 300, rdata: soaRData()}
+	half := len(s.records) / 2
+	first := &message{id: request.id, flags: flagResponse, answer: append([]rr{soa}, s.records[:half]...)}
+	second := &message{id: request.id, flags: flagResponse, answer: append(append([]rr{}, s.records[half:]...), soa)}
+	mac := s.respond(conn, first, requestMAC, false)
+	s.respond(conn, second, mac, true)
+}
+
+func (s *fakeServer) respond(conn net.Conn, response *message, priorMAC []byte, timersOnly bool) []byte {
+	msg, _ := response.pack()
+	var mac []byte
+	if s.key != nil {
+		msg, mac, _ = s.key.sign(msg, priorMAC, timersOnly, time.Now())
+		if s.tamper {
+			msg[len(msg)-10]++
+		}
+	}
+	writeMessage(conn, msg)
+	return mac
+}
+
+func (s *fakeServer) applyUpdate(updates []rr) {
+	s.updates++
+	for _, update := range updates {
+		if update.class == classANY {
+			var kept []rr
+			for _, r := range s.records {
+				if !strings.EqualFold(r.name, update.name) || r.rtype != update.rtype {
+					kept = append(kept, r)
+				}
+			}
+			s.records = kept
+		} else {
+			s.records = append(s.records, update)
+		}
+	}
+}
+
+func soaRData() []byte {
+	rdata, _ := appendName(nil, "ns.james.com.")
+	rdata, _ = appendName(rdata, "hostmaster.james.com.")
+	for i := 0; i < 5; i++ {
+		rdata = appendUint32(rdata, 1)
+	}
+	return rdata
+}
+
+func record(t *testing.T, name string, rtype uint16, value string) rr {
+	rdata, err := encodeRData(rtype, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rr{name: name, rtype: rtype, class: classINET, ttl: 60, rdata: rdata}
+}
+
+func newKey(t *testing.T, secret string) *tsigKey {
+	key, err := newTSIGKey(keyName, HmacSHA256, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newClient(server *fakeServer, keySecret string) provider.Provider {
+	conf := Conf{Server: server.address(), Zone: "james.com", Timeout: time.Second}
+	if keySecret != "" {
+		conf.TSIGKeyName = keyName
+		conf.TSIGAlgorithm = HmacSHA256
+		conf.TSIGSecret = keySecret
+	}
+	return New(conf)
+}
+
+func TestDomainIsFullyQualified(t *testing.T) {
+	client := New(Conf{Zone: "James.com"})
+
+	assert.Equal(t, "james.com.", client.Domain())
+}
+
+func TestGetRecordsTransfersZone(t *testing.T) {
+	server := newFakeServer(t, newKey(t, secret),
+		record(t, "foo.james.com.", typeA, "10.0.0.1"),
+		record(t, "bar.james.com.", typeCNAME, "frontend.james.com."),
+		record(t, "foo.james.com.", typeA, "10.0.0.2"),
+		record(t, "Foo.james.com.", typeAAAA, "2001:db8::1"),
+		rr{name: "foo.james.com.", rtype: 13, class: classINET, ttl: 60, rdata: []byte{3, 'a', 'b', 'c', 0}},
+		rr{name: "foo.james.com.", rtype: typeTXT, class: classINET, ttl: 60, rdata: []byte{2, 'a', 'b', 1, 'c'}},
+	)
+	defer server.close()
+	client := newClient(server, secret)
+
+	records, err := client.GetRecords()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []provider.Record{
+		{Name: "bar.james.com.", Type: "CNAME", TTL: 60, Values: []string{"frontend.james.com."}},
+		{Name: "foo.james.com.", Type: "A", TTL: 60, Values: []string{"10.0.0.1", "10.0.0.2"}},
+		{Name: "foo.james.com.", Type: "AAAA", TTL: 60, Values: []string{"2001:db8::1"}},
+		{Name: "foo.james.com.", Type: "TXT", TTL: 60, Values: []string{"abc"}},
+	}, records)
+}
+
+func TestUpdateRecordsReplacesRecordSets(t *testing.T) {
+	server := newFakeServer(t, newKey(t, secret),
+		record(t, "foo.james.com.", typeA, "10.0.0.1"),
+		record(t, "old.james.com.", typeA, "10.0.0.1"),
+		record(t, "other.james.com.", typeA, otherValue),
+	)
+	defer server.close()
+	client := newClient(server, secret)
+
+	err := client.UpdateRecords(
+		[]provider.Record{
+			{Name: "foo.james.com.", Type: "A"},
+			{Name: "old.james.com.", Type: "A"},
+		},
+		[]provider.Record{
+			{Name: "foo.james.com.", Type: "A", TTL: 60, Values: []string{"10.0.0.2", "10.0.0.3"}},
+			{Name: "bar.james.com.", Type: "CNAME", TTL: 60, Values: []string{"frontend.james.com."}},
+		})
+	assert.NoError(t, err)
+
+	records, err := client.GetRecords()
+	assert.NoError(t, err)
+	assert.Equal(t, []provider.Record{
+		{Name: "bar.james.com.", Type: "CNAME", TTL: 60, Values: []string{"frontend.james.com."}},
+		{Name: "foo.james.com.", Type: "A", TTL: 60, Values: []string{"10.0.0.2", "10.0.0.3"}},
+		{Name: "other.james.com.", Type: "A", TTL: 60, Values: []string{otherValue}},
+	}, records)
+	assert.Equal(t, 1, server.updates, "all changes should be sent in a single update")
+}
+
+func TestUnsignedMessagesWithoutKey(t *testing.T) {
+	server := newFakeServer(t, nil, record(t, "foo.james.com.", typeA, "10.0.0.1"))
+	defer server.close()
+	client := newClient(server, "")
+
+	err := client.UpdateRecords(nil,
+		[]provider.Record{{Name: "bar.james.com.", Type: "A", TTL: 60, Values: []string{"10.0.0.2"}}})
+	assert.NoError(t, err)
+
+	records, err := client.GetRecords()
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+}
+
+func TestServerRejectsWrongKey(t *testing.T) {
+	server := newFakeServer(t, newKey(t, secret))
+	defer server.close()
+	client := newClient(server, wrongKey)
+
+	_, err := client.GetRecords()
+	assert.EqualError(t, err, "zone transfer of james.com. failed: server responded with NOTAUTH")
+
+	err = client.UpdateRecords(nil,
+		[]provider.Record{{Name: "bar.james.com.", Type: "A", TTL: 60, Values: []string{"10.0.0.2"}}})
+	assert.EqualError(t, err, "update of james.com. failed: server responded with NOTAUTH")
+}
+
+func TestResponsesWithInvalidSignaturesAreRejected(t *testing.T) {
+	server := newFakeServer(t, newKey(t, secret), record(t, "foo.james.com.", typeA, "10.0.0.1"))
+	server.tamper = true
+	defer server.close()
+	client := newClient(server, secret)
+
+	_, err := client.GetRecords()
+	assert.EqualError(t, err, "zone transfer of james.com. failed: message has an invalid TSIG signature")
+
+	err = client.UpdateRecords(nil,
+		[]provider.Record{{Name: "bar.james.com.", Type: "A", TTL: 60, Values: []string{"10.0.0.2"}}})
+	assert.EqualError(t, err, "update of james.com. failed: message has an invalid TSIG signature")
+}
+
+func TestUnsignedResponsesAreRejectedWithKey(t *testing.T) {
+	server := newFakeServer(t, nil)
+	defer server.close()
+	client := newClient(server, secret)
+
+	err := client.UpdateRecords(nil,
+		[]provider.Record{{Name: "bar.james.com.", Type: "A", TTL: 60, Values: []string{"10.0.0.2"}}})
+
+	assert.EqualError(t, err, "update of james.com. failed: response is not signed")
+}
+
+func TestUpdateErrorResponse(t *testing.T) {
+	server := newFakeServer(t, newKey(t, secret))
+	server.rcode = 5
+	defer server.close()
+	client := newClient(server, secret)
+
+	err := client.UpdateRecords([]provider.Record{{Name: "bar.james.com.", Type: "A"}}, nil)
+
+	assert.EqualError(t, err, "update of james.com. failed: server responded with REFUSED")
+}
+
+func TestInvalidRecordsAreNotSent(t *testing.T) {
+	server := newFakeServer(t, newKey(t, secret))
+	defer server.close()
+	client := newClient(server, secret)
+
+	err := client.UpdateRecords(nil,
+		[]provider.Record{{Name: "bar.james.com.", Type: "A", TTL: 60, Values: []string{"2001:db8::1"}}})
+	assert.Error(t, err)
+
+	err = client.UpdateRecords(nil, []provider.Record{{Name: "bar.james.com.", Type: "MX"}})
+	assert.EqualError(t, err, "unsupported record type MX for bar.james.com.")
+	assert.Equal(t, 0, server.updates)
+}
+
+func TestInvalidKeyConfig(t *testing.T) {
+	client := New(Conf{Zone: zone, TSIGKeyName: keyName, TSIGAlgorithm: "hmac-sha3", TSIGSecret: secret})
+	_, err := client.GetRecords()
+	assert.EqualError(t, err, "unsupported TSIG algorithm \"hmac-sha3\"")
+
+	client = New(Conf{Zone: zone, TSIGKeyName: keyName, TSIGAlgorithm: HmacSHA256, TSIGSecret: "not base64!"})
+	_, err = client.GetRecords()
+	assert.Contains(t, err.Error(), "TSIG secret is not valid base64")
+}
+
+func TestReadCompressedName(t *testing.T) {
+	// "james.com." at offset 0, then "foo" followed by a pointer to offset 0
+	buf := []byte{5, 'j', 'a', 'm', 'e', 's', 3, 'c', 'o', 'm', 0, 3, 'f', 'o', 'o', 0xc0, 0}
+
+	name, next, err := readName(buf, 11)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "foo.james.com.", name)
+	assert.Equal(t, len(buf), next)
+
+	_, _, err = readName([]byte{0xc0, 0}, 0)
+	assert.EqualError(t, err, "too many compression pointers")
+}
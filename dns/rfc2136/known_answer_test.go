@@ -0,0 +1,142 @@
+package rfc2136
+
+import (
+	"encoding/hex"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sky-uk/feed/dns/provider"
+	"github.com/stretchr/testify/assert"
+)
+
+// Messages below were generated with github.com/miekg/dns, an independent DNS implementation, signed with the
+// hmac-sha256 key feed-key. at 2017-07-14 02:40:00 UTC. They check the wire format and TSIG code against
+// something other than the fake server, which shares that code.
+var (
+	knownSigningTime = time.Unix(1500000000, 0)
+
+	knownRemove = []provider.Record{{Name: "old.james.com.", Type: "A", TTL: 60, Values: []string{"10.0.0.1"}}}
+	knownAdd    = []provider.Record{
+		{Name: "foo.james.com.", Type: "A", TTL: 60, Values: []string{"10.0.0.1", "10.0.0.2"}},
+		{Name: "foo.james.com.", Type: "AAAA", TTL: 60, Values: []string{"2001:db8::1"}},
+		{Name: "bar.james.com.", Type: "CNAME", TTL: 60, Values: []string{"frontend.james.com."}},
+		{Name: "_feed-dns-services.james.com.", Type: "TXT", TTL: 60, Values: []string{"bar.james.com."}},
+	}
+	knownUpdateRequest = unhex(
+		"123428000001000000060001056a616d657303636f6d0000060001036f6c64056a616d657303636f6d00000100ff0000" +
+			"0000000003666f6f056a616d657303636f6d00000100010000003c00040a00000103666f6f056a616d657303636f6d00" +
+			"000100010000003c00040a00000203666f6f056a616d657303636f6d00001c00010000003c001020010db80000000000" +
+			"0000000000000103626172056a616d657303636f6d00000500010000003c00140866726f6e74656e64056a616d657303" +
+			"636f6d00125f666565642d646e732d7365727669636573056a616d657303636f6d00001000010000003c000f0e626172" +
+			"2e6a616d65732e636f6d2e08666565642d6b65790000fa00ff00000000003d0b686d61632d7368613235360000005968" +
+			"2f00012c0020a3be1203ad7e669b50b39aefb03926d0f18daa8df5ceb57b125895dce1ff5aba123400000000")
+	knownUpdateResponse = unhex(
+		"1234a8000001000000000001056a616d657303636f6d000006000108666565642d6b65790000fa00ff00000000003d0b" +
+			"686d61632d73686132353600000059682f00012c0020f4047fbfbc3a2749354458527d5dd62bab3d3936c7b59b3d37d3" +
+			"b3cf9ca97c1a123400000000")
+
+	knownTransferRequest = unhex(
+		"432100000001000000000001056a616d657303636f6d0000fc000108666565642d6b65790000fa00ff00000000003d0b" +
+			"686d61632d73686132353600000059682f00012c002099baa5e77014022586e2ff0ee0bb31ce92a1ff6c1fbad086c03a" +
+			"6b9643946941432100000000")
+	// The second message is signed with only the timers, and has a TXT record with two character strings.
+	knownTransferResponses = [][]byte{
+		unhex(
+			"432180000001000300000001056a616d657303636f6d0000fc0001056a616d657303636f6d00000600010000012c0038" +
+				"026e73056a616d657303636f6d000a686f73746d6173746572056a616d657303636f6d000000000700000e1000000258" +
+				"00093a800000012c03666f6f056a616d657303636f6d00000100010000003c00040a00000103626172056a616d657303" +
+				"636f6d00000500010000003c00140866726f6e74656e64056a616d657303636f6d0008666565642d6b65790000fa00ff" +
+				"00000000003d0b686d61632d73686132353600000059682f00012c0020be69fad728af265a674c18459313e963a33ef6" +
+				"ed6bc8bb81c30f8381d125bc6d432100000000"),
+		unhex(
+			"432180000001000200000001056a616d657303636f6d0000fc0001125f666565642d646e732d7365727669636573056a" +
+				"616d657303636f6d00001000010000003c0010046261722e0a6a616d65732e636f6d2e056a616d657303636f6d000006" +
+				"00010000012c0038026e73056a616d657303636f6d000a686f73746d6173746572056a616d657303636f6d0000000007" +
+				"00000e100000025800093a800000012c08666565642d6b65790000fa00ff00000000003d0b686d61632d736861323536" +
+				"00000059682f00012c0020c0b26fab33e96fc84ad7351ad8af1156073a306378250a932816557c8721d34b4321000000" +
+				"00"),
+	}
+)
+
+func unhex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// replayServer answers a single request with canned responses, and returns the request it received.
+func replayServer(t *testing.T, responses ...[]byte) (string, <-chan []byte) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := make(chan []byte, 1)
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		request, err := readMessage(conn)
+		requests <- request
+		if err != nil {
+			return
+		}
+		for _, response := range responses {
+			if err := writeMessage(conn, response); err != nil {
+				return
+			}
+		}
+	}()
+	return listener.Addr().String(), requests
+}
+
+func newKnownAnswerClient(server string, id uint16) provider.Provider {
+	c := New(Conf{Server: server, Zone: zone, TSIGKeyName: keyName, TSIGAlgorithm: HmacSHA256,
+		TSIGSecret: secret, Timeout: time.Second}).(*client)
+	c.now = func() time.Time { return knownSigningTime }
+	c.newID = func() uint16 { return id }
+	return c
+}
+
+func TestSignedUpdateMatchesKnownAnswer(t *testing.T) {
+	assert := assert.New(t)
+	server, requests := replayServer(t, knownUpdateResponse)
+	client := newKnownAnswerClient(server, 0x1234)
+
+	err := client.UpdateRecords(knownRemove, knownAdd)
+
+	assert.NoError(err, "response signature should verify")
+	assert.Equal(hex.EncodeToString(knownUpdateRequest), hex.EncodeToString(<-requests))
+}
+
+func TestSignedZoneTransferMatchesKnownAnswer(t *testing.T) {
+	assert := assert.New(t)
+	server, requests := replayServer(t, knownTransferResponses...)
+	client := newKnownAnswerClient(server, 0x4321)
+
+	records, err := client.GetRecords()
+
+	assert.NoError(err, "response signatures should verify")
+	assert.Equal(hex.EncodeToString(knownTransferRequest), hex.EncodeToString(<-requests))
+	assert.Equal([]provider.Record{
+		{Name: "_feed-dns-services.james.com.", Type: "TXT", TTL: 60, Values: []string{"bar.james.com."}},
+		{Name: "bar.james.com.", Type: "CNAME", TTL: 60, Values: []string{"frontend.james.com."}},
+		{Name: "foo.james.com.", Type: "A", TTL: 60, Values: []string{"10.0.0.1"}},
+	}, records)
+}
+
+func TestTamperedKnownAnswerIsRejected(t *testing.T) {
+	tampered := append([]byte{}, knownUpdateResponse...)
+	tampered[len(tampered)-20] ^= 1
+	server, _ := replayServer(t, tampered)
+	client := newKnownAnswerClient(server, 0x1234)
+
+	err := client.UpdateRecords(knownRemove, knownAdd)
+
+	assert.EqualError(t, err, "update of james.com. failed: message has an invalid TSIG signature")
+}
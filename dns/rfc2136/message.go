@@ -0,0 +1,365 @@
+package rfc2136
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Wire format constants from RFC1035, RFC2136 and RFC8945.
+const (
+	typeA     uint16 = 1
+	typeCNAME uint16 = 5
+	typeSOA   uint16 = 6
+	typeTXT   uint16 = 16
+	typeAAAA  uint16 = 28
+	typeTSIG  uint16 = 250
+	typeAXFR  uint16 = 252
+
+	classINET uint16 = 1
+	classNONE uint16 = 254
+	classANY  uint16 = 255
+
+	opcodeQuery  = 0
+	opcodeUpdate = 5
+
+	flagResponse   = 1 << 15
+	headerLength   = 12
+	maxMessageSize = 65535
+	maxPointers    = 32
+)
+
+var rcodeNames = map[int]string{
+	0:  "NOERROR",
+	1:  "FORMERR",
+	2:  "SERVFAIL",
+	3:  "NXDOMAIN",
+	4:  "NOTIMP",
+	5:  "REFUSED",
+	6:  "YXDOMAIN",
+	7:  "YXRRSET",
+	8:  "NXRRSET",
+	9:  "NOTAUTH",
+	10: "NOTZONE",
+}
+
+var recordTypes = map[string]uint16{
+	"A":     typeA,
+	"AAAA":  typeAAAA,
+	"CNAME": typeCNAME,
+	"TXT":   typeTXT,
+}
+
+var errTruncated = errors.New("message is truncated")
+
+type question struct {
+	name   string
+	qtype  uint16
+	qclass uint16
+}
+
+// rr is a resource record. The rdata is always held uncompressed.
+type rr struct {
+	name  string
+	rtype uint16
+	class uint16
+	ttl   uint32
+	rdata []byte
+}
+
+type message struct {
+	id         uint16
+	flags      uint16
+	question   []question
+	answer     []rr
+	authority  []rr
+	additional []rr
+
+	// tsig is the decoded TSIG record of a received message, if it had one.
+	tsig *tsigRecord
+	// unsigned is a received message as it was before the TSIG record was added, for verifying the signature.
+	unsigned []byte
+}
+
+func (m *message) opcode() int {
+	return int(m.flags>>11) & 0xf
+}
+
+func (m *message) rcode() int {
+	return int(m.flags & 0xf)
+}
+
+func rcodeName(rcode int) string {
+	if name, ok := rcodeNames[rcode]; ok {
+		return name
+	}
+	return fmt.Sprintf("RCODE%d", rcode)
+}
+
+// pack encodes the message without name compression.
+func (m *message) pack() ([]byte, error) {
+	buf := make([]byte, headerLength, 512)
+	binary.BigEndian.PutUint16(buf[0:], m.id)
+	binary.BigEndian.PutUint16(buf[2:], m.flags)
+	binary.BigEndian.PutUint16(buf[4:], uint16(len(m.question)))
+	binary.BigEndian.PutUint16(buf[6:], uint16(len(m.answer)))
+	binary.BigEndian.PutUint16(buf[8:], uint16(len(m.authority)))
+	binary.BigEndian.PutUint16(buf[10:], uint16(len(m.additional)))
+
+	var err error
+	for _, q := range m.question {
+		if buf, err = appendName(buf, q.name); err != nil {
+			return nil, err
+		}
+		buf = appendUint16(buf, q.qtype)
+		buf = appendUint16(buf, q.qclass)
+	}
+	for _, section := range [][]rr{m.answer, m.authority, m.additional} {
+		for _, r := range section {
+			if buf, err = appendRR(buf, r); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(buf) > maxMessageSize {
+		return nil, fmt.Errorf("message of %d bytes exceeds the maximum of %d", len(buf), maxMessageSize)
+	}
+	return buf, nil
+}
+
+func appendRR(buf []byte, r rr) ([]byte, error) {
+	buf, err := appendName(buf, r.name)
+	if err != nil {
+		return nil, err
+	}
+	buf = appendUint16(buf, r.rtype)
+	buf = appendUint16(buf, r.class)
+	buf = appendUint32(buf, r.ttl)
+	buf = appendUint16(buf, uint16(len(r.rdata)))
+	return append(buf, r.rdata...), nil
+}
+
+// appendName appends the uncompressed wire format of a fully qualified domain name.
+func appendName(buf []byte, name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			if len(label) == 0 || len(label) > 63 {
+				return nil, fmt.Errorf("invalid label %q in name %q", label, name)
+			}
+			buf = append(buf, byte(len(label)))
+			buf = append(buf, label...)
+		}
+	}
+	return append(buf, 0), nil
+}
+
+func appendUint16(buf []byte, v uint16) []byte {
+	return append(buf, byte(v>>8), byte(v))
+}
+
+func appendUint32(buf []byte, v uint32) []byte {
+	return append(buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint48(buf []byte, v uint64) []byte {
+	return append(buf, byte(v>>40), byte(v>>32), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// unpack decodes a received message. A trailing TSIG record is removed from the additional section and
+// kept separately, along with the message as it was before signing.
+func unpack(buf []byte) (*message, error) {
+	if len(buf) < headerLength {
+		return nil, errTruncated
+	}
+	m := &message{
+		id:    binary.BigEndian.Uint16(buf[0:]),
+		flags: binary.BigEndian.Uint16(buf[2:]),
+	}
+	qdCount := int(binary.BigEndian.Uint16(buf[4:]))
+	counts := []int{
+		int(binary.BigEndian.Uint16(buf[6:])),
+		int(binary.BigEndian.Uint16(buf[8:])),
+		int(binary.BigEndian.Uint16(buf[10:])),
+	}
+
+	off := headerLength
+	for i := 0; i < qdCount; i++ {
+		name, next, err := readName(buf, off)
+		if err != nil {
+			return nil, err
+		}
+		if next+4 > len(buf) {
+			return nil, errTruncated
+		}
+		m.question = append(m.question, question{
+			name:   name,
+			qtype:  binary.BigEndian.Uint16(buf[next:]),
+			qclass: binary.BigEndian.Uint16(buf[next+2:]),
+		})
+		off = next + 4
+	}
+
+	sections := []*[]rr{&m.answer, &m.authority, &m.additional}
+	for s, section := range sections {
+		for i := 0; i < counts[s]; i++ {
+			start := off
+			r, next, err := readRR(buf, off)
+			if err != nil {
+				return nil, err
+			}
+			off = next
+
+			lastAdditional := s == 2 && i == counts[s]-1
+			if lastAdditional && r.rtype == typeTSIG {
+				m.tsig, err = parseTSIG(r)
+				if err != nil {
+					return nil, err
+				}
+				m.unsigned = make([]byte, start)
+				copy(m.unsigned, buf[:start])
+				binary.BigEndian.PutUint16(m.unsigned[0:], m.tsig.originalID)
+				binary.BigEndian.PutUint16(m.unsigned[10:], uint16(counts[2]-1))
+				continue
+			}
+			*section = append(*section, r)
+		}
+	}
+
+	return m, nil
+}
+
+func readRR(buf []byte, off int) (rr, int, error) {
+	name, off, err := readName(buf, off)
+	if err != nil {
+		return rr{}, 0, err
+	}
+	if off+10 > len(buf) {
+		return rr{}, 0, errTruncated
+	}
+	r := rr{
+		name:  name,
+		rtype: binary.BigEndian.Uint16(buf[off:]),
+		class: binary.BigEndian.Uint16(buf[off+2:]),
+		ttl:   binary.BigEndian.Uint32(buf[off+4:]),
+	}
+	length := int(binary.BigEndian.Uint16(buf[off+8:]))
+	off += 10
+	if off+length > len(buf) {
+		return rr{}, 0, errTruncated
+	}
+
+	if r.rtype == typeCNAME && length > 0 {
+		// decompress the target so the rdata can be used outside of this message
+		target, _, err := readName(buf, off)
+		if err != nil {
+			return rr{}, 0, err
+		}
+		if r.rdata, err = appendName(nil, target); err != nil {
+			return rr{}, 0, err
+		}
+	} else {
+		r.rdata = make([]byte, length)
+		copy(r.rdata, buf[off:off+length])
+	}
+	return r, off + length, nil
+}
+
+// readName reads a possibly compressed name, returning it fully qualified along with the offset after it.
+func readName(buf []byte, off int) (string, int, error) {
+	var labels []string
+	next := -1
+	for pointers := 0; ; {
+		if off >= len(buf) {
+			return "", 0, errTruncated
+		}
+		length := int(buf[off])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return strings.Join(labels, ".") + ".", next, nil
+		case length&0xc0 == 0xc0:
+			if off+1 >= len(buf) {
+				return "", 0, errTruncated
+			}
+			if pointers++; pointers > maxPointers {
+				return "", 0, errors.New("too many compression pointers")
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(buf[off:]) & 0x3fff)
+		case length&0xc0 != 0:
+			return "", 0, fmt.Errorf("unsupported label type %#x", length&0xc0)
+		default:
+			if off+1+length > len(buf) {
+				return "", 0, errTruncated
+			}
+			labels = append(labels, string(buf[off+1:off+1+length]))
+			off += 1 + length
+		}
+	}
+}
+
+// encodeRData converts a record value to its wire format.
+func encodeRData(rtype uint16, value string) ([]byte, error) {
+	switch rtype {
+	case typeA:
+		ip := net.ParseIP(value).To4()
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IPv4 address %q", value)
+		}
+		return []byte(ip), nil
+	case typeAAAA:
+		ip := net.ParseIP(value)
+		if ip == nil || ip.To4() != nil {
+			return nil, fmt.Errorf("invalid IPv6 address %q", value)
+		}
+		return []byte(ip.To16()), nil
+	case typeCNAME:
+		return appendName(nil, value)
+	case typeTXT:
+		if len(value) > 255 {
+			return nil, fmt.Errorf("TXT value %q is longer than 255 bytes", value)
+		}
+		return append([]byte{byte(len(value))}, value...), nil
+	}
+	return nil, fmt.Errorf("unsupported record type %d", rtype)
+}
+
+// decodeRData converts uncompressed wire format rdata to a record value.
+func decodeRData(rtype uint16, rdata []byte) (string, error) {
+	switch rtype {
+	case typeA:
+		if len(rdata) != net.IPv4len {
+			return "", fmt.Errorf("invalid A record data of length %d", len(rdata))
+		}
+		return net.IP(rdata).String(), nil
+	case typeAAAA:
+		if len(rdata) != net.IPv6len {
+			return "", fmt.Errorf("invalid AAAA record data of length %d", len(rdata))
+		}
+		return net.IP(rdata).String(), nil
+	case typeCNAME:
+		name, _, err := readName(rdata, 0)
+		return name, err
+	case typeTXT:
+		// a TXT record can hold several character strings, which are joined into one value
+		var value []byte
+		for off := 0; off < len(rdata); {
+			length := int(rdata[off])
+			if off+1+length > len(rdata) {
+				return "", errTruncated
+			}
+			value = append(value, rdata[off+1:off+1+length]...)
+			off += 1 + length
+		}
+		return string(value), nil
+	}
+	return "", fmt.Errorf("unsupported record type %d", rtype)
+}
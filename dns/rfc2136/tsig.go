@@ -0,0 +1,232 @@
+package rfc2136
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+)
+
+// TSIG algorithms supported for signing messages, by the name used to configure them.
+const (
+	HmacMD5    = "hmac-md5"
+	HmacSHA1   = "hmac-sha1"
+	HmacSHA256 = "hmac-sha256"
+	HmacSHA512 = "hmac-sha512"
+)
+
+const (
+	defaultFudge = 300
+
+	tsigErrBadSig  = 16
+	tsigErrBadKey  = 17
+	tsigErrBadTime = 18
+)
+
+type tsigAlgorithm struct {
+	name string
+	hash func() hash.Hash
+}
+
+var tsigAlgorithms = map[string]tsigAlgorithm{
+	HmacMD5:    {"hmac-md5.sig-alg.reg.int.", md5.New},
+	HmacSHA1:   {"hmac-sha1.", sha1.New},
+	HmacSHA256: {"hmac-sha256.", sha256.New},
+	HmacSHA512: {"hmac-sha512.", sha512.New},
+}
+
+var tsigErrorNames = map[uint16]string{
+	tsigErrBadSig:  "BADSIG",
+	tsigErrBadKey:  "BADKEY",
+	tsigErrBadTime: "BADTIME",
+}
+
+// tsigKey signs and verifies messages with a shared secret, as described in RFC8945.
+type tsigKey struct {
+	name      string
+	algorithm tsigAlgorithm
+	secret    []byte
+}
+
+type tsigRecord struct {
+	keyName    string
+	algorithm  string
+	timeSigned uint64
+	fudge      uint16
+	mac        []byte
+	originalID uint16
+	err        uint16
+	other      []byte
+}
+
+func newTSIGKey(name, algorithm, secret string) (*tsigKey, error) {
+	alg, ok := tsigAlgorithms[strings.ToLower(algorithm)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported TSIG algorithm %q", algorithm)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("TSIG secret is not valid base64: %v", err)
+	}
+	return &tsigKey{name: fqdn(strings.ToLower(name)), algorithm: alg, secret: decoded}, nil
+}
+
+// sign appends a TSIG record to the packed message. The MAC of the request is included when signing
+// responses, and later messages of a multi-message response are signed with only the timers.
+// It returns the signed message and its MAC.
+func (k *tsigKey) sign(msg, priorMAC []byte, timersOnly bool, now time.Time) ([]byte, []byte, error) {
+	t := &tsigRecord{
+		keyName:    k.name,
+		algorithm:  k.algorithm.name,
+		timeSigned: uint64(now.Unix()),
+		fudge:      defaultFudge,
+		originalID: binary.BigEndian.Uint16(msg[0:]),
+	}
+	mac, err := k.mac(msg, priorMAC, timersOnly, t)
+	if err != nil {
+		return nil, nil, err
+	}
+	t.mac = mac
+
+	rdata, err := t.pack()
+	if err != nil {
+		return nil, nil, err
+	}
+	signed := make([]byte, len(msg), len(msg)+len(rdata)+64)
+	copy(signed, msg)
+	signed, err = appendRR(signed, rr{name: k.name, rtype: typeTSIG, class: classANY, rdata: rdata})
+	if err != nil {
+		return nil, nil, err
+	}
+	arCount := binary.BigEndian.Uint16(signed[10:])
+	binary.BigEndian.PutUint16(signed[10:], arCount+1)
+	return signed, mac, nil
+}
+
+// verify checks the TSIG record of a received message against the unsigned messages it covers.
+func (k *tsigKey) verify(unsigned, priorMAC []byte, timersOnly bool, t *tsigRecord, now time.Time) error {
+	if fqdn(strings.ToLower(t.keyName)) != k.name {
+		return fmt.Errorf("message signed with unknown key %s", t.keyName)
+	}
+	if !strings.EqualFold(fqdn(t.algorithm), k.algorithm.name) {
+		return fmt.Errorf("message signed with unexpected algorithm %s", t.algorithm)
+	}
+	if t.err != 0 {
+		return fmt.Errorf("TSIG error %s", tsigErrorName(t.err))
+	}
+
+	expected, err := k.mac(unsigned, priorMAC, timersOnly, t)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(expected, t.mac) {
+		return fmt.Errorf("message has an invalid TSIG signature")
+	}
+
+	signedAt := time.Unix(int64(t.timeSigned), 0)
+	if skew := now.Sub(signedAt); skew > time.Duration(t.fudge)*time.Second ||
+		skew < -time.Duration(t.fudge)*time.Second {
+		return fmt.Errorf("message signed at %v is outside the allowed time skew", signedAt)
+	}
+	return nil
+}
+
+func (k *tsigKey) mac(msg, priorMAC []byte, timersOnly bool, t *tsigRecord) ([]byte, error) {
+	h := hmac.New(k.algorithm.hash, k.secret)
+	if priorMAC != nil {
+		h.Write(appendUint16(nil, uint16(len(priorMAC))))
+		h.Write(priorMAC)
+	}
+	h.Write(msg)
+
+	var vars []byte
+	var err error
+	if !timersOnly {
+		if vars, err = appendName(vars, strings.ToLower(t.keyName)); err != nil {
+			return nil, err
+		}
+		vars = appendUint16(vars, classANY)
+		vars = appendUint32(vars, 0)
+		if vars, err = appendName(vars, strings.ToLower(t.algorithm)); err != nil {
+			return nil, err
+		}
+	}
+	vars = appendUint48(vars, t.timeSigned)
+	vars = appendUint16(vars, t.fudge)
+	if !timersOnly {
+		vars = appendUint16(vars, t.err)
+		vars = appendUint16(vars, uint16(len(t.other)))
+		vars = append(vars, t.other...)
+	}
+	h.Write(vars)
+
+	return h.Sum(nil), nil
+}
+
+func (t *tsigRecord) pack() ([]byte, error) {
+	rdata, err := appendName(nil, t.algorithm)
+	if err != nil {
+		return nil, err
+	}
+	rdata = appendUint48(rdata, t.timeSigned)
+	rdata = appendUint16(rdata, t.fudge)
+	rdata = appendUint16(rdata, uint16(len(t.mac)))
+	rdata = append(rdata, t.mac...)
+	rdata = appendUint16(rdata, t.originalID)
+	rdata = appendUint16(rdata, t.err)
+	rdata = appendUint16(rdata, uint16(len(t.other)))
+	return append(rdata, t.other...), nil
+}
+
+func parseTSIG(r rr) (*tsigRecord, error) {
+	algorithm, off, err := readName(r.rdata, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TSIG record: %v", err)
+	}
+	data := r.rdata[off:]
+	if len(data) < 10 {
+		return nil, errTruncated
+	}
+	t := &tsigRecord{
+		keyName:    r.name,
+		algorithm:  algorithm,
+		timeSigned: uint64(binary.BigEndian.Uint16(data[0:]))<<32 | uint64(binary.BigEndian.Uint32(data[2:])),
+		fudge:      binary.BigEndian.Uint16(data[6:]),
+	}
+	macLength := int(binary.BigEndian.Uint16(data[8:]))
+	data = data[10:]
+	if len(data) < macLength+6 {
+		return nil, errTruncated
+	}
+	t.mac = data[:macLength]
+	data = data[macLength:]
+	t.originalID = binary.BigEndian.Uint16(data[0:])
+	t.err = binary.BigEndian.Uint16(data[2:])
+	otherLength := int(binary.BigEndian.Uint16(data[4:]))
+	if len(data) < 6+otherLength {
+		return nil, errTruncated
+	}
+	t.other = data[6 : 6+otherLength]
+	return t, nil
+}
+
+func tsigErrorName(code uint16) string {
+	if name, ok := tsigErrorNames[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("%d", code)
+}
+
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
@@ -0,0 +1,269 @@
+/*
+Package zonefile writes records to a standard RFC1035 zone file, so they can be served by a DNS server such as
+CoreDNS with its file plugin, without any cloud provider.
+*/
+package zonefile
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/sky-uk/feed/dns/provider"
+)
+
+const (
+	defaultTTL  = 300
+	refresh     = 3600
+	retry       = 600
+	expire      = 604800
+	negativeTTL = 300
+)
+
+// Conf for writing a zone file.
+type Conf struct {
+	// Path of the zone file. It's replaced atomically on each change.
+	Path string
+	// Zone is the domain of the zone.
+	Zone string
+	// Nameservers of the zone. The first is used as the primary in the SOA record.
+	Nameservers []string
+	// Hostmaster is the email address of the zone administrator, such as hostmaster@example.com.
+	Hostmaster string
+}
+
+type zoneFile struct {
+	path        string
+	zone        string
+	nameservers []string
+	hostmaster  string
+	lock        sync.Mutex
+}
+
+type zone struct {
+	serial  uint32
+	records []provider.Record
+}
+
+// New creates a provider that writes records to a zone file.
+func New(conf Conf) provider.Provider {
+	z := &zoneFile{
+		path:       conf.Path,
+		zone:       fqdn(strings.ToLower(conf.Zone)),
+		hostmaster: fqdn(strings.Replace(conf.Hostmaster, "@", ".", 1)),
+	}
+	for _, ns := range conf.Nameservers {
+		z.nameservers = append(z.nameservers, fqdn(ns))
+	}
+	if len(z.nameservers) == 0 {
+		z.nameservers = []string{"ns." + z.zone}
+	}
+	if conf.Hostmaster == "" {
+		z.hostmaster = "hostmaster." + z.zone
+	}
+	return z
+}
+
+// Domain returns the domain of the zone.
+func (z *zoneFile) Domain() string {
+	return z.zone
+}
+
+// GetRecords reads the A, AAAA, CNAME, and TXT record sets from the zone file. A missing zone file is created
+// empty, so the zone can be served before any records are added.
+func (z *zoneFile) GetRecords() ([]provider.Record, error) {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+
+	current, err := z.read()
+	if os.IsNotExist(err) {
+		log.Infof("Creating zone file %s for %s", z.path, z.zone)
+		current = &zone{}
+		err = z.write(current)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return current.records, nil
+}
+
+// UpdateRecords replaces the zone file with one containing the changed records, and an incremented serial.
+func (z *zoneFile) UpdateRecords(remove, add []provider.Record) error {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+
+	current, err := z.read()
+	if os.IsNotExist(err) {
+		current, err = &zone{}, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	removed := make(map[string]bool)
+	for _, record := range remove {
+		removed[key(record)] = true
+	}
+	var records []provider.Record
+	for _, record := range current.records {
+		if !removed[key(record)] {
+			records = append(records, record)
+		}
+	}
+	records = append(records, add...)
+
+	updated := &zone{serial: current.serial, records: records}
+	log.Infof("Writing zone file %s, removing %v and adding %v", z.path, remove, add)
+	return z.write(updated)
+}
+
+// read parses a zone file previously written by this package.
+func (z *zoneFile) read() (*zone, error) {
+	data, err := ioutil.ReadFile(z.path)
+	if err != nil {
+		return nil, err
+	}
+
+	current := &zone{}
+	sets := make(map[string]*provider.Record)
+	var keys []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.Index(text, ";"); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "$") {
+			continue
+		}
+		// name ttl class type rdata...
+		if len(fields) < 5 || fields[2] != "IN" {
+			return nil, fmt.Errorf("unable to parse line %d of zone file %s: %q", line, z.path, scanner.Text())
+		}
+
+		switch fields[3] {
+		case "SOA":
+			if len(fields) < 7 {
+				return nil, fmt.Errorf("unable to parse SOA on line %d of zone file %s", line, z.path)
+			}
+			serial, err := strconv.ParseUint(fields[6], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid serial on line %d of zone file %s: %v", line, z.path, err)
+			}
+			current.serial = uint32(serial)
+		case "A", "AAAA", "CNAME", "TXT":
+			ttl, err := strconv.ParseUint(fields[1], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ttl on line %d of zone file %s: %v", line, z.path, err)
+			}
+			record := provider.Record{Name: strings.ToLower(fields[0]), Type: fields[3], TTL: uint32(ttl)}
+			k := key(record)
+			set, ok := sets[k]
+			if !ok {
+				set = &record
+				sets[k] = set
+				keys = append(keys, k)
+			}
+			set.Values = append(set.Values, strings.Trim(fields[4], `"`))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, k := range keys {
+		current.records = append(current.records, *sets[k])
+	}
+	return current, nil
+}
+
+// write renders the zone with the next serial, and atomically replaces the zone file with it.
+func (z *zoneFile) write(current *zone) error {
+	current.serial++
+	if current.serial == 0 {
+		// serials are compared with sequence space arithmetic, so skip zero on wrap around
+		current.serial++
+	}
+
+	records := make([]provider.Record, len(current.records))
+	copy(records, current.records)
+	sort.Sort(byNameAndType(records))
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "; Generated by feed-dns. Do not edit.\n")
+	fmt.Fprintf(&buf, "$ORIGIN %s\n", z.zone)
+	fmt.Fprintf(&buf, "%s %d IN SOA %s %s %d %d %d %d %d\n", z.zone, defaultTTL, z.nameservers[0], z.hostmaster,
+		current.serial, refresh, retry, expire, negativeTTL)
+	for _, ns := range z.nameservers {
+		fmt.Fprintf(&buf, "%s %d IN NS %s\n", z.zone, defaultTTL, ns)
+	}
+	for _, record := range records {
+		values := append([]string{}, record.Values...)
+		sort.Strings(values)
+		for _, value := range values {
+			if record.Type == "TXT" {
+				value = strconv.Quote(value)
+			}
+			fmt.Fprintf(&buf, "%s %d IN %s %s\n", record.Name, record.TTL, record.Type, value)
+		}
+	}
+
+	return writeAtomically(z.path, buf.Bytes())
+}
+
+// writeAtomically writes to a temporary file in the same directory, then renames it over the path, so readers
+// never see a partially written file.
+func writeAtomically(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return fmt.Errorf("unable to create temporary zone file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to write zone file: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to write zone file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("unable to write zone file: %v", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("unable to write zone file: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("unable to replace zone file: %v", err)
+	}
+	return nil
+}
+
+func key(record provider.Record) string {
+	return strings.ToLower(record.Name) + " " + record.Type
+}
+
+type byNameAndType []provider.Record
+
+func (r byNameAndType) Len() int      { return len(r) }
+func (r byNameAndType) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r byNameAndType) Less(i, j int) bool {
+	return r[i].Name < r[j].Name || (r[i].Name == r[j].Name && r[i].Type < r[j].Type)
+}
+
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
@@ -0,0 +1,150 @@
+package zonefile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sky-uk/feed/dns/provider"
+	"github.com/stretchr/testify/assert"
+)
+
+func newZoneFile(t *testing.T) (provider.Provider, string, func()) {
+	dir, err := ioutil.TempDir("", "zonefile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "db.james.com")
+	z := New(Conf{
+		Path:        path,
+		Zone:        "james.com",
+		Nameservers: []string{"ns1.james.com", "ns2.james.com"},
+		Hostmaster:  "admin@james.com",
+	})
+	return z, path, func() { os.RemoveAll(dir) }
+}
+
+func readFile(t *testing.T, path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestDomainIsFullyQualified(t *testing.T) {
+	assert.Equal(t, "james.com.", New(Conf{Zone: "James.com"}).Domain())
+}
+
+func TestMissingZoneFileIsCreatedEmpty(t *testing.T) {
+	z, path, cleanup := newZoneFile(t)
+	defer cleanup()
+
+	records, err := z.GetRecords()
+
+	assert.NoError(t, err)
+	assert.Empty(t, records)
+	assert.Equal(t, `; Generated by feed-dns. Do not edit.
+$ORIGIN james.com.
+james.com. 300 IN SOA ns1.james.com. admin.james.com. 1 3600 600 604800 300
+james.com. 300 IN NS ns1.james.com.
+james.com. 300 IN NS ns2.james.com.
+`, readFile(t, path))
+}
+
+func TestUpdateRecordsWritesSortedRecordsWithNextSerial(t *testing.T) {
+	// given
+	z, path, cleanup := newZoneFile(t)
+	defer cleanup()
+	_, err := z.GetRecords()
+	assert.NoError(t, err)
+
+	// when
+	err = z.UpdateRecords(nil, []provider.Record{
+		{Name: "foo.james.com.", Type: "A", TTL: 60, Values: []string{"10.0.0.2", "10.0.0.1"}},
+		{Name: "bar.james.com.", Type: "CNAME", TTL: 60, Values: []string{"ingress.james.com."}},
+		{Name: "foo.james.com.", Type: "AAAA", TTL: 60, Values: []string{"2001:db8::1"}},
+		{Name: "foo.james.com.", Type: "TXT", TTL: 60, Values: []string{"bar.james.com."}},
+	})
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, `; Generated by feed-dns. Do not edit.
+$ORIGIN james.com.
+james.com. 300 IN SOA ns1.james.com. admin.james.com. 2 3600 600 604800 300
+james.com. 300 IN NS ns1.james.com.
+james.com. 300 IN NS ns2.james.com.
+bar.james.com. 60 IN CNAME ingress.james.com.
+foo.james.com. 60 IN A 10.0.0.1
+foo.james.com. 60 IN A 10.0.0.2
+foo.james.com. 60 IN AAAA 2001:db8::1
+foo.james.com. 60 IN TXT "bar.james.com."
+`, readFile(t, path))
+}
+
+func TestRecordsAreReadBackAfterRestart(t *testing.T) {
+	// given
+	z, path, cleanup := newZoneFile(t)
+	defer cleanup()
+	records := []provider.Record{
+		{Name: "bar.james.com.", Type: "CNAME", TTL: 60, Values: []string{"ingress.james.com."}},
+		{Name: "foo.james.com.", Type: "A", TTL: 60, Values: []string{"10.0.0.1", "10.0.0.2"}},
+		{Name: "foo.james.com.", Type: "TXT", TTL: 60, Values: []string{"bar.james.com."}},
+	}
+	assert.NoError(t, z.UpdateRecords(nil, records))
+
+	// when
+	restarted := New(Conf{Path: path, Zone: "james.com"})
+	readRecords, err := restarted.GetRecords()
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, records, readRecords)
+}
+
+func TestUpdateRecordsRemovesRecordSets(t *testing.T) {
+	// given
+	z, path, cleanup := newZoneFile(t)
+	defer cleanup()
+	assert.NoError(t, z.UpdateRecords(nil, []provider.Record{
+		{Name: "foo.james.com.", Type: "A", TTL: 60, Values: []string{"10.0.0.1"}},
+		{Name: "bar.james.com.", Type: "A", TTL: 60, Values: []string{"10.0.0.1"}},
+	}))
+
+	// when
+	err := z.UpdateRecords(
+		[]provider.Record{{Name: "Foo.james.com.", Type: "A"}, {Name: "bar.james.com.", Type: "A"}},
+		[]provider.Record{{Name: "foo.james.com.", Type: "A", TTL: 60, Values: []string{"10.0.0.3"}}})
+
+	// then
+	assert.NoError(t, err)
+	records, err := z.GetRecords()
+	assert.NoError(t, err)
+	assert.Equal(t, []provider.Record{{Name: "foo.james.com.", Type: "A", TTL: 60, Values: []string{"10.0.0.3"}}},
+		records)
+	assert.Contains(t, readFile(t, path), " IN SOA ns1.james.com. admin.james.com. 2 ")
+}
+
+func TestNoTemporaryFilesAreLeftBehind(t *testing.T) {
+	z, path, cleanup := newZoneFile(t)
+	defer cleanup()
+
+	assert.NoError(t, z.UpdateRecords(nil, []provider.Record{
+		{Name: "foo.james.com.", Type: "A", TTL: 60, Values: []string{"10.0.0.1"}},
+	}))
+
+	files, err := ioutil.ReadDir(filepath.Dir(path))
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestInvalidZoneFileIsAnError(t *testing.T) {
+	z, path, cleanup := newZoneFile(t)
+	defer cleanup()
+	assert.NoError(t, ioutil.WriteFile(path, []byte("foo.james.com. A\n"), 0644))
+
+	_, err := z.GetRecords()
+
+	assert.Contains(t, err.Error(), "unable to parse line 1 of zone file")
+}
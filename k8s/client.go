@@ -7,6 +7,7 @@ The types are copied from the stable api of the Kubernetes 1.3 release.
 package k8s
 
 import (
+	"bytes"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
@@ -29,7 +30,10 @@ import (
 
 const (
 	ingressPath       = "/apis/extensions/v1beta1/ingresses"
+	ingressStatusPath = "/apis/extensions/v1beta1/namespaces/%s/ingresses/%s/status"
+	configMapsPath    = "/api/v1/namespaces/%s/configmaps"
 	servicePath       = "/api/v1/services"
+	secretPath        = "/api/v1/namespaces/%s/secrets/%s"
 	initialRetryDelay = time.Millisecond * 100
 	maxRetryDelay     = time.Second * 60
 )
@@ -50,6 +54,22 @@ type Client interface {
 
 	// WatchServices watches for updates to services and notifies the Watcher.
 	WatchServices() Watcher
+
+	// GetSecret returns the secret, or nil if it doesn't exist.
+	GetSecret(namespace, name string) (*Secret, error)
+
+	// UpdateIngressStatus replaces the status of the ingress with ingress.Status.
+	UpdateIngressStatus(ingress Ingress) error
+
+	// GetConfigMap returns the config map, or nil if it doesn't exist.
+	GetConfigMap(namespace, name string) (*ConfigMap, error)
+
+	// CreateConfigMap creates the config map, failing if it already exists.
+	CreateConfigMap(configMap *ConfigMap) error
+
+	// UpdateConfigMap replaces the config map. It fails if the config map has been modified since
+	// configMap.ResourceVersion.
+	UpdateConfigMap(configMap *ConfigMap) error
 }
 
 type client struct {
@@ -129,6 +149,72 @@ func (c *client) GetServices() ([]Service, error) {
 	return serviceList.Items, nil
 }
 
+func (c *client) GetSecret(namespace, name string) (*Secret, error) {
+	var secret Secret
+	err := c.requestAndUnmarshall(fmt.Sprintf(secretPath, url.QueryEscape(namespace), url.QueryEscape(name)), &secret)
+	if statusErr, ok := err.(*statusError); ok && statusErr.code == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &secret, nil
+}
+
+func (c *client) UpdateIngressStatus(ingress Ingress) error {
+	body, err := json.Marshal(ingress)
+	if err != nil {
+		return fmt.Errorf("unable to marshal ingress %s/%s: %v", ingress.Namespace, ingress.Name, err)
+	}
+
+	path := fmt.Sprintf(ingressStatusPath, url.QueryEscape(ingress.Namespace), url.QueryEscape(ingress.Name))
+	resp, err := c.requestWithBody("PUT", path, body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *client) GetConfigMap(namespace, name string) (*ConfigMap, error) {
+	var configMap ConfigMap
+	err := c.requestAndUnmarshall(configMapPath(namespace, name), &configMap)
+	if statusErr, ok := err.(*statusError); ok && statusErr.code == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &configMap, nil
+}
+
+func (c *client) CreateConfigMap(configMap *ConfigMap) error {
+	return c.writeConfigMap("POST", fmt.Sprintf(configMapsPath, url.QueryEscape(configMap.Namespace)), configMap)
+}
+
+func (c *client) UpdateConfigMap(configMap *ConfigMap) error {
+	return c.writeConfigMap("PUT", configMapPath(configMap.Namespace, configMap.Name), configMap)
+}
+
+func configMapPath(namespace, name string) string {
+	return fmt.Sprintf(configMapsPath, url.QueryEscape(namespace)) + "/" + url.QueryEscape(name)
+}
+
+// writeConfigMap sends the config map, updating it with the stored version from the response.
+func (c *client) writeConfigMap(method, path string, configMap *ConfigMap) error {
+	body, err := json.Marshal(configMap)
+	if err != nil {
+		return fmt.Errorf("unable to marshal config map %s/%s: %v", configMap.Namespace, configMap.Name, err)
+	}
+
+	resp, err := c.requestWithBody(method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return c.unmarshal(resp.Body, configMap)
+}
+
 func (c *client) WatchIngresses() Watcher {
 	return c.watch(ingressPath)
 }
@@ -305,11 +391,23 @@ func (c *client) unmarshal(r io.Reader, val interface{}) error {
 }
 
 func (c *client) request(path string) (*http.Response, error) {
+	return c.requestWithBody("GET", path, nil)
+}
+
+func (c *client) requestWithBody(method, path string, body []byte) (*http.Response, error) {
 	endpoint := c.baseURL + path
-	req, err := http.NewRequest("GET", endpoint, nil)
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+		log.Debugf("k8s<-: %s", string(body))
+	}
+	req, err := http.NewRequest(method, endpoint, reqBody)
 	if err != nil {
 		return nil, err
 	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
 
 	if c.token != "" {
 		req.Header.Add("Authorization", "Bearer "+c.token)
@@ -327,13 +425,23 @@ func (c *client) request(path string) (*http.Response, error) {
 			log.Debug("Watch returned 410 (Gone) due to k8s having no events yet, ignoring")
 		} else {
 			resp.Body.Close()
-			return nil, fmt.Errorf("GET %s returned %v", endpoint, *resp)
+			return nil, &statusError{code: resp.StatusCode, msg: fmt.Sprintf("%s %s returned %v", method, endpoint, *resp)}
 		}
 	}
 
 	return resp, nil
 }
 
+// statusError is returned for responses with an unsuccessful status code.
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e *statusError) Error() string {
+	return e.msg
+}
+
 func (c *client) String() string {
 	return fmt.Sprintf("[k8s @ %s]", c.baseURL)
 }
@@ -70,6 +70,30 @@ func TestRetrievesServicesFromKubernetes(t *testing.T) {
 	assert.Equal(servicesFixture.Items, services)
 }
 
+func TestRetrievesSecretFromKubernetes(t *testing.T) {
+	assert := assert.New(t)
+
+	secretFixture := &Secret{
+		ObjectMeta: ObjectMeta{Namespace: "foo", Name: "foo-secret"},
+		Data:       map[string][]byte{"auth": []byte("user:$apr1$hash")},
+		Type:       "Opaque",
+	}
+	handler, _ := handleGet("/api/v1/namespaces/foo/secrets/foo-secret", secretFixture)
+	ts := httptest.NewTLSServer(handler)
+	defer ts.Close()
+
+	client, err := newClient(ts.URL, apiServerCert, testAuthToken)
+	assert.NoError(err)
+
+	secret, err := client.GetSecret("foo", "foo-secret")
+	assert.NoError(err)
+	assert.Equal(secretFixture, secret)
+
+	missing, err := client.GetSecret("foo", "missing")
+	assert.NoError(err)
+	assert.Nil(missing)
+}
+
 func TestClientCertificatesWork(t *testing.T) {
 	assert := assert.New(t)
 
@@ -120,6 +144,110 @@ func TestTokenIsOptional(t *testing.T) {
 	skipAuth = false
 }
 
+func TestUpdatesIngressStatus(t *testing.T) {
+	assert := assert.New(t)
+
+	ingress := createIngressesFixture().Items[0]
+	ingress.Namespace = "foo-ns"
+	ingress.Status.LoadBalancer.Ingress = []LoadBalancerIngress{{Hostname: "elb.example.com"}}
+
+	var received Ingress
+	var method string
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/apis/extensions/v1beta1/namespaces/"+ingress.Namespace+"/ingresses/"+ingress.Name+"/status" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if !validAuthToken(r) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		method = r.Method
+		assert.NoError(json.NewDecoder(r.Body).Decode(&received))
+		writeAsJSON(received, w)
+	}))
+	defer ts.Close()
+
+	client, err := newClient(ts.URL, apiServerCert, testAuthToken)
+	assert.NoError(err)
+
+	assert.NoError(client.UpdateIngressStatus(ingress))
+	assert.Equal("PUT", method)
+	assert.Equal(ingress, received)
+}
+
+func TestErrorIfIngressStatusUpdateFails(t *testing.T) {
+	assert := assert.New(t)
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+	}))
+	defer ts.Close()
+
+	client, err := newClient(ts.URL, apiServerCert, testAuthToken)
+	assert.NoError(err)
+
+	assert.Error(client.UpdateIngressStatus(createIngressesFixture().Items[0]))
+}
+
+func TestConfigMapsCanBeCreatedReadAndUpdated(t *testing.T) {
+	assert := assert.New(t)
+
+	var stored *ConfigMap
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !validAuthToken(r) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/api/v1/namespaces/kube-system/configmaps":
+			var created ConfigMap
+			assert.NoError(json.NewDecoder(r.Body).Decode(&created))
+			created.ResourceVersion = "1"
+			stored = &created
+			writeAsJSON(stored, w)
+		case r.URL.Path != "/api/v1/namespaces/kube-system/configmaps/feed-dns" || stored == nil:
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == "GET":
+			writeAsJSON(stored, w)
+		case r.Method == "PUT":
+			var updated ConfigMap
+			assert.NoError(json.NewDecoder(r.Body).Decode(&updated))
+			if updated.ResourceVersion != stored.ResourceVersion {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			updated.ResourceVersion = "2"
+			stored = &updated
+			writeAsJSON(stored, w)
+		}
+	}))
+	defer ts.Close()
+
+	client, err := newClient(ts.URL, apiServerCert, testAuthToken)
+	assert.NoError(err)
+
+	configMap, err := client.GetConfigMap("kube-system", "feed-dns")
+	assert.NoError(err)
+	assert.Nil(configMap)
+
+	configMap = &ConfigMap{ObjectMeta: ObjectMeta{Namespace: "kube-system", Name: "feed-dns",
+		Annotations: map[string]string{"leader": "me"}}}
+	assert.NoError(client.CreateConfigMap(configMap))
+	assert.Equal("1", configMap.ResourceVersion)
+
+	read, err := client.GetConfigMap("kube-system", "feed-dns")
+	assert.NoError(err)
+	assert.Equal(configMap, read)
+
+	read.Annotations["leader"] = "you"
+	assert.NoError(client.UpdateConfigMap(read))
+	assert.Equal("2", read.ResourceVersion)
+
+	configMap.Annotations["leader"] = "stale"
+	assert.Error(client.UpdateConfigMap(configMap), "stale resource version should conflict")
+}
+
 func TestErrorIfNon200StatusCode(t *testing.T) {
 	assert := assert.New(t)
 
@@ -0,0 +1,15 @@
+package k8s
+
+// Secret holds secret data of a certain type.
+type Secret struct {
+	TypeMeta `json:",inline"`
+	// Standard object's metadata.
+	// More info: http://releases.k8s.io/HEAD/docs/devel/api-conventions.md#metadata
+	ObjectMeta `json:"metadata,omitempty" protobuf:"bytes,1,opt,name=metadata"`
+
+	// Data contains the secret data. The serialized form is base64 encoded.
+	Data map[string][]byte `json:"data,omitempty" protobuf:"bytes,2,rep,name=data"`
+
+	// Type is used to facilitate programmatic handling of secret data.
+	Type string `json:"type,omitempty" protobuf:"bytes,3,opt,name=type"`
+}
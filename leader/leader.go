@@ -0,0 +1,387 @@
+/*
+Package leader elects a single leader among replicas of a controller, using a lease held in an annotation of a
+Kubernetes config map. Only the leader runs the wrapped updater, while the other replicas are hot standbys that
+keep up with ingress updates so they can take over quickly.
+*/
+package leader
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sky-uk/feed/controller"
+	"github.com/sky-uk/feed/k8s"
+	"github.com/sky-uk/feed/util"
+)
+
+// LeaseAnnotation is the config map annotation holding the lease.
+const LeaseAnnotation = "sky.uk/feed-leader"
+
+var leaderGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: util.PrometheusNamespace,
+	Name:      "leader",
+	Help:      "1 if this replica is the leader, otherwise 0.",
+})
+
+var leaderTransitionsCounter = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: util.PrometheusNamespace,
+	Name:      "leader_transitions",
+	Help:      "The number of times this replica has become or stopped being the leader.",
+})
+
+func init() {
+	prometheus.MustRegister(leaderGauge)
+	prometheus.MustRegister(leaderTransitionsCounter)
+}
+
+// Config for leader election.
+type Config struct {
+	KubernetesClient k8s.Client
+	// Namespace and Name of the config map holding the lease. It's created if it doesn't exist.
+	Namespace string
+	Name      string
+	// Identity of this replica, unique among the replicas.
+	Identity string
+	// LeaseDuration is how long a lease is held without being renewed, before another replica can take it.
+	LeaseDuration time.Duration
+	// RetryPeriod is how often the lease is renewed by the leader, or checked by the standbys.
+	RetryPeriod time.Duration
+	// Updater is only started and updated while this replica is the leader.
+	Updater controller.Updater
+}
+
+// lease is the annotation value, recording the leader.
+type lease struct {
+	HolderIdentity       string    `json:"holderIdentity"`
+	LeaseDurationSeconds int       `json:"leaseDurationSeconds"`
+	AcquireTime          time.Time `json:"acquireTime"`
+	RenewTime            time.Time `json:"renewTime"`
+}
+
+type elector struct {
+	Config
+	now func() time.Time
+	// lock guards the state below. It's never held while calling the apiserver or the wrapped updater, so a
+	// slow update can't hold up renewing the lease.
+	lock       sync.Mutex
+	leading    bool
+	lost       bool
+	validUntil time.Time
+	holder     string
+	lastUpdate *controller.IngressUpdate
+	healthErr  error
+	// updaterLock serialises calls to the wrapped updater.
+	updaterLock sync.Mutex
+	// observedLease is the last lease value read, and observedTime when this replica first saw it. Leases expire
+	// relative to observedTime rather than the lease's own timestamps, so clock skew between replicas doesn't matter.
+	// Only the campaign uses them.
+	observedLease string
+	observedTime  time.Time
+	doneCh        chan struct{}
+	stopOnce      sync.Once
+	loopDone      sync.WaitGroup
+}
+
+// New creates an updater that delegates to conf.Updater while this replica holds the lease. Once leadership
+// is lost the replica becomes unhealthy, as the updater can't be restarted, and should be restarted itself.
+func New(conf Config) controller.Updater {
+	return &elector{
+		Config: conf,
+		now:    time.Now,
+		doneCh: make(chan struct{}),
+	}
+}
+
+func (e *elector) Start() error {
+	log.Infof("Starting leader election as %s, using config map %s/%s", e.Identity, e.Namespace, e.Name)
+	leaderGauge.Set(0)
+	e.loopDone.Add(1)
+	go e.campaign()
+	return nil
+}
+
+func (e *elector) campaign() {
+	defer e.loopDone.Done()
+	ticker := time.NewTicker(e.RetryPeriod)
+	defer ticker.Stop()
+
+	for {
+		if !e.tryAcquireOrRenew() {
+			return
+		}
+		select {
+		case <-e.doneCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// tryAcquireOrRenew takes the lease if it's free or already ours. It returns false once leadership is lost.
+func (e *elector) tryAcquireOrRenew() bool {
+	e.lock.Lock()
+	lost := e.lost
+	e.lock.Unlock()
+	if lost {
+		return false
+	}
+	now := e.now()
+
+	configMap, err := e.KubernetesClient.GetConfigMap(e.Namespace, e.Name)
+	if err != nil {
+		log.Warnf("Unable to get leader lease: %v", err)
+		return e.checkStillLeading(now)
+	}
+
+	var current lease
+	if configMap != nil {
+		if value, ok := configMap.Annotations[LeaseAnnotation]; ok {
+			if err := json.Unmarshal([]byte(value), &current); err != nil {
+				log.Warnf("Ignoring invalid leader lease %q: %v", value, err)
+				current = lease{}
+			}
+			if value != e.observedLease {
+				e.observedLease = value
+				e.observedTime = now
+			}
+		}
+	}
+
+	e.lock.Lock()
+	leading := e.leading
+	expiry := e.observedTime.Add(time.Duration(current.LeaseDurationSeconds) * time.Second)
+	if current.HolderIdentity != "" && current.HolderIdentity != e.Identity && now.Before(expiry) {
+		if e.holder != current.HolderIdentity {
+			log.Infof("%s is the leader", current.HolderIdentity)
+		}
+		e.holder = current.HolderIdentity
+		e.lock.Unlock()
+		if leading {
+			e.stopLeading(fmt.Errorf("leadership was taken by %s", current.HolderIdentity))
+			return false
+		}
+		return true
+	}
+	e.lock.Unlock()
+
+	renewed := lease{
+		HolderIdentity:       e.Identity,
+		LeaseDurationSeconds: int(e.LeaseDuration / time.Second),
+		AcquireTime:          now,
+		RenewTime:            now,
+	}
+	if current.HolderIdentity == e.Identity && leading {
+		renewed.AcquireTime = current.AcquireTime
+	}
+	value, _ := json.Marshal(renewed)
+
+	if configMap == nil {
+		configMap = &k8s.ConfigMap{ObjectMeta: k8s.ObjectMeta{Namespace: e.Namespace, Name: e.Name}}
+	}
+	if configMap.Annotations == nil {
+		configMap.Annotations = make(map[string]string)
+	}
+	configMap.Annotations[LeaseAnnotation] = string(value)
+
+	if configMap.ResourceVersion == "" {
+		err = e.KubernetesClient.CreateConfigMap(configMap)
+	} else {
+		err = e.KubernetesClient.UpdateConfigMap(configMap)
+	}
+	if err != nil {
+		log.Warnf("Unable to write leader lease: %v", err)
+		return e.checkStillLeading(now)
+	}
+
+	e.observedLease = string(value)
+	e.observedTime = now
+
+	e.lock.Lock()
+	// give up a retry period before the lease expires, so two replicas never lead at once
+	e.validUntil = now.Add(e.LeaseDuration - e.RetryPeriod)
+	e.holder = e.Identity
+	e.lock.Unlock()
+	if !leading {
+		return e.startLeading()
+	}
+	return true
+}
+
+// checkStillLeading stops leading if the lease couldn't be renewed in time.
+func (e *elector) checkStillLeading(now time.Time) bool {
+	e.lock.Lock()
+	expired := e.leading && !now.Before(e.validUntil)
+	e.lock.Unlock()
+	if expired {
+		e.stopLeading(fmt.Errorf("unable to renew leader lease"))
+		return false
+	}
+	return true
+}
+
+// startLeading starts the updater with the latest update. It returns false if the updater fails to start.
+func (e *elector) startLeading() bool {
+	e.updaterLock.Lock()
+	defer e.updaterLock.Unlock()
+
+	log.Infof("%s is now the leader", e.Identity)
+	e.lock.Lock()
+	e.leading = true
+	lastUpdate := e.lastUpdate
+	e.lock.Unlock()
+	leaderGauge.Set(1)
+	leaderTransitionsCounter.Inc()
+
+	if err := e.Updater.Start(); err != nil {
+		e.stopLeadingLocked(fmt.Errorf("unable to start %v: %v", e.Updater, err))
+		return false
+	}
+	if lastUpdate != nil {
+		if err := e.updateWhileLeading(*lastUpdate); err != nil {
+			log.Errorf("Unable to update %v after becoming the leader: %v", e.Updater, err)
+		}
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return !e.lost
+}
+
+// stopLeading stops the updater once any update in progress has finished.
+func (e *elector) stopLeading(reason error) {
+	e.updaterLock.Lock()
+	defer e.updaterLock.Unlock()
+	e.stopLeadingLocked(reason)
+}
+
+// stopLeadingLocked stops the updater, which can't be restarted. The caller must hold updaterLock.
+func (e *elector) stopLeadingLocked(reason error) {
+	e.lock.Lock()
+	if !e.leading {
+		e.lock.Unlock()
+		return
+	}
+	log.Errorf("%s is no longer the leader: %v", e.Identity, reason)
+	e.leading = false
+	e.lost = true
+	e.healthErr = reason
+	e.lock.Unlock()
+	leaderGauge.Set(0)
+	leaderTransitionsCounter.Inc()
+
+	if err := e.Updater.Stop(); err != nil {
+		log.Warnf("Error while stopping %v: %v", e.Updater, err)
+	}
+}
+
+// Stop gives up the lease, so a standby can take over without waiting for it to expire.
+func (e *elector) Stop() error {
+	e.stopOnce.Do(func() { close(e.doneCh) })
+	e.loopDone.Wait()
+
+	e.updaterLock.Lock()
+	defer e.updaterLock.Unlock()
+	e.lock.Lock()
+	leading := e.leading
+	e.leading = false
+	e.lock.Unlock()
+	if !leading {
+		return nil
+	}
+
+	leaderGauge.Set(0)
+	e.release()
+	return e.Updater.Stop()
+}
+
+func (e *elector) release() {
+	configMap, err := e.KubernetesClient.GetConfigMap(e.Namespace, e.Name)
+	if err != nil {
+		log.Warnf("Unable to release leader lease: %v", err)
+		return
+	}
+	var current lease
+	if configMap != nil {
+		json.Unmarshal([]byte(configMap.Annotations[LeaseAnnotation]), &current)
+	}
+	if current.HolderIdentity != e.Identity {
+		return
+	}
+	released, _ := json.Marshal(lease{})
+	configMap.Annotations[LeaseAnnotation] = string(released)
+	if err := e.KubernetesClient.UpdateConfigMap(configMap); err != nil {
+		log.Warnf("Unable to release leader lease: %v", err)
+		return
+	}
+	log.Infof("Released leader lease")
+}
+
+func (e *elector) Update(update controller.IngressUpdate) error {
+	e.lock.Lock()
+	e.lastUpdate = &update
+	e.lock.Unlock()
+
+	e.updaterLock.Lock()
+	defer e.updaterLock.Unlock()
+	return e.updateWhileLeading(update)
+}
+
+// updateWhileLeading updates the wrapped updater only while the lease is still valid, stopping leading once it
+// isn't, as a standby may already have taken over. The caller must hold updaterLock.
+func (e *elector) updateWhileLeading(update controller.IngressUpdate) error {
+	e.lock.Lock()
+	leading := e.leading
+	expired := !e.now().Before(e.validUntil)
+	e.lock.Unlock()
+
+	if !leading {
+		log.Debugf("Not the leader, so not updating %v", e.Updater)
+		return nil
+	}
+	if expired {
+		err := fmt.Errorf("leader lease expired before it could be renewed")
+		e.stopLeadingLocked(err)
+		return fmt.Errorf("not updating %v: %v", e.Updater, err)
+	}
+	return e.Updater.Update(update)
+}
+
+// Health is the wrapped updater's health while leading. Standbys are healthy until they lose leadership.
+func (e *elector) Health() error {
+	e.lock.Lock()
+	healthErr := e.healthErr
+	leading := e.leading
+	e.lock.Unlock()
+
+	if healthErr != nil {
+		return healthErr
+	}
+	if leading {
+		return e.Updater.Health()
+	}
+	return nil
+}
+
+// Status describes the leadership of this replica.
+func (e *elector) Status() string {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	switch {
+	case e.leading:
+		return fmt.Sprintf("leader: %s (this replica)", e.Identity)
+	case e.lost:
+		return "leader: lost leadership"
+	case e.holder != "":
+		return fmt.Sprintf("leader: %s", e.holder)
+	default:
+		return "leader: unknown"
+	}
+}
+
+func (e *elector) String() string {
+	return fmt.Sprintf("leader election for %v", e.Updater)
+}
@@ -0,0 +1,297 @@
+package leader
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sky-uk/feed/controller"
+	"github.com/sky-uk/feed/k8s"
+	fake "github.com/sky-uk/feed/util/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type fakeUpdater struct {
+	mock.Mock
+}
+
+func (u *fakeUpdater) Update(update controller.IngressUpdate) error {
+	r := u.Called(update)
+	return r.Error(0)
+}
+
+func (u *fakeUpdater) Start() error {
+	r := u.Called()
+	return r.Error(0)
+}
+
+func (u *fakeUpdater) Stop() error {
+	r := u.Called()
+	return r.Error(0)
+}
+
+func (u *fakeUpdater) Health() error {
+	r := u.Called()
+	return r.Error(0)
+}
+
+func (u *fakeUpdater) String() string {
+	return "FakeUpdater"
+}
+
+var start = time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type testElector struct {
+	*elector
+	client  *fake.FakeClient
+	updater *fakeUpdater
+	clock   time.Time
+}
+
+func newElector() *testElector {
+	client := new(fake.FakeClient)
+	updater := new(fakeUpdater)
+	e := New(Config{
+		KubernetesClient: client,
+		Namespace:        "kube-system",
+		Name:             "feed-dns-leader",
+		Identity:         "me",
+		LeaseDuration:    15 * time.Second,
+		RetryPeriod:      5 * time.Second,
+		Updater:          updater,
+	}).(*elector)
+	te := &testElector{elector: e, client: client, updater: updater, clock: start}
+	e.now = func() time.Time { return te.clock }
+	return te
+}
+
+func configMapWithLease(holder string, renewed time.Time) *k8s.ConfigMap {
+	value, _ := json.Marshal(lease{
+		HolderIdentity:       holder,
+		LeaseDurationSeconds: 15,
+		AcquireTime:          renewed,
+		RenewTime:            renewed,
+	})
+	return &k8s.ConfigMap{ObjectMeta: k8s.ObjectMeta{
+		Namespace:       "kube-system",
+		Name:            "feed-dns-leader",
+		ResourceVersion: "1",
+		Annotations:     map[string]string{LeaseAnnotation: string(value)},
+	}}
+}
+
+func leaseHeldBy(holder string) interface{} {
+	return mock.MatchedBy(func(configMap *k8s.ConfigMap) bool {
+		var l lease
+		json.Unmarshal([]byte(configMap.Annotations[LeaseAnnotation]), &l)
+		return l.HolderIdentity == holder
+	})
+}
+
+var update = controller.IngressUpdate{Entries: []controller.IngressEntry{{Name: "ns/ing", Host: "foo.com"}}}
+
+func TestCreatesConfigMapAndLeadsIfMissing(t *testing.T) {
+	assert := assert.New(t)
+	e := newElector()
+	e.client.On("GetConfigMap", "kube-system", "feed-dns-leader").Return(nil, nil)
+	e.client.On("CreateConfigMap", leaseHeldBy("me")).Return(nil)
+	e.updater.On("Start").Return(nil)
+	e.updater.On("Update", update).Return(nil)
+
+	assert.True(e.tryAcquireOrRenew())
+	assert.NoError(e.Update(update))
+
+	e.client.AssertExpectations(t)
+	e.updater.AssertExpectations(t)
+	assert.Equal("leader: me (this replica)", e.Status())
+}
+
+func TestIsStandbyWhileAnotherReplicaHoldsTheLease(t *testing.T) {
+	assert := assert.New(t)
+	e := newElector()
+	e.client.On("GetConfigMap", "kube-system", "feed-dns-leader").Return(configMapWithLease("other", start), nil)
+
+	assert.True(e.tryAcquireOrRenew())
+	assert.NoError(e.Update(update))
+
+	e.updater.AssertNotCalled(t, "Start")
+	e.updater.AssertNotCalled(t, "Update", mock.Anything)
+	e.client.AssertNotCalled(t, "UpdateConfigMap", mock.Anything)
+	assert.NoError(e.Health())
+	assert.Equal("leader: other", e.Status())
+}
+
+func TestTakesOverExpiredLeaseAndAppliesLatestUpdate(t *testing.T) {
+	assert := assert.New(t)
+	e := newElector()
+	e.client.On("GetConfigMap", "kube-system", "feed-dns-leader").Return(configMapWithLease("other", start), nil)
+	e.client.On("UpdateConfigMap", leaseHeldBy("me")).Return(nil)
+	e.updater.On("Start").Return(nil)
+	e.updater.On("Update", update).Return(nil)
+
+	assert.True(e.tryAcquireOrRenew())
+	assert.NoError(e.Update(update))
+	e.clock = start.Add(16 * time.Second)
+	assert.True(e.tryAcquireOrRenew())
+
+	e.client.AssertExpectations(t)
+	e.updater.AssertExpectations(t)
+	e.updater.AssertNumberOfCalls(t, "Update", 1)
+}
+
+func TestLeaseExpiresALeaseDurationAfterItWasLastSeenToChange(t *testing.T) {
+	assert := assert.New(t)
+	e := newElector()
+	// the holder's clock is an hour behind, so its lease looks long expired
+	skewed := start.Add(-time.Hour)
+	e.client.On("GetConfigMap", "kube-system", "feed-dns-leader").Return(configMapWithLease("other", skewed), nil).Twice()
+	e.client.On("GetConfigMap", "kube-system", "feed-dns-leader").Return(
+		configMapWithLease("other", skewed.Add(10*time.Second)), nil)
+	e.client.On("UpdateConfigMap", leaseHeldBy("me")).Return(nil)
+	e.updater.On("Start").Return(nil)
+
+	assert.True(e.tryAcquireOrRenew())
+	e.clock = start.Add(10 * time.Second)
+	assert.True(e.tryAcquireOrRenew())
+	e.clock = start.Add(12 * time.Second)
+	assert.True(e.tryAcquireOrRenew(), "renewed lease")
+	e.clock = start.Add(26 * time.Second)
+	assert.True(e.tryAcquireOrRenew())
+	e.updater.AssertNotCalled(t, "Start")
+	assert.Equal("leader: other", e.Status())
+
+	e.clock = start.Add(28 * time.Second)
+	assert.True(e.tryAcquireOrRenew())
+	e.updater.AssertCalled(t, "Start")
+	assert.Equal("leader: me (this replica)", e.Status())
+}
+
+func TestBecomesUnhealthyWhenLeaseCannotBeRenewed(t *testing.T) {
+	assert := assert.New(t)
+	e := newElector()
+	e.client.On("GetConfigMap", "kube-system", "feed-dns-leader").Return(nil, nil).Once()
+	e.client.On("CreateConfigMap", leaseHeldBy("me")).Return(nil)
+	e.client.On("GetConfigMap", "kube-system", "feed-dns-leader").Return(nil, errors.New("apiserver down"))
+	e.updater.On("Start").Return(nil)
+	e.updater.On("Health").Return(nil)
+	e.updater.On("Stop").Return(nil)
+
+	assert.True(e.tryAcquireOrRenew())
+	e.clock = start.Add(5 * time.Second)
+	assert.True(e.tryAcquireOrRenew(), "still within the lease")
+	assert.NoError(e.Health())
+	e.clock = start.Add(10 * time.Second)
+	assert.False(e.tryAcquireOrRenew())
+
+	e.updater.AssertCalled(t, "Stop")
+	assert.Error(e.Health())
+	assert.Equal("leader: lost leadership", e.Status())
+}
+
+func TestRefusesUpdatesOnceTheLeaseHasExpired(t *testing.T) {
+	assert := assert.New(t)
+	e := newElector()
+	e.client.On("GetConfigMap", "kube-system", "feed-dns-leader").Return(nil, nil)
+	e.client.On("CreateConfigMap", leaseHeldBy("me")).Return(nil)
+	e.updater.On("Start").Return(nil)
+	e.updater.On("Stop").Return(nil)
+
+	assert.True(e.tryAcquireOrRenew())
+	e.clock = start.Add(10 * time.Second)
+	assert.Error(e.Update(update))
+
+	e.updater.AssertNotCalled(t, "Update", mock.Anything)
+	e.updater.AssertCalled(t, "Stop")
+	assert.Error(e.Health())
+	assert.False(e.tryAcquireOrRenew())
+}
+
+func TestRenewsTheLeaseDuringASlowUpdate(t *testing.T) {
+	assert := assert.New(t)
+	e := newElector()
+	e.client.On("GetConfigMap", "kube-system", "feed-dns-leader").Return(nil, nil).Once()
+	e.client.On("CreateConfigMap", leaseHeldBy("me")).Return(nil)
+	e.client.On("GetConfigMap", "kube-system", "feed-dns-leader").Return(configMapWithLease("me", start), nil)
+	e.client.On("UpdateConfigMap", leaseHeldBy("me")).Return(nil)
+	e.updater.On("Start").Return(nil)
+	updating := make(chan struct{})
+	finish := make(chan struct{})
+	e.updater.On("Update", update).Return(nil).Run(func(mock.Arguments) {
+		close(updating)
+		<-finish
+	})
+
+	assert.True(e.tryAcquireOrRenew())
+	updated := make(chan error)
+	go func() { updated <- e.Update(update) }()
+	<-updating
+
+	renewed := make(chan bool)
+	go func() { renewed <- e.tryAcquireOrRenew() }()
+	select {
+	case ok := <-renewed:
+		assert.True(ok)
+	case <-time.After(time.Second):
+		t.Error("lease renewal waited for the update")
+	}
+
+	close(finish)
+	assert.NoError(<-updated)
+	e.client.AssertNumberOfCalls(t, "UpdateConfigMap", 1)
+}
+
+func TestStopsLeadingIfLeaseIsTaken(t *testing.T) {
+	assert := assert.New(t)
+	e := newElector()
+	e.client.On("GetConfigMap", "kube-system", "feed-dns-leader").Return(nil, nil).Once()
+	e.client.On("CreateConfigMap", leaseHeldBy("me")).Return(nil)
+	e.client.On("GetConfigMap", "kube-system", "feed-dns-leader").Return(configMapWithLease("other", start), nil)
+	e.updater.On("Start").Return(nil)
+	e.updater.On("Stop").Return(nil)
+
+	assert.True(e.tryAcquireOrRenew())
+	assert.False(e.tryAcquireOrRenew())
+
+	e.updater.AssertExpectations(t)
+	assert.Error(e.Health())
+}
+
+func TestStopsLeadingIfUpdaterFailsToStart(t *testing.T) {
+	assert := assert.New(t)
+	e := newElector()
+	e.client.On("GetConfigMap", "kube-system", "feed-dns-leader").Return(nil, nil)
+	e.client.On("CreateConfigMap", leaseHeldBy("me")).Return(nil)
+	e.updater.On("Start").Return(errors.New("no hosted zone"))
+	e.updater.On("Stop").Return(nil)
+
+	assert.False(e.tryAcquireOrRenew())
+	assert.Error(e.Health())
+}
+
+func TestStopReleasesTheLease(t *testing.T) {
+	assert := assert.New(t)
+	e := newElector()
+	e.client.On("GetConfigMap", "kube-system", "feed-dns-leader").Return(configMapWithLease("me", start), nil)
+	e.client.On("UpdateConfigMap", leaseHeldBy("me")).Return(nil).Once()
+	e.client.On("UpdateConfigMap", leaseHeldBy("")).Return(nil).Once()
+	e.updater.On("Start").Return(nil)
+	e.updater.On("Stop").Return(nil)
+
+	assert.NoError(e.Start())
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(e.Stop())
+
+	e.client.AssertExpectations(t)
+	e.updater.AssertExpectations(t)
+}
+
+func TestStopCanBeCalledTwice(t *testing.T) {
+	e := newElector()
+	e.client.On("GetConfigMap", "kube-system", "feed-dns-leader").Return(configMapWithLease("other", start), nil)
+
+	assert.NoError(t, e.Start())
+	assert.NoError(t, e.Stop())
+	assert.NoError(t, e.Stop())
+}
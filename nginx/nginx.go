@@ -4,11 +4,18 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
 
 	"bytes"
+	"crypto/sha256"
 	"fmt"
+	"hash/fnv"
+	"net"
+	"net/url"
 	"text/template"
 
+	"regexp"
+	"sort"
 	"strings"
 
 	"time"
@@ -23,6 +30,11 @@ import (
 const (
 	nginxStartDelay       = time.Millisecond * 100
 	metricsUpdateInterval = time.Second * 10
+
+	defaultBackendConnectTimeoutSeconds = 10
+	defaultWebSocketTimeoutSeconds      = 3600
+	defaultRateLimitZoneSizeMB          = 10
+	defaultRateLimitStatus              = 429
 )
 
 // Conf configuration for nginx
@@ -34,10 +46,27 @@ type Conf struct {
 	KeepaliveSeconds        int
 	BackendKeepalives       int
 	BackendKeepaliveSeconds int
-	HealthPort              int
-	TrustedFrontends        []string
-	IngressPort             int
-	LogLevel                string
+	// BackendConnectTimeoutSeconds is the default timeout for connecting to backends. Defaults to 10.
+	BackendConnectTimeoutSeconds int
+	// WebSocketTimeoutSeconds is the default read and send timeout of WebSocket ingresses. Defaults to 3600.
+	WebSocketTimeoutSeconds int
+	// RateLimitZoneSizeMB is the size of the shared memory zone of each rate limit. Defaults to 10.
+	RateLimitZoneSizeMB int
+	// RateLimitStatus is the status code of requests rejected by a rate or connection limit. Defaults to 429.
+	RateLimitStatus int
+	// HTTP2 accepts HTTP/2 instead of HTTP/1.x on the ingress port, which gRPC clients need. The frontend must
+	// forward TCP connections, rather than HTTP requests.
+	HTTP2 bool
+	// MaxConnectionsPerIP limits the concurrent connections from each client IP to ingresses. Zero doesn't
+	// limit connections.
+	MaxConnectionsPerIP int
+	HealthPort          int
+	TrustedFrontends    []string
+	IngressPort         int
+	LogLevel            string
+	// Resolvers are the DNS servers nginx uses to resolve the hosts of external auth services as requests arrive.
+	// Ingresses with an auth service named by host rather than IP address are skipped if there are none.
+	Resolvers []string
 }
 
 // Signaller interface around signalling the loadbalancer process
@@ -76,23 +105,137 @@ type nginxLoadBalancer struct {
 type loadBalancerTemplate struct {
 	Conf
 	Entries []nginxEntry
+	Servers []*nginxServer
+	// GRPCStatuses are returned by gRPC locations instead of HTTP errors.
+	GRPCStatuses []grpcStatus
+}
+
+// nginxServer has the entries of a single host, as nginx only uses the first server block with a given name.
+type nginxServer struct {
+	Host       string
+	ServerName string
+	Entries    []nginxEntry
+	// GRPC is true if any entries proxy gRPC, so need the gRPC status locations.
+	GRPC bool
+}
+
+// grpcStatus is a gRPC status code, returned in place of HTTP errors that gRPC clients don't understand.
+type grpcStatus struct {
+	Name         string
+	Code         int
+	Message      string
+	HTTPStatuses []int
+}
+
+// grpcStatuses map HTTP errors to gRPC status codes, following the gRPC HTTP to gRPC status code mapping.
+var grpcStatuses = []grpcStatus{
+	{Name: "internal", Code: 13, Message: "internal", HTTPStatuses: []int{400, 500}},
+	{Name: "unauthenticated", Code: 16, Message: "unauthenticated", HTTPStatuses: []int{401}},
+	{Name: "permission_denied", Code: 7, Message: "permission denied", HTTPStatuses: []int{403}},
+	{Name: "unimplemented", Code: 12, Message: "unimplemented", HTTPStatuses: []int{404}},
+	{Name: "resource_exhausted", Code: 8, Message: "resource exhausted", HTTPStatuses: []int{413, 429}},
+	{Name: "unavailable", Code: 14, Message: "unavailable", HTTPStatuses: []int{502, 503}},
+	{Name: "deadline_exceeded", Code: 4, Message: "deadline exceeded", HTTPStatuses: []int{504}},
 }
 
 type nginxEntry struct {
 	controller.IngressEntry
 	UpstreamID string
+	// ProxyScheme is http or https, for HTTP backends.
+	ProxyScheme string
+	// GRPCScheme is grpc or grpcs, for gRPC backends. Empty if the backend doesn't use gRPC.
+	GRPCScheme string
+	// Location is the modifier and path of the location block.
+	Location string
+	// StripPath removes the location path when proxying.
+	StripPath bool
+	// Rewrite is the quoted regex and replacement of the rewrite directive, if the path is rewritten.
+	Rewrite string
+	// RateLimitZone is the name of the limit_req_zone, if the request rate is limited.
+	RateLimitZone string
+	// RateLimitKey is the variable identifying clients for rate limiting.
+	RateLimitKey string
+	// AuthFile is the quoted path of the htpasswd file, if basic authentication is required.
+	AuthFile string
+	// BackendTLSCA is the quoted path of the CA bundle verifying the backend, if it's verified.
+	BackendTLSCA string
+	// BackendTLSCertificate is the quoted path of the client certificate presented to the backend, if any.
+	BackendTLSCertificate string
+	// BackendTLSKey is the quoted path of the client certificate's key.
+	BackendTLSKey string
+	// AuthRequest is the internal location of the external auth subrequest, if external authentication is required.
+	AuthRequest string
+	// AuthURL is the quoted URL of the external auth service.
+	AuthURL string
+	// AuthSignin is the named location redirecting unauthenticated users, if they should sign in.
+	AuthSignin string
+	// AuthSigninRedirect is the quoted URL unauthenticated users are redirected to.
+	AuthSigninRedirect string
+	// AuthResponseHeaders are copied from the external auth response to the proxied request.
+	AuthResponseHeaders []authResponseHeader
+}
+
+type authResponseHeader struct {
+	Name string
+	// Variable holds the header's value from the auth response, set by auth_request_set.
+	Variable string
+	// Upstream is the header in the auth response.
+	Upstream string
 }
 
 func (lb *nginxLoadBalancer) nginxConfFile() string {
 	return lb.WorkingDir + "/nginx.conf"
 }
 
+func (lb *nginxLoadBalancer) secretsDir() string {
+	return lb.WorkingDir + "/secrets"
+}
+
+// secretFile is named after the secret, its key and a hash of the contents, so a changed secret changes
+// nginx.conf and causes a reload.
+func (lb *nginxLoadBalancer) secretFile(secret, key string, contents []byte) string {
+	hash := sha256.Sum256(contents)
+	name := strings.Replace(secret, "/", "_", -1)
+	return fmt.Sprintf("%s/%s-%s-%x", lb.secretsDir(), name, key, hash[:8])
+}
+
+// secretFiles returns the contents of the files from secrets needed by the entry, keyed by path.
+func (lb *nginxLoadBalancer) secretFiles(entry controller.IngressEntry) map[string][]byte {
+	files := make(map[string][]byte)
+	add := func(secret, key string, contents []byte) {
+		if len(contents) > 0 {
+			files[lb.secretFile(secret, key, contents)] = contents
+		}
+	}
+	if auth := entry.BasicAuth; auth != nil {
+		add(auth.Secret, "auth", auth.Htpasswd)
+	}
+	if backendTLS := entry.BackendTLS; backendTLS != nil && backendTLS.Secret != "" {
+		add(backendTLS.Secret, "ca.crt", backendTLS.CA)
+		add(backendTLS.Secret, "tls.crt", backendTLS.Certificate)
+		add(backendTLS.Secret, "tls.key", backendTLS.Key)
+	}
+	return files
+}
+
 // New creates an nginx proxy.
 func New(nginxConf Conf) controller.Updater {
 	nginxConf.WorkingDir = strings.TrimSuffix(nginxConf.WorkingDir, "/")
 	if nginxConf.LogLevel == "" {
 		nginxConf.LogLevel = "warn"
 	}
+	if nginxConf.BackendConnectTimeoutSeconds == 0 {
+		nginxConf.BackendConnectTimeoutSeconds = defaultBackendConnectTimeoutSeconds
+	}
+	if nginxConf.WebSocketTimeoutSeconds == 0 {
+		nginxConf.WebSocketTimeoutSeconds = defaultWebSocketTimeoutSeconds
+	}
+	if nginxConf.RateLimitZoneSizeMB == 0 {
+		nginxConf.RateLimitZoneSizeMB = defaultRateLimitZoneSizeMB
+	}
+	if nginxConf.RateLimitStatus == 0 {
+		nginxConf.RateLimitStatus = defaultRateLimitStatus
+	}
 
 	return &nginxLoadBalancer{
 		Conf:      nginxConf,
@@ -196,6 +339,11 @@ func (lb *nginxLoadBalancer) Stop() error {
 }
 
 func (lb *nginxLoadBalancer) Update(entries controller.IngressUpdate) error {
+	secretFiles, err := lb.writeSecretFiles(entries)
+	if err != nil {
+		return fmt.Errorf("unable to write files from secrets: %v", err)
+	}
+
 	updated, err := lb.update(entries)
 	if err != nil {
 		return fmt.Errorf("unable to update nginx: %v", err)
@@ -209,9 +357,50 @@ func (lb *nginxLoadBalancer) Update(entries controller.IngressUpdate) error {
 		log.Info("Nginx updated")
 	}
 
+	lb.removeStaleSecretFiles(secretFiles)
 	return nil
 }
 
+// writeSecretFiles writes the files from secrets needed by the entries, such as htpasswd files and certificates,
+// returning the files written. They're only readable by nginx, as they can hold private keys.
+func (lb *nginxLoadBalancer) writeSecretFiles(update controller.IngressUpdate) (map[string]bool, error) {
+	written := make(map[string]bool)
+	for _, entry := range update.Entries {
+		for file, contents := range lb.secretFiles(entry) {
+			if written[file] {
+				continue
+			}
+			if len(written) == 0 {
+				if err := os.MkdirAll(lb.secretsDir(), 0700); err != nil {
+					return nil, err
+				}
+			}
+			if err := ioutil.WriteFile(file, contents, 0600); err != nil {
+				return nil, err
+			}
+			written[file] = true
+		}
+	}
+	return written, nil
+}
+
+// removeStaleSecretFiles removes files from secrets that are no longer used. nginx reads htpasswd files on each
+// request, so this is only safe once nginx has reloaded without them.
+func (lb *nginxLoadBalancer) removeStaleSecretFiles(secretFiles map[string]bool) {
+	existing, err := filepath.Glob(lb.secretsDir() + "/*")
+	if err != nil {
+		log.Warnf("Unable to list files from secrets: %v", err)
+		return
+	}
+	for _, file := range existing {
+		if !secretFiles[file] {
+			if err := os.Remove(file); err != nil {
+				log.Warnf("Unable to remove stale file %s: %v", file, err)
+			}
+		}
+	}
+}
+
 func (lb *nginxLoadBalancer) update(entries controller.IngressUpdate) (bool, error) {
 	log.Debugf("Updating loadbalancer %s", entries)
 	updatedConfig, err := lb.createConfig(entries)
@@ -278,23 +467,115 @@ func (lb *nginxLoadBalancer) createConfig(update controller.IngressUpdate) ([]by
 	sortedIngressEntries := update.SortedByName().Entries
 
 	var entries []nginxEntry
+	var servers []*nginxServer
+	serversByHost := make(map[string]*nginxServer)
+	locations := make(map[string]string)
 	for idx, ingressEntry := range sortedIngressEntries {
-		trimmedPath := strings.TrimSuffix(strings.TrimPrefix(ingressEntry.Path, "/"), "/")
-		if len(trimmedPath) == 0 {
-			ingressEntry.Path = "/"
-		} else {
-			ingressEntry.Path = fmt.Sprintf("/%s/", trimmedPath)
+		if auth := ingressEntry.BasicAuth; auth != nil && len(auth.Htpasswd) == 0 {
+			log.Warnf("Ignoring %s, as its %v can't be read without -watch-secrets", ingressEntry.Name, auth)
+			continue
+		}
+		if backendTLS := ingressEntry.BackendTLS; backendTLS != nil && backendTLS.Secret != "" && len(backendTLS.CA) == 0 {
+			log.Warnf("Ignoring %s, as its %v can't be read without -watch-secrets", ingressEntry.Name, backendTLS)
+			continue
+		}
+		if auth := ingressEntry.ExternalAuth; auth != nil && len(lb.Resolvers) == 0 && needsResolving(auth.URL) {
+			log.Warnf("Ignoring %s, as there are no resolvers for nginx to resolve its auth url %s", ingressEntry.Name,
+				auth.URL)
+			continue
+		}
+		location, locationKey := nginxLocation(ingressEntry)
+		locationKey = ingressEntry.Host + " " + locationKey
+		if existing, ok := locations[locationKey]; ok {
+			log.Warnf("Ignoring %s, as %s already has location %s for %s", ingressEntry.Name, existing, location,
+				ingressEntry.Host)
+			continue
+		}
+		locations[locationKey] = ingressEntry.Name
+
+		if ingressEntry.PathType == "" {
+			ingressEntry.Path = location
+		}
+		if ingressEntry.ProxyConnectTimeoutSeconds == 0 {
+			ingressEntry.ProxyConnectTimeoutSeconds = lb.BackendConnectTimeoutSeconds
+		}
+		streamTimeout := lb.BackendKeepaliveSeconds
+		if ingressEntry.WebSocket {
+			streamTimeout = lb.WebSocketTimeoutSeconds
+		}
+		if ingressEntry.ProxyReadTimeoutSeconds == 0 {
+			ingressEntry.ProxyReadTimeoutSeconds = streamTimeout
+		}
+		if ingressEntry.ProxySendTimeoutSeconds == 0 {
+			ingressEntry.ProxySendTimeoutSeconds = streamTimeout
 		}
 
 		entry := nginxEntry{
 			IngressEntry: ingressEntry,
 			UpstreamID:   fmt.Sprintf("upstream%03d", idx),
+			Location:     location,
+		}
+		switch ingressEntry.BackendProtocol {
+		case controller.BackendProtocolGRPC, controller.BackendProtocolGRPCS:
+			entry.GRPCScheme = strings.ToLower(ingressEntry.BackendProtocol)
+			if !lb.HTTP2 {
+				log.Warnf("%s has a gRPC backend, but HTTP/2 isn't enabled on the ingress port", ingressEntry.Name)
+			}
+		case controller.BackendProtocolHTTPS:
+			entry.ProxyScheme = "https"
+		default:
+			entry.ProxyScheme = "http"
+		}
+		// grpc_pass can't change the path, so gRPC paths are proxied in full.
+		entry.StripPath = ingressEntry.PathType == "" && !ingressEntry.PreservePath && entry.GRPCScheme == ""
+		if ingressEntry.RewriteRegex != "" {
+			entry.Rewrite = quote(ingressEntry.RewriteRegex) + " " + quote(ingressEntry.RewriteReplacement)
+		}
+		if ingressEntry.RateLimitRPS > 0 {
+			entry.RateLimitKey = rateLimitKey(ingressEntry.RateLimitHeader)
+			entry.RateLimitZone = rateLimitZone(ingressEntry, entry.RateLimitKey)
+		}
+		if auth := ingressEntry.BasicAuth; auth != nil {
+			entry.AuthFile = quote(lb.secretFile(auth.Secret, "auth", auth.Htpasswd))
+		}
+		if backendTLS := ingressEntry.BackendTLS; backendTLS != nil && backendTLS.Secret != "" {
+			entry.BackendTLSCA = quote(lb.secretFile(backendTLS.Secret, "ca.crt", backendTLS.CA))
+			if len(backendTLS.Certificate) > 0 {
+				entry.BackendTLSCertificate = quote(lb.secretFile(backendTLS.Secret, "tls.crt", backendTLS.Certificate))
+				entry.BackendTLSKey = quote(lb.secretFile(backendTLS.Secret, "tls.key", backendTLS.Key))
+			}
+		}
+		if auth := ingressEntry.ExternalAuth; auth != nil {
+			entry.AuthRequest = "/_external_auth_" + entry.UpstreamID
+			entry.AuthURL = quote(auth.URL)
+			if auth.SigninURL != "" {
+				entry.AuthSignin = "@external_auth_signin_" + entry.UpstreamID
+				entry.AuthSigninRedirect = quote(signinRedirect(auth.SigninURL))
+			}
+			for _, header := range auth.ResponseHeaders {
+				entry.AuthResponseHeaders = append(entry.AuthResponseHeaders, authResponseHeader{
+					Name:     header,
+					Variable: headerVariable("auth_", header),
+					Upstream: headerVariable("upstream_http_", header),
+				})
+			}
 		}
 		entries = append(entries, entry)
+
+		server, ok := serversByHost[ingressEntry.Host]
+		if !ok {
+			server = &nginxServer{Host: ingressEntry.Host, ServerName: serverName(ingressEntry.Host)}
+			serversByHost[ingressEntry.Host] = server
+			servers = append(servers, server)
+		}
+		server.Entries = append(server.Entries, entry)
+		server.GRPC = server.GRPC || entry.GRPCScheme != ""
 	}
+	sort.Sort(byHost(servers))
 
 	var output bytes.Buffer
-	err = tmpl.Execute(&output, loadBalancerTemplate{Conf: lb.Conf, Entries: entries})
+	err = tmpl.Execute(&output, loadBalancerTemplate{Conf: lb.Conf, Entries: entries, Servers: servers,
+		GRPCStatuses: grpcStatuses})
 
 	if err != nil {
 		return []byte{}, fmt.Errorf("Unable to execute nginx config duration. It will be out of date: %v", err)
@@ -303,6 +584,96 @@ func (lb *nginxLoadBalancer) createConfig(update controller.IngressUpdate) ([]by
 	return output.Bytes(), nil
 }
 
+// nginxLocation returns the location block's modifier and path for the entry's path type, and a key which is
+// the same for locations nginx considers duplicates. Exact paths take precedence, then the longest matching
+// prefix if it's a Prefix path, then the first matching Regex path, and finally the longest matching prefix.
+func nginxLocation(entry controller.IngressEntry) (string, string) {
+	path := entry.Path
+	if path == "" {
+		path = "/"
+	}
+	switch entry.PathType {
+	case controller.PathTypeExact:
+		return "= " + quote(path), "= " + path
+	case controller.PathTypePrefix:
+		return "^~ " + quote(path), path
+	case controller.PathTypeRegex:
+		return "~ " + quote(entry.Path), "~ " + entry.Path
+	default:
+		trimmedPath := strings.TrimSuffix(strings.TrimPrefix(entry.Path, "/"), "/")
+		if len(trimmedPath) == 0 {
+			return "/", "/"
+		}
+		path = fmt.Sprintf("/%s/", trimmedPath)
+		return path, path
+	}
+}
+
+// needsResolving returns true if the URL's host is a name, rather than an IP address, so nginx needs a resolver.
+func needsResolving(rawURL string) bool {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return true
+	}
+	host := parsed.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return net.ParseIP(strings.Trim(host, "[]")) == nil
+}
+
+// rateLimitKey returns the variable with the header's value, or the client IP if header is empty.
+func rateLimitKey(header string) string {
+	if header == "" {
+		return "$binary_remote_addr"
+	}
+	return headerVariable("http_", header)
+}
+
+// headerVariable returns the nginx variable with the prefix for the header, such as $http_x_api_key.
+func headerVariable(prefix, header string) string {
+	return "$" + prefix + strings.Replace(strings.ToLower(header), "-", "_", -1)
+}
+
+// signinRedirect adds the original URL to the signin URL, so users can be sent back after signing in.
+func signinRedirect(signinURL string) string {
+	separator := "?"
+	if strings.Contains(signinURL, "?") {
+		separator = "&"
+	}
+	return signinURL + separator + "rd=$original_scheme://$host$request_uri"
+}
+
+// rateLimitZone returns a zone name that only changes if the entry's location or key changes. nginx fails to
+// reload if an existing zone is given a different key, so zones can't be named by their position in the config.
+func rateLimitZone(entry controller.IngressEntry, key string) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(strings.Join([]string{entry.Name, entry.Host, entry.PathType, entry.Path, key}, " ")))
+	return fmt.Sprintf("rate_limit_%08x", hash.Sum32())
+}
+
+type byHost []*nginxServer
+
+func (a byHost) Len() int           { return len(a) }
+func (a byHost) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byHost) Less(i, j int) bool { return a[i].Host < a[j].Host }
+
+// serverName returns the nginx server_name for the host. A wildcard host only matches a single label, as
+// for Kubernetes ingresses, so it's a regex. Regexes come after exact names in nginx's precedence, so exact
+// hosts are always preferred.
+func serverName(host string) string {
+	if strings.HasPrefix(host, "*.") {
+		return "~^[^.]+" + regexp.QuoteMeta(host[1:]) + "$"
+	}
+	return host
+}
+
+// quote returns s as an nginx quoted string, so regexes with braces or semicolons are a single parameter.
+// The controller ensures s has no quotes or whitespace.
+func quote(s string) string {
+	return `"` + strings.Replace(s, `\`, `\\`, -1) + `"`
+}
+
 func (lb *nginxLoadBalancer) Health() error {
 	if !lb.running.Get() {
 		return fmt.Errorf("nginx is not running")
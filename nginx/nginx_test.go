@@ -252,23 +252,14 @@ func TestNginxConfigUpdates(t *testing.T) {
 				},
 			},
 			[]string{
-				"   # chris-ingress\n" +
-					"    upstream upstream000 {\n" +
-					"        server service:9090;\n" +
-					"        keepalive 1024;\n" +
-					"    }\n" +
-					"\n" +
-					"    server {\n" +
-					"        listen 9090;\n" +
-					"        server_name chris.com;\n" +
-					"\n" +
-					"        # Restrict clients\n" +
-					"        allow 127.0.0.1;\n" +
-					"        allow 10.82.0.0/16;\n" +
-					"        \n" +
-					"        deny all;\n" +
-					"\n" +
+				"       # chris-ingress\n" +
 					"        location /path/ {\n" +
+					"            # Restrict clients\n" +
+					"            allow 127.0.0.1;\n" +
+					"            allow 10.82.0.0/16;\n" +
+					"            \n" +
+					"            deny all;\n" +
+					"\n" +
 					"            # Strip location path when proxying.\n" +
 					"            proxy_pass http://upstream000/;\n" +
 					"\n" +
@@ -282,7 +273,7 @@ func TestNginxConfigUpdates(t *testing.T) {
 					"\n            # Timeout faster than the default 60s on initial connect.\n" +
 					"            proxy_connect_timeout 10s;\n" +
 					"\n" +
-					"            # Close proxy connections after backend keepalive time.\n" +
+					"            # Close proxy connections after backend keepalive time, unless overridden by the ingress.\n" +
 					"            proxy_read_timeout 58s;\n" +
 					"            proxy_send_timeout 58s;\n" +
 					"\n" +
@@ -292,8 +283,7 @@ func TestNginxConfigUpdates(t *testing.T) {
 					"            proxy_buffering off;\n" +
 					"            proxy_request_buffering off;\n" +
 					"        }\n" +
-					"    }\n" +
-					"    ",
+					"        ",
 			},
 		},
 		{
@@ -310,20 +300,12 @@ func TestNginxConfigUpdates(t *testing.T) {
 				},
 			},
 			[]string{
-				"   # chris-ingress\n" +
-					"    upstream upstream000 {\n" +
-					"        server service:9090;\n" +
-					"        keepalive 1024;\n" +
-					"    }\n" +
-					"\n" +
-					"    server {\n" +
-					"        listen 9090;\n" +
-					"        server_name chris.com;\n" +
-					"\n" +
-					"        # Restrict clients\n" +
-					"        allow 127.0.0.1;\n" +
-					"        \n" +
-					"        deny all;\n",
+				"       # chris-ingress\n" +
+					"        location /path/ {\n" +
+					"            # Restrict clients\n" +
+					"            allow 127.0.0.1;\n" +
+					"            \n" +
+					"            deny all;\n",
 			},
 		},
 		{
@@ -340,20 +322,12 @@ func TestNginxConfigUpdates(t *testing.T) {
 				},
 			},
 			[]string{
-				"   # chris-ingress\n" +
-					"    upstream upstream000 {\n" +
-					"        server service:9090;\n" +
-					"        keepalive 1024;\n" +
-					"    }\n" +
-					"\n" +
-					"    server {\n" +
-					"        listen 9090;\n" +
-					"        server_name chris.com;\n" +
-					"\n" +
-					"        # Restrict clients\n" +
-					"        allow 127.0.0.1;\n" +
-					"        \n" +
-					"        deny all;\n",
+				"       # chris-ingress\n" +
+					"        location /path/ {\n" +
+					"            # Restrict clients\n" +
+					"            allow 127.0.0.1;\n" +
+					"            \n" +
+					"            deny all;\n",
 			},
 		},
 		{
@@ -363,7 +337,7 @@ func TestNginxConfigUpdates(t *testing.T) {
 				{
 					Name:           "2-last-ingress",
 					Host:           "foo.com",
-					Path:           "/",
+					Path:           "/last",
 					ServiceAddress: "foo",
 					ServicePort:    8080,
 					Allow:          []string{"10.82.0.0/16"},
@@ -371,7 +345,7 @@ func TestNginxConfigUpdates(t *testing.T) {
 				{
 					Name:           "0-first-ingress",
 					Host:           "foo.com",
-					Path:           "/",
+					Path:           "/first",
 					ServiceAddress: "foo",
 					ServicePort:    8080,
 					Allow:          []string{"10.82.0.0/16"},
@@ -379,19 +353,19 @@ func TestNginxConfigUpdates(t *testing.T) {
 				{
 					Name:           "1-next-ingress",
 					Host:           "foo.com",
-					Path:           "/",
+					Path:           "/next",
 					ServiceAddress: "foo",
 					ServicePort:    8080,
 					Allow:          []string{"10.82.0.0/16"},
 				},
 			},
 			[]string{
-				"   # 0-first-ingress\n" +
-					"    upstream upstream000 {\n",
-				"   # 1-next-ingress\n" +
-					"    upstream upstream001 {\n",
-				"   # 2-last-ingress\n" +
-					"    upstream upstream002 {\n",
+				"       # 0-first-ingress\n" +
+					"        location /first/ {\n",
+				"       # 1-next-ingress\n" +
+					"        location /next/ {\n",
+				"       # 2-last-ingress\n" +
+					"        location /last/ {\n",
 			},
 		},
 		{
@@ -401,7 +375,7 @@ func TestNginxConfigUpdates(t *testing.T) {
 				{
 					Name:           "2-last-ingress",
 					Host:           "foo.com",
-					Path:           "/",
+					Path:           "/last",
 					ServiceAddress: "foo",
 					ServicePort:    8080,
 					Allow:          []string{"10.82.0.0/16"},
@@ -409,7 +383,7 @@ func TestNginxConfigUpdates(t *testing.T) {
 				{
 					Name:           "0-first-ingress",
 					Host:           "foo.com",
-					Path:           "/",
+					Path:           "/first",
 					ServiceAddress: "foo",
 					ServicePort:    8080,
 					Allow:          []string{"10.82.0.0/16"},
@@ -417,7 +391,7 @@ func TestNginxConfigUpdates(t *testing.T) {
 				{
 					Name:           "1-next-ingress",
 					Host:           "foo.com",
-					Path:           "/",
+					Path:           "/next",
 					ServiceAddress: "foo",
 					ServicePort:    8080,
 					Allow:          []string{"10.82.0.0/16"},
@@ -482,6 +456,41 @@ func TestNginxConfigUpdates(t *testing.T) {
 				"        location /prefix-without-anyslash/ {\n",
 			},
 		},
+		{
+			"Path can be preserved or rewritten",
+			defaultConf,
+			[]controller.IngressEntry{
+				{
+					Host:           "chris.com",
+					Name:           "0-preserved-ingress",
+					Path:           "/path",
+					ServiceAddress: "service",
+					ServicePort:    9090,
+					PreservePath:   true,
+				},
+				{
+					Host:               "chris.com",
+					Name:               "1-rewritten-ingress",
+					Path:               "/api",
+					ServiceAddress:     "service",
+					ServicePort:        9090,
+					PreservePath:       true,
+					RewriteRegex:       `^/api/(\d{2})/(.*)$`,
+					RewriteReplacement: "/v$1/$2",
+				},
+			},
+			[]string{
+				"            # Keep the full path when proxying.\n" +
+					"            proxy_pass http://upstream000;\n" +
+					"\n" +
+					"            # Enable keepalive to backend.\n",
+				"            # Rewrite the path when proxying.\n" +
+					"            rewrite \"^/api/(\\\\d{2})/(.*)$\" \"/v$1/$2\" break;\n" +
+					"            proxy_pass http://upstream001;\n" +
+					"\n" +
+					"            # Enable keepalive to backend.\n",
+			},
+		},
 		{
 			"Check multiple allows work",
 			defaultConf,
@@ -496,12 +505,12 @@ func TestNginxConfigUpdates(t *testing.T) {
 				},
 			},
 			[]string{
-				"        # Restrict clients\n" +
-					"        allow 127.0.0.1;\n" +
-					"        allow 10.82.0.0/16;\n" +
-					"        allow 10.99.0.0/16;\n" +
-					"        \n" +
-					"        deny all;\n",
+				"            # Restrict clients\n" +
+					"            allow 127.0.0.1;\n" +
+					"            allow 10.82.0.0/16;\n" +
+					"            allow 10.99.0.0/16;\n" +
+					"            \n" +
+					"            deny all;\n",
 			},
 		},
 	}
@@ -536,6 +545,197 @@ func TestNginxConfigUpdates(t *testing.T) {
 	}
 }
 
+func TestNginxServersAndLocations(t *testing.T) {
+	assert := assert.New(t)
+	tmpDir := setupWorkDir(t)
+	defer os.Remove(tmpDir)
+
+	var tests = []struct {
+		name          string
+		entries       []controller.IngressEntry
+		configServers []string
+		absent        []string
+	}{
+		{
+			"Entries are grouped into a server per host",
+			[]controller.IngressEntry{
+				{Name: "a-ingress", Host: "foo.com", Path: "/a", ServiceAddress: "a", ServicePort: 8080},
+				{Name: "b-ingress", Host: "bar.com", Path: "/b", ServiceAddress: "b", ServicePort: 8080},
+				{Name: "c-ingress", Host: "foo.com", Path: "/c", ServiceAddress: "c", ServicePort: 8080},
+			},
+			[]string{
+				"    server {\n" +
+					"        listen 9090;\n" +
+					"        server_name bar.com;\n" +
+					"        \n" +
+					"        # Start entry\n" +
+					"        # b-ingress\n" +
+					"        location /b/ {\n",
+				"        server_name foo.com;\n" +
+					"        \n" +
+					"        # Start entry\n" +
+					"        # a-ingress\n" +
+					"        location /a/ {\n",
+				"        # c-ingress\n" +
+					"        location /c/ {\n",
+			},
+			nil,
+		},
+		{
+			"Wildcard hosts only match a single label",
+			[]controller.IngressEntry{
+				{Name: "chris-ingress", Host: "*.apps.chris.com", Path: "/", ServiceAddress: "service", ServicePort: 9090},
+				{Name: "exact-ingress", Host: "exact.apps.chris.com", Path: "/", ServiceAddress: "service", ServicePort: 9090},
+			},
+			[]string{
+				"        server_name ~^[^.]+\\.apps\\.chris\\.com$;\n",
+				"        server_name exact.apps.chris.com;\n",
+			},
+			nil,
+		},
+		{
+			"Path types are rendered as location modifiers",
+			[]controller.IngressEntry{
+				{Name: "0-default", Host: "foo.com", Path: "/api", ServiceAddress: "a", ServicePort: 8080},
+				{Name: "1-exact", Host: "foo.com", Path: "/api", PathType: controller.PathTypeExact,
+					ServiceAddress: "a", ServicePort: 8080},
+				{Name: "2-prefix", Host: "foo.com", Path: "/static", PathType: controller.PathTypePrefix,
+					ServiceAddress: "a", ServicePort: 8080},
+				{Name: "3-regex", Host: "foo.com", Path: "^/v[0-9]{1,2}/", PathType: controller.PathTypeRegex,
+					ServiceAddress: "a", ServicePort: 8080},
+			},
+			[]string{
+				"        location /api/ {\n",
+				"        location = \"/api\" {\n",
+				"        location ^~ \"/static\" {\n",
+				"        location ~ \"^/v[0-9]{1,2}/\" {\n",
+				"        location ^~ \"/static\" {\n" +
+					"            # Restrict clients\n" +
+					"            allow 127.0.0.1;\n" +
+					"            \n" +
+					"            deny all;\n" +
+					"\n" +
+					"            # Keep the full path when proxying.\n" +
+					"            proxy_pass http://upstream002;\n",
+			},
+			nil,
+		},
+		{
+			"Duplicate locations are ignored",
+			[]controller.IngressEntry{
+				{Name: "0-first", Host: "foo.com", Path: "/api", ServiceAddress: "a", ServicePort: 8080},
+				{Name: "1-duplicate", Host: "foo.com", Path: "/api/", ServiceAddress: "b", ServicePort: 8080},
+				{Name: "2-duplicate-prefix", Host: "foo.com", Path: "/api/", PathType: controller.PathTypePrefix,
+					ServiceAddress: "c", ServicePort: 8080},
+				{Name: "3-other-host", Host: "bar.com", Path: "/api", ServiceAddress: "d", ServicePort: 8080},
+			},
+			[]string{
+				"        server_name bar.com;\n" +
+					"        \n" +
+					"        # Start entry\n" +
+					"        # 3-other-host\n",
+				"        server_name foo.com;\n" +
+					"        \n" +
+					"        # Start entry\n" +
+					"        # 0-first\n",
+			},
+			[]string{"1-duplicate", "2-duplicate-prefix"},
+		},
+	}
+
+	for _, test := range tests {
+		lb, mockSignaller := newLb(tmpDir)
+		mockSignaller.On("sighup", mock.AnythingOfType("*os.Process")).Return(nil)
+
+		assert.NoError(lb.Start())
+		assert.NoError(lb.Update(controller.IngressUpdate{Entries: test.entries}))
+
+		config, err := ioutil.ReadFile(tmpDir + "/nginx.conf")
+		assert.NoError(err)
+		configContents := string(config)
+
+		r := regexp.MustCompile("(?s)# Start server\n(.*?)# End server")
+		servers := strings.Join(flatten(r.FindAllStringSubmatch(configContents, -1)), "")
+		for _, expected := range test.configServers {
+			assert.Contains(servers, expected, test.name)
+		}
+		for _, unexpected := range test.absent {
+			assert.NotContains(configContents, unexpected, test.name)
+		}
+
+		assert.Nil(lb.Stop())
+	}
+}
+
+func TestRateAndConnectionLimits(t *testing.T) {
+	assert := assert.New(t)
+	tmpDir := setupWorkDir(t)
+	defer os.Remove(tmpDir)
+
+	conf := newConf(tmpDir, fakeNginx)
+	conf.MaxConnectionsPerIP = 20
+	conf.RateLimitStatus = 503
+	lb, mockSignaller := newLbWithConf(conf)
+	mockSignaller.On("sighup", mock.AnythingOfType("*os.Process")).Return(nil)
+
+	limited := controller.IngressEntry{Name: "limited", Host: "foo.com", Path: "/", ServiceAddress: "a",
+		ServicePort: 8080, RateLimitRPS: 10, RateLimitBurst: 5}
+	byHeader := controller.IngressEntry{Name: "by-header", Host: "foo.com", Path: "/api/", ServiceAddress: "a",
+		ServicePort: 8080, RateLimitRPS: 100, RateLimitHeader: "X-Api-Key"}
+	unlimited := controller.IngressEntry{Name: "unlimited", Host: "foo.com", Path: "/other", ServiceAddress: "a",
+		ServicePort: 8080}
+
+	assert.NoError(lb.Start())
+	assert.NoError(lb.Update(controller.IngressUpdate{Entries: []controller.IngressEntry{limited, byHeader, unlimited}}))
+
+	config, err := ioutil.ReadFile(tmpDir + "/nginx.conf")
+	assert.NoError(err)
+	configContents := string(config)
+
+	limitedZone := rateLimitZone(limited, "$binary_remote_addr")
+	byHeaderZone := rateLimitZone(byHeader, "$http_x_api_key")
+	assert.NotEqual(limitedZone, byHeaderZone)
+
+	assert.Contains(configContents, "    limit_req_status 503;\n    limit_conn_status 503;\n")
+	assert.Contains(configContents, "    limit_conn_zone $binary_remote_addr zone=connections_per_ip:10m;\n")
+	assert.Contains(configContents, "    limit_req_zone $binary_remote_addr zone="+limitedZone+":10m rate=10r/s;\n")
+	assert.Contains(configContents, "    limit_req_zone $http_x_api_key zone="+byHeaderZone+":10m rate=100r/s;\n")
+	assert.Contains(configContents, "        server_name foo.com;\n"+
+		"\n"+
+		"        # Limit concurrent connections from each client.\n"+
+		"        limit_conn connections_per_ip 20;\n")
+	assert.Contains(configContents, "            deny all;\n"+
+		"\n"+
+		"            # Limit the request rate from each client.\n"+
+		"            limit_req zone="+limitedZone+" burst=5 nodelay;\n"+
+		"\n"+
+		"            # Strip location path when proxying.\n")
+	assert.Contains(configContents, "            limit_req zone="+byHeaderZone+" burst=0 nodelay;\n")
+	assert.Equal(2, strings.Count(configContents, "limit_req zone="))
+
+	assert.NoError(lb.Stop())
+}
+
+func TestRateLimitZoneNamesAreStable(t *testing.T) {
+	assert := assert.New(t)
+	entry := controller.IngressEntry{Name: "ns/ing", Host: "foo.com", Path: "/", RateLimitRPS: 10}
+
+	zone := rateLimitZone(entry, "$binary_remote_addr")
+	entry.RateLimitRPS = 20
+	assert.Equal(zone, rateLimitZone(entry, "$binary_remote_addr"), "rate can change without a new zone")
+	assert.NotEqual(zone, rateLimitZone(entry, "$http_x_api_key"), "key can't change without a new zone")
+	entry.Path = "/other"
+	assert.NotEqual(zone, rateLimitZone(entry, "$binary_remote_addr"))
+}
+
+func flatten(matches [][]string) []string {
+	var flattened []string
+	for _, match := range matches {
+		flattened = append(flattened, match[1])
+	}
+	return flattened
+}
+
 func TestDoesNotUpdateIfConfigurationHasNotChanged(t *testing.T) {
 	assert := assert.New(t)
 	tmpDir := setupWorkDir(t)
@@ -664,3 +864,371 @@ func setupWorkDir(t *testing.T) string {
 func copyNginxTemplate(t *testing.T, tmpDir string) {
 	assert.NoError(t, exec.Command("cp", "nginx.tmpl", tmpDir+"/").Run())
 }
+
+func TestBasicAuthFilesAreWrittenAndReplaced(t *testing.T) {
+	assert := assert.New(t)
+	tmpDir := setupWorkDir(t)
+	defer os.RemoveAll(tmpDir)
+
+	lb, mockSignaller := newLb(tmpDir)
+	mockSignaller.On("sighup", mock.AnythingOfType("*os.Process")).Return(nil)
+
+	entry := controller.IngressEntry{Name: "secured", Host: "foo.com", Path: "/", ServiceAddress: "a",
+		ServicePort: 8080, BasicAuth: &controller.BasicAuth{Secret: "ns/htpasswd", Htpasswd: []byte("user:old")}}
+	open := controller.IngressEntry{Name: "open", Host: "foo.com", Path: "/open", ServiceAddress: "a",
+		ServicePort: 8080}
+
+	assert.NoError(lb.Start())
+	assert.NoError(lb.Update(controller.IngressUpdate{Entries: []controller.IngressEntry{entry, open}}))
+
+	oldFile := lb.(*nginxLoadBalancer).secretFile("ns/htpasswd", "auth", entry.BasicAuth.Htpasswd)
+	assert.True(strings.HasPrefix(oldFile, tmpDir+"/secrets/ns_htpasswd-auth-"), oldFile)
+	contents, err := ioutil.ReadFile(oldFile)
+	assert.NoError(err)
+	assert.Equal("user:old", string(contents))
+
+	config, err := ioutil.ReadFile(tmpDir + "/nginx.conf")
+	assert.NoError(err)
+	assert.Contains(string(config), "            deny all;\n"+
+		"\n"+
+		"            # Require basic authentication, as well as an allowed client address.\n"+
+		"            auth_basic \"Restricted\";\n"+
+		"            auth_basic_user_file \""+oldFile+"\";\n"+
+		"\n"+
+		"            # Strip location path when proxying.\n")
+	assert.Equal(1, strings.Count(string(config), "auth_basic_user_file"))
+
+	entry.BasicAuth = &controller.BasicAuth{Secret: "ns/htpasswd", Htpasswd: []byte("user:new")}
+	assert.NoError(lb.Update(controller.IngressUpdate{Entries: []controller.IngressEntry{entry, open}}))
+
+	newFile := lb.(*nginxLoadBalancer).secretFile("ns/htpasswd", "auth", entry.BasicAuth.Htpasswd)
+	assert.NotEqual(oldFile, newFile)
+	config, err = ioutil.ReadFile(tmpDir + "/nginx.conf")
+	assert.NoError(err)
+	assert.Contains(string(config), "auth_basic_user_file \""+newFile+"\";")
+	_, err = os.Stat(oldFile)
+	assert.True(os.IsNotExist(err), "stale auth file should be removed")
+	mockSignaller.AssertNumberOfCalls(t, "sighup", 2)
+
+	assert.NoError(lb.Stop())
+}
+
+func TestEntriesWithUnreadBasicAuthSecretsAreIgnored(t *testing.T) {
+	assert := assert.New(t)
+	tmpDir := setupWorkDir(t)
+	defer os.RemoveAll(tmpDir)
+
+	lb, mockSignaller := newLb(tmpDir)
+	mockSignaller.On("sighup", mock.AnythingOfType("*os.Process")).Return(nil)
+
+	unread := controller.IngressEntry{Name: "secured", Host: "foo.com", Path: "/", ServiceAddress: "a",
+		ServicePort: 8080, BasicAuth: &controller.BasicAuth{Secret: "ns/htpasswd"}}
+	open := controller.IngressEntry{Name: "open", Host: "foo.com", Path: "/open", ServiceAddress: "a",
+		ServicePort: 8080}
+
+	assert.NoError(lb.Start())
+	assert.NoError(lb.Update(controller.IngressUpdate{Entries: []controller.IngressEntry{unread, open}}))
+
+	config, err := ioutil.ReadFile(tmpDir + "/nginx.conf")
+	assert.NoError(err)
+	assert.Contains(string(config), "location /open/ {")
+	assert.NotContains(string(config), "# secured")
+	assert.NotContains(string(config), "auth_basic")
+
+	assert.NoError(lb.Stop())
+}
+
+func TestEntriesWithUnreadBackendTLSSecretsAreIgnored(t *testing.T) {
+	assert := assert.New(t)
+	tmpDir := setupWorkDir(t)
+	defer os.RemoveAll(tmpDir)
+
+	lb, mockSignaller := newLb(tmpDir)
+	mockSignaller.On("sighup", mock.AnythingOfType("*os.Process")).Return(nil)
+
+	unread := controller.IngressEntry{Name: "verified", Host: "foo.com", Path: "/", ServiceAddress: "a",
+		ServicePort: 8443, BackendProtocol: controller.BackendProtocolHTTPS,
+		BackendTLS: &controller.BackendTLS{ServerName: "a.ns.svc", Secret: "ns/backend-ca"}}
+	unverified := controller.IngressEntry{Name: "unverified", Host: "foo.com", Path: "/unverified",
+		ServiceAddress: "a", ServicePort: 8443, BackendProtocol: controller.BackendProtocolHTTPS,
+		BackendTLS: &controller.BackendTLS{ServerName: "a.ns.svc"}}
+
+	assert.NoError(lb.Start())
+	assert.NoError(lb.Update(controller.IngressUpdate{Entries: []controller.IngressEntry{unread, unverified}}))
+
+	config, err := ioutil.ReadFile(tmpDir + "/nginx.conf")
+	assert.NoError(err)
+	assert.Contains(string(config), "# unverified")
+	assert.NotContains(string(config), "# verified")
+	assert.NotContains(string(config), "proxy_ssl_trusted_certificate")
+
+	assert.NoError(lb.Stop())
+}
+
+func TestExternalAuth(t *testing.T) {
+	assert := assert.New(t)
+	tmpDir := setupWorkDir(t)
+	defer os.Remove(tmpDir)
+
+	conf := newConf(tmpDir, fakeNginx)
+	conf.Resolvers = []string{"10.0.0.2", "[fd00::2]"}
+	lb, mockSignaller := newLbWithConf(conf)
+	mockSignaller.On("sighup", mock.AnythingOfType("*os.Process")).Return(nil)
+
+	entry := controller.IngressEntry{Name: "sso", Host: "foo.com", Path: "/", ServiceAddress: "a",
+		ServicePort: 8080, ExternalAuth: &controller.ExternalAuth{
+			URL:             "https://sso.sky.com/auth",
+			SigninURL:       "https://sso.sky.com/signin?app=foo",
+			ResponseHeaders: []string{"X-User", "X-Auth-Groups"},
+		}}
+	unauthenticated := controller.IngressEntry{Name: "api", Host: "foo.com", Path: "/api", ServiceAddress: "a",
+		ServicePort: 8080, ExternalAuth: &controller.ExternalAuth{URL: "http://auth.svc/"}}
+
+	assert.NoError(lb.Start())
+	assert.NoError(lb.Update(controller.IngressUpdate{Entries: []controller.IngressEntry{entry, unauthenticated}}))
+
+	config, err := ioutil.ReadFile(tmpDir + "/nginx.conf")
+	assert.NoError(err)
+	configContents := string(config)
+
+	assert.Contains(configContents, "    resolver 10.0.0.2 [fd00::2];\n")
+	assert.Contains(configContents, "    map $http_x_forwarded_proto $original_scheme {\n")
+	assert.Contains(configContents, "            deny all;\n"+
+		"\n"+
+		"            # Require the external auth service to allow the request.\n"+
+		"            auth_request /_external_auth_upstream001;\n"+
+		"            auth_request_set $auth_x_user $upstream_http_x_user;\n"+
+		"            auth_request_set $auth_x_auth_groups $upstream_http_x_auth_groups;\n"+
+		"            error_page 401 = @external_auth_signin_upstream001;\n"+
+		"\n"+
+		"            # Strip location path when proxying.\n")
+	assert.Contains(configContents, "            proxy_set_header X-Original-URI $request_uri;\n"+
+		"\n"+
+		"            # Pass headers from the external auth response.\n"+
+		"            proxy_set_header X-User $auth_x_user;\n"+
+		"            proxy_set_header X-Auth-Groups $auth_x_auth_groups;\n"+
+		"\n")
+	assert.Contains(configContents, "            proxy_request_buffering off;\n"+
+		"        }\n"+
+		"\n"+
+		"        location = /_external_auth_upstream001 {\n"+
+		"            internal;\n")
+	assert.Contains(configContents, "            set $external_auth_url \"https://sso.sky.com/auth\";\n"+
+		"            proxy_pass $external_auth_url;\n"+
+		"        }\n"+
+		"\n"+
+		"        location @external_auth_signin_upstream001 {\n"+
+		"            # Send unauthenticated users to sign in.\n"+
+		"            return 302 \"https://sso.sky.com/signin?app=foo&rd=$original_scheme://$host$request_uri\";\n"+
+		"        }\n"+
+		"        # End entry\n")
+
+	assert.Contains(configContents, "            auth_request /_external_auth_upstream000;\n"+
+		"\n"+
+		"            # Strip location path when proxying.\n")
+	assert.Contains(configContents, "            set $external_auth_url \"http://auth.svc/\";\n"+
+		"            proxy_pass $external_auth_url;\n"+
+		"        }\n"+
+		"        # End entry\n")
+	assert.Equal(1, strings.Count(configContents, "error_page 401"))
+
+	assert.NoError(lb.Stop())
+}
+
+func TestExternalAuthByHostIsIgnoredWithoutResolvers(t *testing.T) {
+	assert := assert.New(t)
+	tmpDir := setupWorkDir(t)
+	defer os.RemoveAll(tmpDir)
+
+	lb, mockSignaller := newLb(tmpDir)
+	mockSignaller.On("sighup", mock.AnythingOfType("*os.Process")).Return(nil)
+
+	byHost := controller.IngressEntry{Name: "by-host", Host: "foo.com", Path: "/", ServiceAddress: "a",
+		ServicePort: 8080, ExternalAuth: &controller.ExternalAuth{URL: "http://auth.svc:8080/"}}
+	byIP := controller.IngressEntry{Name: "by-ip", Host: "foo.com", Path: "/ip", ServiceAddress: "a",
+		ServicePort: 8080, ExternalAuth: &controller.ExternalAuth{URL: "http://[2001:db8::1]:8080/"}}
+
+	assert.NoError(lb.Start())
+	assert.NoError(lb.Update(controller.IngressUpdate{Entries: []controller.IngressEntry{byHost, byIP}}))
+
+	config, err := ioutil.ReadFile(tmpDir + "/nginx.conf")
+	assert.NoError(err)
+	assert.NotContains(string(config), "    resolver ")
+	assert.NotContains(string(config), "# by-host")
+	assert.Contains(string(config), "set $external_auth_url \"http://[2001:db8::1]:8080/\";")
+
+	assert.NoError(lb.Stop())
+}
+
+func TestWebSocketUpgradesAreForwarded(t *testing.T) {
+	assert := assert.New(t)
+	tmpDir := setupWorkDir(t)
+	defer os.Remove(tmpDir)
+
+	lb, mockSignaller := newLb(tmpDir)
+	mockSignaller.On("sighup", mock.AnythingOfType("*os.Process")).Return(nil)
+
+	webSocket := controller.IngressEntry{Name: "a-websocket", Host: "foo.com", Path: "/ws", ServiceAddress: "a",
+		ServicePort: 8080, WebSocket: true}
+	shortWebSocket := controller.IngressEntry{Name: "b-websocket", Host: "foo.com", Path: "/short", ServiceAddress: "a",
+		ServicePort: 8080, WebSocket: true, ProxyReadTimeoutSeconds: 30}
+	plain := controller.IngressEntry{Name: "c-plain", Host: "foo.com", Path: "/", ServiceAddress: "a",
+		ServicePort: 8080}
+
+	assert.NoError(lb.Start())
+	assert.NoError(lb.Update(controller.IngressUpdate{Entries: []controller.IngressEntry{webSocket, shortWebSocket, plain}}))
+
+	config, err := ioutil.ReadFile(tmpDir + "/nginx.conf")
+	assert.NoError(err)
+	configContents := string(config)
+
+	assert.Contains(configContents, "    map $http_upgrade $connection_upgrade {\n"+
+		"        default upgrade;\n"+
+		"        \"\"      \"\";\n"+
+		"    }\n")
+	assert.Contains(configContents, "        # a-websocket\n"+
+		"        location /ws/ {")
+	assert.Contains(configContents, "            proxy_http_version 1.1;\n"+
+		"\n"+
+		"            # Forward WebSocket and other upgrade requests.\n"+
+		"            proxy_set_header Upgrade $http_upgrade;\n"+
+		"            proxy_set_header Connection $connection_upgrade;\n")
+	assert.Equal(2, strings.Count(configContents, "proxy_set_header Connection $connection_upgrade;"))
+	assert.Equal(1, strings.Count(configContents, "proxy_set_header Connection \"\";"))
+	assert.Contains(configContents, "            proxy_read_timeout 3600s;\n            proxy_send_timeout 3600s;\n")
+	assert.Contains(configContents, "            proxy_read_timeout 30s;\n            proxy_send_timeout 3600s;\n")
+	assert.Contains(configContents, "            proxy_read_timeout 58s;\n            proxy_send_timeout 58s;\n")
+
+	assert.NoError(lb.Stop())
+}
+
+func TestBackendProtocols(t *testing.T) {
+	assert := assert.New(t)
+	tmpDir := setupWorkDir(t)
+	defer os.Remove(tmpDir)
+
+	conf := newConf(tmpDir, fakeNginx)
+	conf.HTTP2 = true
+	lb, mockSignaller := newLbWithConf(conf)
+	mockSignaller.On("sighup", mock.AnythingOfType("*os.Process")).Return(nil)
+
+	grpc := controller.IngressEntry{Name: "a-grpc", Host: "grpc.com", Path: "/pkg.Service/", ServiceAddress: "a",
+		ServicePort: 8080, BackendProtocol: controller.BackendProtocolGRPC}
+	grpcs := controller.IngressEntry{Name: "b-grpcs", Host: "grpc.com", Path: "/pkg.Secure/", ServiceAddress: "a",
+		ServicePort: 8443, BackendProtocol: controller.BackendProtocolGRPCS, ProxyReadTimeoutSeconds: 300}
+	https := controller.IngressEntry{Name: "c-https", Host: "foo.com", Path: "/", ServiceAddress: "a",
+		ServicePort: 8443, BackendProtocol: controller.BackendProtocolHTTPS}
+
+	assert.NoError(lb.Start())
+	assert.NoError(lb.Update(controller.IngressUpdate{Entries: []controller.IngressEntry{grpc, grpcs, https}}))
+
+	config, err := ioutil.ReadFile(tmpDir + "/nginx.conf")
+	assert.NoError(err)
+	configContents := string(config)
+
+	assert.Contains(configContents, "        listen 9090 http2;\n        server_name grpc.com;\n")
+	assert.Contains(configContents, "        listen 9090 default_server http2;\n")
+	assert.Contains(configContents, "            deny all;\n"+
+		"\n"+
+		"            # Proxy gRPC over HTTP/2.\n"+
+		"            grpc_pass grpc://upstream000;\n"+
+		"\n"+
+		"            # Add X-Forwarded-For and X-Original-URI for proxy information.\n"+
+		"            grpc_set_header X-Forwarded-For $proxy_add_x_forwarded_for;\n"+
+		"            grpc_set_header X-Original-URI $request_uri;\n"+
+		"\n"+
+		"            # Timeout faster than the default 60s on initial connect.\n"+
+		"            grpc_connect_timeout 10s;\n"+
+		"\n"+
+		"            # Close proxy connections after backend keepalive time, unless overridden by the ingress.\n"+
+		"            grpc_read_timeout 58s;\n"+
+		"            grpc_send_timeout 58s;\n"+
+		"\n"+
+		"            # Return gRPC statuses instead of HTTP errors, which gRPC clients don't understand.\n"+
+		"            error_page 400 500 = @grpc_internal;\n"+
+		"            error_page 401 = @grpc_unauthenticated;\n")
+	assert.Contains(configContents, "            grpc_pass grpcs://upstream001;\n")
+	assert.Contains(configContents, "            grpc_read_timeout 300s;\n")
+	assert.Contains(configContents, "            error_page 502 503 = @grpc_unavailable;\n"+
+		"            error_page 504 = @grpc_deadline_exceeded;\n"+
+		"        }\n"+
+		"        # End entry\n")
+	assert.Contains(configContents, "        # End entry\n"+
+		"        \n"+
+		"        location @grpc_internal {\n"+
+		"            default_type application/grpc;\n"+
+		"            add_header grpc-status 13;\n"+
+		"            add_header grpc-message \"internal\";\n"+
+		"            return 204;\n"+
+		"        }\n")
+	assert.Equal(1, strings.Count(configContents, "location @grpc_unavailable {"), "one set of statuses per server")
+	assert.Equal(2, strings.Count(configContents, "error_page 502 503 = @grpc_unavailable;"))
+	assert.NotContains(configContents, "proxy_pass http://upstream00")
+
+	assert.Contains(configContents, "            # Strip location path when proxying.\n"+
+		"            proxy_pass https://upstream002/;\n")
+
+	assert.NoError(lb.Stop())
+}
+
+func TestBackendTLS(t *testing.T) {
+	assert := assert.New(t)
+	tmpDir := setupWorkDir(t)
+	defer os.RemoveAll(tmpDir)
+
+	lb, mockSignaller := newLb(tmpDir)
+	mockSignaller.On("sighup", mock.AnythingOfType("*os.Process")).Return(nil)
+
+	verified := controller.IngressEntry{Name: "a-verified", Host: "foo.com", Path: "/", ServiceAddress: "a",
+		ServicePort: 8443, BackendProtocol: controller.BackendProtocolHTTPS, BackendTLS: &controller.BackendTLS{
+			ServerName: "svc.ns.svc", Secret: "ns/backend", CA: []byte("ca"), Certificate: []byte("cert"),
+			Key: []byte("key")}}
+	sniOnly := controller.IngressEntry{Name: "b-sni", Host: "foo.com", Path: "/sni", ServiceAddress: "a",
+		ServicePort: 8443, BackendProtocol: controller.BackendProtocolHTTPS,
+		BackendTLS: &controller.BackendTLS{ServerName: "backend.sky.com"}}
+	grpcs := controller.IngressEntry{Name: "c-grpcs", Host: "foo.com", Path: "/pkg.Service/", ServiceAddress: "a",
+		ServicePort: 8443, BackendProtocol: controller.BackendProtocolGRPCS, BackendTLS: &controller.BackendTLS{
+			ServerName: "grpc.ns.svc", Secret: "ns/grpc", CA: []byte("grpc ca")}}
+
+	assert.NoError(lb.Start())
+	assert.NoError(lb.Update(controller.IngressUpdate{Entries: []controller.IngressEntry{verified, sniOnly, grpcs}}))
+
+	config, err := ioutil.ReadFile(tmpDir + "/nginx.conf")
+	assert.NoError(err)
+	configContents := string(config)
+
+	nginx := lb.(*nginxLoadBalancer)
+	ca := nginx.secretFile("ns/backend", "ca.crt", []byte("ca"))
+	cert := nginx.secretFile("ns/backend", "tls.crt", []byte("cert"))
+	key := nginx.secretFile("ns/backend", "tls.key", []byte("key"))
+	grpcCA := nginx.secretFile("ns/grpc", "ca.crt", []byte("grpc ca"))
+
+	assert.Contains(configContents, "            proxy_send_timeout 58s;\n"+
+		"\n"+
+		"            # Connect to the backend with TLS, sending the server name with SNI.\n"+
+		"            proxy_ssl_server_name on;\n"+
+		"            proxy_ssl_name svc.ns.svc;\n"+
+		"            proxy_ssl_verify on;\n"+
+		"            proxy_ssl_trusted_certificate \""+ca+"\";\n"+
+		"            proxy_ssl_certificate \""+cert+"\";\n"+
+		"            proxy_ssl_certificate_key \""+key+"\";\n"+
+		"\n")
+	assert.Contains(configContents, "            proxy_ssl_name backend.sky.com;\n"+
+		"\n")
+	assert.Contains(configContents, "            grpc_ssl_name grpc.ns.svc;\n"+
+		"            grpc_ssl_verify on;\n"+
+		"            grpc_ssl_trusted_certificate \""+grpcCA+"\";\n"+
+		"\n")
+	assert.Equal(2, strings.Count(configContents, "_ssl_verify on;"))
+
+	for file, contents := range map[string]string{ca: "ca", cert: "cert", key: "key", grpcCA: "grpc ca"} {
+		written, err := ioutil.ReadFile(file)
+		assert.NoError(err)
+		assert.Equal(contents, string(written))
+	}
+	info, err := os.Stat(key)
+	assert.NoError(err)
+	assert.Equal(os.FileMode(0600), info.Mode().Perm(), "keys shouldn't be readable by other users")
+
+	assert.NoError(lb.Stop())
+}
@@ -0,0 +1,166 @@
+/*
+Package status publishes the frontend addresses of feed-ingress in the status of each ingress, so that
+feed-dns and kubectl can see where an ingress is reachable.
+*/
+package status
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	aws_elb "github.com/aws/aws-sdk-go/service/elb"
+	"github.com/sky-uk/feed/controller"
+	"github.com/sky-uk/feed/elb"
+	"github.com/sky-uk/feed/k8s"
+)
+
+// Config for the status updater.
+type Config struct {
+	KubernetesClient k8s.Client
+	// Frontends are the frontend hostnames or IPs, keyed by ELB scheme (internal or internet-facing).
+	Frontends map[string][]string
+	// ElbRegion is the AWS region of the front end ELBs.
+	ElbRegion string
+	// ElbLabelValue is the value of the elb.ElbTag tag identifying the front end ELBs, whose DNS names
+	// are used for schemes not in Frontends. Leave empty to not look up ELBs.
+	ElbLabelValue string
+}
+
+type updater struct {
+	client        k8s.Client
+	frontends     map[string][]string
+	elbLabelValue string
+	awsElb        elb.ELB
+	findElbs      func(elb.ELB, string) (map[string]elb.LoadBalancerDetails, error)
+	lock          sync.Mutex
+	statuses      map[string][]k8s.LoadBalancerIngress
+}
+
+// New creates an updater that writes the frontend addresses into Ingress.Status.LoadBalancer.
+func New(conf Config) controller.Updater {
+	return &updater{
+		client:        conf.KubernetesClient,
+		frontends:     conf.Frontends,
+		elbLabelValue: conf.ElbLabelValue,
+		awsElb:        aws_elb.New(session.New(&aws.Config{Region: &conf.ElbRegion})),
+		findElbs:      elb.FindFrontEndElbs,
+	}
+}
+
+func (u *updater) Start() error {
+	statuses := make(map[string][]k8s.LoadBalancerIngress)
+
+	if u.elbLabelValue != "" {
+		elbs, err := u.findElbs(u.awsElb, u.elbLabelValue)
+		if err != nil {
+			return fmt.Errorf("unable to find front end ELBs: %v", err)
+		}
+		for scheme, lb := range elbs {
+			statuses[scheme] = loadBalancerIngresses([]string{lb.DNSName})
+		}
+	}
+
+	for scheme, addresses := range u.frontends {
+		statuses[scheme] = loadBalancerIngresses(addresses)
+	}
+
+	if len(statuses) == 0 {
+		return fmt.Errorf("no frontend addresses found to publish in ingress status")
+	}
+
+	for scheme, status := range statuses {
+		log.Infof("Publishing %v in the status of %s ingresses", status, scheme)
+	}
+
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.statuses = statuses
+	return nil
+}
+
+func loadBalancerIngresses(addresses []string) []k8s.LoadBalancerIngress {
+	var ingresses []k8s.LoadBalancerIngress
+	for _, address := range addresses {
+		if net.ParseIP(address) != nil {
+			ingresses = append(ingresses, k8s.LoadBalancerIngress{IP: address})
+		} else {
+			ingresses = append(ingresses, k8s.LoadBalancerIngress{Hostname: address})
+		}
+	}
+	return ingresses
+}
+
+func (u *updater) Stop() error {
+	return nil
+}
+
+func (u *updater) Health() error {
+	return nil
+}
+
+// Update sets the status of each ingress that doesn't already have the frontend addresses of its scheme.
+func (u *updater) Update(update controller.IngressUpdate) error {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	updated := make(map[string]bool)
+	var failed []string
+
+	for _, entry := range update.Entries {
+		if updated[entry.Name] {
+			continue
+		}
+		updated[entry.Name] = true
+
+		status, ok := u.statuses[entry.ELbScheme]
+		if !ok {
+			log.Debugf("No frontend for scheme %q, not updating status of %s", entry.ELbScheme, entry.Name)
+			continue
+		}
+		if statusMatches(status, entry.LoadBalancerAddresses) {
+			continue
+		}
+
+		namespaceAndName := strings.SplitN(entry.Name, "/", 2)
+		if len(namespaceAndName) != 2 {
+			log.Warnf("Unable to update status of %s, as it isn't a namespace/name", entry.Name)
+			continue
+		}
+
+		ingress := k8s.Ingress{
+			ObjectMeta: k8s.ObjectMeta{Namespace: namespaceAndName[0], Name: namespaceAndName[1]},
+			Status:     k8s.IngressStatus{LoadBalancer: k8s.LoadBalancerStatus{Ingress: status}},
+		}
+		log.Infof("Updating status of %s to %v", entry.Name, status)
+		if err := u.client.UpdateIngressStatus(ingress); err != nil {
+			log.Warnf("Unable to update status of %s: %v", entry.Name, err)
+			failed = append(failed, entry.Name)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("unable to update status of ingresses %v", failed)
+	}
+	return nil
+}
+
+func statusMatches(status []k8s.LoadBalancerIngress, addresses []string) bool {
+	if len(status) != len(addresses) {
+		return false
+	}
+	for i, ingress := range status {
+		if ingress.IP+ingress.Hostname != addresses[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (u *updater) String() string {
+	return "ingress status updater"
+}
@@ -0,0 +1,125 @@
+package status
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sky-uk/feed/controller"
+	"github.com/sky-uk/feed/elb"
+	"github.com/sky-uk/feed/k8s"
+	fake "github.com/sky-uk/feed/util/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+const (
+	internalElb = "internal-elb.eu-west-1.elb.amazonaws.com"
+	externalIP  = "192.0.2.10"
+)
+
+func newUpdater(client k8s.Client, frontends map[string][]string, elbs map[string]elb.LoadBalancerDetails) *updater {
+	u := New(Config{
+		KubernetesClient: client,
+		Frontends:        frontends,
+		ElbRegion:        "eu-west-1",
+	}).(*updater)
+	if elbs != nil {
+		u.elbLabelValue = "cluster"
+		u.findElbs = func(elb.ELB, string) (map[string]elb.LoadBalancerDetails, error) {
+			return elbs, nil
+		}
+	}
+	return u
+}
+
+func ingressWithStatus(namespace, name string, lbs ...k8s.LoadBalancerIngress) k8s.Ingress {
+	return k8s.Ingress{
+		ObjectMeta: k8s.ObjectMeta{Namespace: namespace, Name: name},
+		Status:     k8s.IngressStatus{LoadBalancer: k8s.LoadBalancerStatus{Ingress: lbs}},
+	}
+}
+
+func TestUpdatesStatusOfIngressesToFrontendOfTheirScheme(t *testing.T) {
+	assert := assert.New(t)
+	client := new(fake.FakeClient)
+	u := newUpdater(client,
+		map[string][]string{"internet-facing": {externalIP}},
+		map[string]elb.LoadBalancerDetails{"internal": {DNSName: internalElb}})
+
+	client.On("UpdateIngressStatus", ingressWithStatus("ns", "internal-ing", k8s.LoadBalancerIngress{Hostname: internalElb})).Return(nil)
+	client.On("UpdateIngressStatus", ingressWithStatus("ns", "external-ing", k8s.LoadBalancerIngress{IP: externalIP})).Return(nil)
+
+	assert.NoError(u.Start())
+	assert.NoError(u.Update(controller.IngressUpdate{Entries: []controller.IngressEntry{
+		{Name: "ns/internal-ing", Path: "/a", ELbScheme: "internal"},
+		{Name: "ns/internal-ing", Path: "/b", ELbScheme: "internal"},
+		{Name: "ns/external-ing", ELbScheme: "internet-facing"},
+		{Name: "ns/other-ing", ELbScheme: "unknown"},
+	}}))
+
+	client.AssertExpectations(t)
+	client.AssertNumberOfCalls(t, "UpdateIngressStatus", 2)
+}
+
+func TestStaticFrontendsOverrideElbs(t *testing.T) {
+	assert := assert.New(t)
+	client := new(fake.FakeClient)
+	u := newUpdater(client,
+		map[string][]string{"internal": {"ingress.example.com"}},
+		map[string]elb.LoadBalancerDetails{"internal": {DNSName: internalElb}})
+
+	client.On("UpdateIngressStatus", ingressWithStatus("ns", "ing", k8s.LoadBalancerIngress{Hostname: "ingress.example.com"})).Return(nil)
+
+	assert.NoError(u.Start())
+	assert.NoError(u.Update(controller.IngressUpdate{Entries: []controller.IngressEntry{
+		{Name: "ns/ing", ELbScheme: "internal"},
+	}}))
+
+	client.AssertExpectations(t)
+}
+
+func TestDoesNotUpdateUnchangedStatus(t *testing.T) {
+	assert := assert.New(t)
+	client := new(fake.FakeClient)
+	u := newUpdater(client, map[string][]string{"internal": {internalElb}}, nil)
+
+	assert.NoError(u.Start())
+	assert.NoError(u.Update(controller.IngressUpdate{Entries: []controller.IngressEntry{
+		{Name: "ns/ing", ELbScheme: "internal", LoadBalancerAddresses: []string{internalElb}},
+	}}))
+
+	client.AssertNotCalled(t, "UpdateIngressStatus", mock.Anything)
+}
+
+func TestUpdateContinuesAfterFailureAndReturnsError(t *testing.T) {
+	assert := assert.New(t)
+	client := new(fake.FakeClient)
+	u := newUpdater(client, map[string][]string{"internal": {internalElb}}, nil)
+
+	lb := k8s.LoadBalancerIngress{Hostname: internalElb}
+	client.On("UpdateIngressStatus", ingressWithStatus("ns", "bad", lb)).Return(errors.New("conflict"))
+	client.On("UpdateIngressStatus", ingressWithStatus("ns", "good", lb)).Return(nil)
+
+	assert.NoError(u.Start())
+	err := u.Update(controller.IngressUpdate{Entries: []controller.IngressEntry{
+		{Name: "ns/bad", ELbScheme: "internal"},
+		{Name: "ns/good", ELbScheme: "internal"},
+	}})
+
+	assert.Error(err)
+	client.AssertExpectations(t)
+}
+
+func TestStartFailsWithoutFrontends(t *testing.T) {
+	u := newUpdater(new(fake.FakeClient), nil, map[string]elb.LoadBalancerDetails{})
+	assert.Error(t, u.Start())
+}
+
+func TestStartFailsIfElbLookupFails(t *testing.T) {
+	u := newUpdater(new(fake.FakeClient), nil, nil)
+	u.elbLabelValue = "cluster"
+	u.findElbs = func(elb.ELB, string) (map[string]elb.LoadBalancerDetails, error) {
+		return nil, errors.New("access denied")
+	}
+	assert.Error(t, u.Start())
+}
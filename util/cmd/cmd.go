@@ -24,6 +24,12 @@ type Pulse interface {
 	Stop() error
 }
 
+// StatusReporter is optionally implemented by a Pulse, to report its status in the health check.
+type StatusReporter interface {
+	// Status returns a single line describing the current status.
+	Status() string
+}
+
 // AddHealthPort is used to expose the health over http.
 func AddHealthPort(pulse Pulse, healthPort int) {
 	http.HandleFunc("/health", healthHandler(pulse))
@@ -41,11 +47,14 @@ func healthHandler(pulse Pulse) func(w http.ResponseWriter, r *http.Request) {
 		if err := pulse.Health(); err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			io.WriteString(w, fmt.Sprintf("%v\n", err))
-			return
+		} else {
+			w.WriteHeader(http.StatusOK)
+			io.WriteString(w, "ok\n")
 		}
 
-		w.WriteHeader(http.StatusOK)
-		io.WriteString(w, "ok\n")
+		if reporter, ok := pulse.(StatusReporter); ok {
+			io.WriteString(w, reporter.Status()+"\n")
+		}
 	}
 }
 
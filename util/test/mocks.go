@@ -34,6 +34,42 @@ func (c *FakeClient) WatchServices() k8s.Watcher {
 	return r.Get(0).(k8s.Watcher)
 }
 
+// UpdateIngressStatus mocks out calls to UpdateIngressStatus
+func (c *FakeClient) UpdateIngressStatus(ingress k8s.Ingress) error {
+	r := c.Called(ingress)
+	return r.Error(0)
+}
+
+// GetSecret mocks out calls to GetSecret
+func (c *FakeClient) GetSecret(namespace, name string) (*k8s.Secret, error) {
+	r := c.Called(namespace, name)
+	if r.Get(0) == nil {
+		return nil, r.Error(1)
+	}
+	return r.Get(0).(*k8s.Secret), r.Error(1)
+}
+
+// GetConfigMap mocks out calls to GetConfigMap
+func (c *FakeClient) GetConfigMap(namespace, name string) (*k8s.ConfigMap, error) {
+	r := c.Called(namespace, name)
+	if r.Get(0) == nil {
+		return nil, r.Error(1)
+	}
+	return r.Get(0).(*k8s.ConfigMap), r.Error(1)
+}
+
+// CreateConfigMap mocks out calls to CreateConfigMap
+func (c *FakeClient) CreateConfigMap(configMap *k8s.ConfigMap) error {
+	r := c.Called(configMap)
+	return r.Error(0)
+}
+
+// UpdateConfigMap mocks out calls to UpdateConfigMap
+func (c *FakeClient) UpdateConfigMap(configMap *k8s.ConfigMap) error {
+	r := c.Called(configMap)
+	return r.Error(0)
+}
+
 func (c *FakeClient) String() string {
 	return "FakeClient"
 }